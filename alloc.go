@@ -0,0 +1,162 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// The lengths in the headers of the stream are not trusted when allocating. At most allocChunk
+// bytes are allocated for a declared length at first, and the slice (or buffer) grows while the
+// elements are actually decoded, so that a few bytes of hostile headers could not cause a huge
+// allocation. Options.MaxAlloc limits the total bytes allocated when decoding one value.
+const allocChunk = 64 << 10
+
+// allocator counts the bytes allocated when decoding a value, see Options.MaxAlloc
+type allocator interface {
+	alloc(size int) error
+}
+
+// allocate counts size bytes allocated by the decoding of stream, if it supports
+func allocate(stream interface{}, size int) error {
+	if a, ok := stream.(allocator); ok {
+		return a.alloc(size)
+	}
+	return nil
+}
+
+// initialCap returns the capacity allocated at first for length elements of elemSize bytes
+func initialCap(length int, elemSize uintptr) int {
+	if elemSize == 0 {
+		return length
+	}
+	if n := int(allocChunk / elemSize); length > n {
+		if n < 1 {
+			return 1
+		}
+		return n
+	}
+	return length
+}
+
+// makeSlice sets value (a slice) with a new slice of length and capacity c
+func makeSlice(stream interface{}, value reflect.Value, length, c int) error {
+	if err := allocate(stream, c*int(value.Type().Elem().Size())); err != nil {
+		return err
+	}
+	newv := reflect.MakeSlice(value.Type(), length, c)
+	if length > 0 && value.Len() > 0 {
+		reflect.Copy(newv, value)
+	}
+	value.Set(newv)
+	return nil
+}
+
+// allocSlice prepares value (a slice) for decoding length elements. The capacity of value is
+// reused if it's enough, otherwise a slice of initialCap is created, which grows by growSlice.
+func allocSlice(stream interface{}, length int, value reflect.Value) error {
+	if err := optionsOf(stream).checkLength(length); err != nil {
+		return err
+	}
+	if length <= value.Cap() {
+		value.SetLen(length)
+		return nil
+	}
+	return makeSlice(stream, value, 0, initialCap(length, value.Type().Elem().Size()))
+}
+
+// growSlice makes the element at index i available in value (a slice prepared by allocSlice
+// for length elements), the capacity is doubled if it's not enough.
+func growSlice(stream interface{}, value reflect.Value, i, length int) error {
+	if i < value.Len() {
+		return nil
+	}
+	if i >= value.Cap() {
+		c := value.Cap() * 2
+		if c <= i {
+			c = i + 1
+		}
+		if c > length {
+			c = length
+		}
+		if err := makeSlice(stream, value, value.Len(), c); err != nil {
+			return err
+		}
+	}
+	value.SetLen(i + 1)
+	return nil
+}
+
+// makeMap sets value (a map) with a new map for length entries if it's nil, the size hint is
+// limited as same as initialCap.
+func makeMap(stream interface{}, value reflect.Value, length int) error {
+	if err := optionsOf(stream).checkLength(length); err != nil {
+		return err
+	}
+	if value.IsNil() {
+		typ := value.Type()
+		value.Set(reflect.MakeMapWithSize(typ, initialCap(length, typ.Key().Size()+typ.Elem().Size())))
+	}
+	return nil
+}
+
+// allocEntry counts the bytes allocated for an entry put into value (a map)
+func allocEntry(stream interface{}, value reflect.Value) error {
+	typ := value.Type()
+	return allocate(stream, int(typ.Key().Size()+typ.Elem().Size()))
+}
+
+// readGrowing reads length bytes from r into a buffer growing with the bytes read, instead of
+// allocating length bytes at once
+func readGrowing(stream interface{}, r io.Reader, length int) ([]byte, error) {
+	var buf []byte
+	for len(buf) < length {
+		c := cap(buf) * 2
+		if c < allocChunk {
+			c = allocChunk
+		}
+		if c > length {
+			c = length
+		}
+		if err := allocate(stream, c); err != nil {
+			return buf, err
+		}
+		nbuf := make([]byte, c)
+		copy(nbuf, buf)
+		n, err := io.ReadFull(r, nbuf[len(buf):])
+		buf = nbuf[:len(buf)+n]
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// alloc implements allocator, the count is reset with the limit of Options.MaxBytes
+func (r *defaultVR) alloc(size int) error {
+	r.allocated += size
+	if r.opts != nil && r.opts.MaxAlloc > 0 && r.allocated > r.opts.MaxAlloc {
+		return fmt.Errorf("%w: %d bytes allocated, limit %d", ErrExceedMaxAlloc, r.allocated, r.opts.MaxAlloc)
+	}
+	return nil
+}
+
+func (ctx *HandleContext) alloc(size int) error {
+	return allocate(ctx.vr, size)
+}
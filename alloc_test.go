@@ -0,0 +1,199 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type allocHeavy struct {
+	Data [1024]byte
+	Seq  uint
+}
+
+// unsizedReader hides the length of the reader, like a network connection
+type unsizedReader struct {
+	io.Reader
+}
+
+// allocated returns the bytes allocated by fn
+func allocated(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestAllocHostileLength(t *testing.T) {
+	const limit = 1 << 20
+	// length 100,000,000 with a few bytes of data
+	arrayHeader := "8c 05f5e100 80 80 80 80 80"
+	for _, test := range []struct {
+		payload string
+		typ     reflect.Type
+	}{
+		{arrayHeader, reflect.TypeOf([]allocHeavy(nil))},
+		{arrayHeader, reflect.TypeOf([]*allocHeavy(nil))},
+		{arrayHeader, reflect.TypeOf(map[uint]allocHeavy(nil))},
+		{arrayHeader, reflect.TypeOf([]interface{}(nil))},
+		{arrayHeader, reflect.TypeOf((*interface{})(nil)).Elem()},
+		{arrayHeader, reflect.TypeOf([]byte(nil))},
+		{arrayHeader, reflect.TypeOf(Node{})},
+		{"e4 05f5e100 41 41 41 41 41", reflect.TypeOf("")},
+		{"e4 05f5e100 41 41 41 41 41", reflect.TypeOf([]byte(nil))},
+		{"e4 05f5e100 41 41 41 41 41", reflect.TypeOf((*interface{})(nil)).Elem()},
+		{"e4 05f5e100 41 41 41 41 41", reflect.TypeOf(Node{})},
+	} {
+		bs, err := hex.DecodeString(strings.Replace(test.payload, " ", "", -1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		forEachEngine(t, func(t *testing.T) {
+			var derr error
+			n := allocated(func() {
+				v := reflect.New(test.typ)
+				derr = Decode(unsizedReader{bytes.NewReader(bs)}, v.Interface())
+			})
+			if !errors.Is(derr, io.ErrUnexpectedEOF) && !errors.Is(derr, io.EOF) {
+				t.Errorf("%s to %v: got error %v", test.payload, test.typ, derr)
+			}
+			if n > limit {
+				t.Errorf("%s to %v: %d bytes allocated", test.payload, test.typ, n)
+			}
+		})
+	}
+
+	// rtlgen style decoding
+	bs, _ := hex.DecodeString("e405f5e100414141")
+	n := allocated(func() {
+		if _, err := DecodeBytes(NewValueReader(unsizedReader{bytes.NewReader(bs)}), nil); err == nil {
+			t.Errorf("decode bytes should fail")
+		}
+	})
+	if n > limit {
+		t.Errorf("decode bytes: %d bytes allocated", n)
+	}
+}
+
+func TestAllocMaxAlloc(t *testing.T) {
+	// 1000 zero values, 1MB in memory
+	bs := append([]byte{0x8a, 0x03, 0xe8}, bytes.Repeat([]byte{0x80}, 1000)...)
+	forEachEngine(t, func(t *testing.T) {
+		var vs []allocHeavy
+		err := NewDecoder(bytes.NewReader(bs), WithMaxAlloc(100<<10)).Decode(&vs)
+		if !errors.Is(err, ErrExceedMaxAlloc) {
+			t.Fatalf("got %v, want %v", err, ErrExceedMaxAlloc)
+		}
+		if err := NewDecoder(bytes.NewReader(bs), WithMaxAlloc(2<<20)).Decode(&vs); err != nil || len(vs) != 1000 {
+			t.Fatalf("got %d elements, err %v", len(vs), err)
+		}
+
+		m := make(map[uint]uint)
+		for i := uint(0); i < 1000; i++ {
+			m[i] = i
+		}
+		mbs, _ := Marshal(m)
+		var got map[uint]uint
+		if err := NewDecoder(bytes.NewReader(mbs), WithMaxAlloc(1000)).Decode(&got); !errors.Is(err, ErrExceedMaxAlloc) {
+			t.Fatalf("map got %v", err)
+		}
+
+		s := strings.Repeat("a", 1000)
+		sbs, _ := Marshal(s)
+		var str string
+		if err := NewDecoder(bytes.NewReader(sbs), WithMaxAlloc(999)).Decode(&str); !errors.Is(err, ErrExceedMaxAlloc) {
+			t.Fatalf("string got %v", err)
+		}
+	})
+
+	// the budget is for each value
+	buf := new(bytes.Buffer)
+	enc := NewStreamEncoder(buf)
+	for i := 0; i < 10; i++ {
+		if err := enc.Encode(strings.Repeat("a", 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dec := NewStreamDecoder(buf, WithMaxAlloc(1024))
+	for i := 0; dec.More(); i++ {
+		var s string
+		if err := dec.Decode(&s); err != nil {
+			t.Fatalf("value %d: %v", i, err)
+		}
+	}
+}
+
+func TestAllocGrowing(t *testing.T) {
+	ints := make([]uint16, 100000)
+	for i := range ints {
+		ints[i] = uint16(i)
+	}
+	str := strings.Repeat("abcdefg", 50000)
+	ifaces := []interface{}{uint64(1), "abc", []interface{}{uint64(2)}}
+	for i := 0; i < 10000; i++ {
+		ifaces = append(ifaces, uint64(i))
+	}
+	src := struct {
+		Ints   []uint16
+		Str    string
+		Bytes  []byte
+		Ifaces interface{}
+		Heavy  []allocHeavy
+	}{ints, str, []byte(str), ifaces, make([]allocHeavy, 200)}
+	src.Heavy[199].Seq = 199
+	bs, err := Marshal(&src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forEachEngine(t, func(t *testing.T) {
+		got := src
+		got.Ints, got.Str, got.Bytes, got.Ifaces, got.Heavy = nil, "", nil, nil, nil
+		if err := Decode(unsizedReader{bytes.NewReader(bs)}, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, src) {
+			t.Fatalf("decoded value mismatch")
+		}
+		// decode into the slices with capacity
+		if err := Decode(bytes.NewReader(bs), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, src) {
+			t.Fatalf("decoded value mismatch when reusing")
+		}
+	})
+
+	vr := NewValueReader(bytes.NewReader(bs))
+	if th, _, err := vr.ReadFullHeader(); err != nil || th != THArraySingle {
+		t.Fatalf("header %s, %v", th, err)
+	}
+	if err := Decode(vr, new([]uint16)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecodeBytes(vr, nil); err != nil || string(got) != str {
+		t.Fatalf("decode bytes got %d bytes, err %v", len(got), err)
+	}
+}
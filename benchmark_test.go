@@ -153,6 +153,36 @@ func init() {
 	}
 }
 
+func BenchmarkEncode(b *testing.B) {
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	n := len(_objects)
+	buf := new(bytes.Buffer)
+
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := Encode(_objects[i%n], buf); err != nil {
+			b.Fatalf("encode failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkEncodeV2(b *testing.B) {
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	n := len(_objects)
+	buf := new(bytes.Buffer)
+
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := EncodeV2(_objects[i%n], buf); err != nil {
+			b.Fatalf("encode v2 failed: %v", err)
+		}
+	}
+}
+
 func BenchmarkDecodeV1(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
@@ -190,3 +220,158 @@ func BenchmarkDecodeV2(b *testing.B) {
 		objs[j] = obj
 	}
 }
+
+type flatBenchmark struct {
+	ID      uint64
+	Height  int64
+	Name    string
+	Hash    Hash
+	Data    []byte
+	Ok      bool
+	Balance float64
+}
+
+var _flat = &flatBenchmark{
+	ID:      0x1234567890,
+	Height:  -1000,
+	Name:    "flat struct for benchmark",
+	Hash:    Hash{}.Random(),
+	Data:    _randomBytes(100),
+	Ok:      true,
+	Balance: 3.1415926,
+}
+
+func BenchmarkMarshalFlat(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(_flat); err != nil {
+			b.Fatalf("marshal failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkSizeFlat(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Size(_flat); err != nil {
+			b.Fatalf("size failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	b.ReportAllocs()
+	n := len(_objects)
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(_objects[i%n]); err != nil {
+			b.Fatalf("marshal failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkSize(b *testing.B) {
+	b.ReportAllocs()
+	n := len(_objects)
+	for i := 0; i < b.N; i++ {
+		if _, err := Size(_objects[i%n]); err != nil {
+			b.Fatalf("size failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkAppendMarshalFlat(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]byte, 0, 1024)
+	for i := 0; i < b.N; i++ {
+		var err error
+		if buf, err = AppendMarshal(buf[:0], _flat); err != nil {
+			b.Fatalf("append marshal failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkAppendMarshal(b *testing.B) {
+	b.ReportAllocs()
+	n := len(_objects)
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		var err error
+		if buf, err = AppendMarshal(buf[:0], _objects[i%n]); err != nil {
+			b.Fatalf("append marshal failed: %v", err)
+		}
+	}
+}
+
+var _blob, _ = Marshal(&noCopyBlock{Number: 1, Body: bytes.Repeat([]byte{0xab}, 1<<20), Name: "blob"})
+
+func BenchmarkUnmarshalBlob(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := Unmarshal(_blob, new(noCopyBlock)); err != nil {
+			b.Fatalf("unmarshal failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalNoCopyBlob(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := UnmarshalNoCopy(_blob, new(noCopyBlock)); err != nil {
+			b.Fatalf("unmarshal failed: %v", err)
+		}
+	}
+}
+
+var _wide, _ = Marshal(func() []interface{} {
+	vs := make([]interface{}, 10000)
+	for i := range vs {
+		switch i % 4 {
+		case 0:
+			vs[i] = uint64(i)
+		case 1:
+			vs[i] = uint64(i) << 40
+		case 2:
+			vs[i] = "element"
+		default:
+			vs[i] = []uint64{uint64(i), 1}
+		}
+	}
+	return vs
+}())
+
+func BenchmarkParseRTLHeader(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, _, err := ParseRTLHeader(byte(i)); err != nil && byte(i) != 0x83 {
+			b.Fatalf("parse %x failed: %v", byte(i), err)
+		}
+	}
+}
+
+func BenchmarkSkipWideArray(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewBytesValueReader(_wide).Skip(); err != nil {
+			b.Fatalf("skip failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkDecodeWideArray(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var vs []interface{}
+		if err := Unmarshal(_wide, &vs); err != nil {
+			b.Fatalf("decode failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkDecodeV2WideArray(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var vs []interface{}
+		if err := DecodeV2(NewBytesValueReader(_wide), &vs); err != nil {
+			b.Fatalf("decode failed: %v", err)
+		}
+	}
+}
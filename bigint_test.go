@@ -35,25 +35,37 @@ var (
 )
 
 func TestBigIntCodec(t *testing.T) {
-	buf := new(bytes.Buffer)
-	for _, i := range bigintarray {
-		buf.Reset()
-		err := EncodeBigInt(i, buf)
-		if err != nil {
-			t.Error(err)
-			continue
-		}
-		b := buf.Bytes()
-		t.Log(hex.EncodeToString(b))
-		ni := new(big.Int)
-		err = DecodeBigInt(buf, ni)
-		if err != nil {
-			t.Error(err)
-		}
-		if i.Cmp(ni) != 0 {
-			t.Error(ni, "should be", i)
-		} else {
-			t.Log(i, "check")
+	forEachEngine(t, func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		for _, i := range bigintarray {
+			buf.Reset()
+			err := EncodeBigInt(i, buf)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			b := buf.Bytes()
+			t.Log(hex.EncodeToString(b))
+			ni := new(big.Int)
+			err = DecodeBigInt(bytes.NewReader(b), ni)
+			if err != nil {
+				t.Error(err)
+			}
+			if i.Cmp(ni) != 0 {
+				t.Error(ni, "should be", i)
+			} else {
+				t.Log(i, "check")
+			}
+
+			// by the engine
+			pi := new(big.Int)
+			if err := Decode(bytes.NewReader(b), pi); err != nil || i.Cmp(pi) != 0 {
+				t.Errorf("decode %x got %v, %v, want %v", b, pi, err, i)
+			}
+			var vi big.Int
+			if err := Decode(bytes.NewReader(b), &vi); err != nil || i.Cmp(&vi) != 0 {
+				t.Errorf("decode %x into value got %v, %v, want %v", b, &vi, err, i)
+			}
 		}
-	}
+	})
 }
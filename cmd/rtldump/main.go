@@ -0,0 +1,173 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// rtldump prints the values of an RTL stream as indented trees, for debugging. Each line is a
+// header in the stream: offset, header byte, type of the header, length and the value.
+//
+//	rtldump block.bin
+//	echo 93 05 c3 616263 80 | rtldump -hex
+//
+// The elements could be annotated with the struct fields of a Go type registered with
+// rtl.RegisterType, by specifying its id with -type. Because the types are registered in the
+// packages of the models, build your own rtldump with the models imported:
+//
+//	package main
+//
+//	import _ "example.com/project/models" // registers the types in init()
+//
+// and copy the main function of this package into it.
+//
+// With -json, the values are printed as the JSON texts of rtl.ToJSON instead, which could be
+// edited and transcoded back to the RTL stream by -fromjson:
+//
+//	rtldump -json record.bin > record.json
+//	rtldump -fromjson record.json > record.bin
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/stephenfire/go-rtl"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: rtldump [flags] [file]\n\n")
+	fmt.Fprintf(os.Stderr, "Prints the values of the RTL stream in file (default is stdin).\n\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	typeID := flag.String("type", "", "id of the registered type used to annotate the values")
+	hexInput := flag.Bool("hex", false, "RTL input is hex encoded text (spaces and newlines are ignored)")
+	toJSON := flag.Bool("json", false, "prints the values as JSON texts")
+	fromJSON := flag.Bool("fromjson", false, "transcodes the JSON texts in the input to the RTL stream")
+	flag.Usage = usage
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rtldump: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var typ reflect.Type
+	if *typeID != "" {
+		id, err := strconv.ParseUint(*typeID, 0, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rtldump: illegal type id %s: %v\n", *typeID, err)
+			os.Exit(2)
+		}
+		var ok bool
+		if typ, ok = rtl.RegisteredType(id); !ok {
+			fmt.Fprintf(os.Stderr, "rtldump: type id %s not registered\n", *typeID)
+			os.Exit(2)
+		}
+	}
+
+	if *toJSON && *fromJSON {
+		fmt.Fprintf(os.Stderr, "rtldump: -json and -fromjson could not be used together\n")
+		os.Exit(2)
+	}
+
+	if *hexInput && !*fromJSON {
+		decoded, err := decodeHex(in)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rtldump: %v\n", err)
+			os.Exit(1)
+		}
+		in = bytes.NewReader(decoded)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	var err error
+	switch {
+	case *toJSON:
+		err = rtl.ToJSON(bufio.NewReader(in), out)
+	case *fromJSON:
+		err = rtl.FromJSON(in, out)
+	default:
+		err = dump(out, in, typ)
+	}
+	if ferr := out.Flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rtldump: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func decodeHex(r io.Reader) ([]byte, error) {
+	text, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text = bytes.Join(bytes.Fields(text), nil)
+	s := strings.TrimPrefix(strings.TrimPrefix(string(text), "0x"), "0X")
+	return hex.DecodeString(s)
+}
+
+// dump prints all the values in r, values are separated by empty lines
+func dump(w io.Writer, r io.Reader, typ reflect.Type) error {
+	vr := rtl.ValueReaderOf(bufio.NewReader(r))
+	offset, _ := vr.(interface{ Offset() int })
+	buf := new(bytes.Buffer)
+	for i := 0; ; i++ {
+		start := -1
+		if offset != nil {
+			start = offset.Offset()
+		}
+		buf.Reset()
+		err := rtl.Dump(buf, vr, typ)
+		if err == io.EOF && offset != nil && offset.Offset() == start {
+			// end of the stream
+			return nil
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if _, werr := w.Write(buf.Bytes()); werr != nil {
+			return werr
+		}
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+}
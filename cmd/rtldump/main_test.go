@@ -0,0 +1,52 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	in, err := decodeHex(strings.NewReader("0x9305c3616263\n80 81"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if err := dump(out, bytes.NewReader(in), nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `000000  93  Array    3
+000001  05  Byte     5        [0] = 5
+000002  c3  String   3        [1] = "abc"
+000006  80  Zero     0        [2] = zero
+
+000007  81  True     0      = true
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	if err := dump(new(bytes.Buffer), bytes.NewReader(nil), nil); err != nil {
+		t.Errorf("dump empty stream failed: %v", err)
+	}
+	if err := dump(new(bytes.Buffer), bytes.NewReader(in[:4]), nil); err != io.ErrUnexpectedEOF {
+		t.Errorf("dump truncated stream got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,550 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	rtlPath    = "github.com/stephenfire/go-rtl"
+	annotation = "//rtl:generate"
+)
+
+type config struct {
+	types  []string // names of the structs to generate, besides the annotated ones
+	all    bool     // generate for all structs in the package
+	output string   // output file name, default is <package>_rtl.go
+}
+
+// field is an encoded field of a struct, same as the fieldName of the reflective encoding
+type field struct {
+	name    string
+	typ     types.Type
+	order   int
+	version int
+}
+
+type target struct {
+	name   string
+	fields []field
+}
+
+type generator struct {
+	pkg     *types.Package
+	targets map[*types.TypeName]*target
+	imports map[string]string // path -> name
+	rtl     string            // qualifier of the rtl package
+	buf     bytes.Buffer
+}
+
+// generate writes the Serialization/Deserialization methods of the selected structs of the
+// package in dir to the output file, and returns the path of the output file.
+func generate(dir string, cfg config) (string, error) {
+	fset := token.NewFileSet()
+	files, err := parseDir(fset, dir, cfg.output)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no go files found in %s", dir)
+	}
+	pkgName := files[0].Name.Name
+	if cfg.output == "" {
+		cfg.output = strings.ToLower(pkgName) + "_rtl.go"
+	}
+
+	// type errors are ignored, because the package may refer to the methods not generated yet
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	pkg, _ := conf.Check(pkgName, fset, files, nil)
+	if pkg == nil {
+		return "", fmt.Errorf("type check %s failed", dir)
+	}
+
+	g := &generator{
+		pkg:     pkg,
+		targets: make(map[*types.TypeName]*target),
+		imports: make(map[string]string),
+		rtl:     "rtl.",
+	}
+	if pkgName == "rtl" {
+		// generating in the rtl package itself
+		g.rtl = ""
+	}
+	names, err := selectStructs(files, cfg)
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", errors.New("no struct selected, annotate structs with " + annotation + " or use -type/-all")
+	}
+	for _, name := range names {
+		tn, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			return "", fmt.Errorf("type %s not found", name)
+		}
+		st, ok := tn.Type().Underlying().(*types.Struct)
+		if !ok {
+			return "", fmt.Errorf("type %s is not a struct", name)
+		}
+		fields, err := structFields(name, st)
+		if err != nil {
+			return "", err
+		}
+		g.targets[tn] = &target{name: name, fields: fields}
+	}
+
+	var body bytes.Buffer
+	for _, name := range names {
+		tn := pkg.Scope().Lookup(name).(*types.TypeName)
+		g.buf.Reset()
+		g.genSerialization(g.targets[tn])
+		g.genDeserialization(g.targets[tn])
+		body.Write(g.buf.Bytes())
+	}
+
+	src := g.header(pkgName, body.Bytes())
+	formatted, err := format.Source(src)
+	if err != nil {
+		return "", fmt.Errorf("format generated code failed: %v\n%s", err, src)
+	}
+	output := filepath.Join(dir, cfg.output)
+	if err := ioutil.WriteFile(output, formatted, 0644); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+func parseDir(fset *token.FileSet, dir string, output string) ([]*ast.File, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*ast.File
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if output != "" && name == output {
+			// the output of last generating
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if isGeneratedByRtlgen(f) {
+			continue
+		}
+		if len(files) > 0 && files[0].Name.Name != f.Name.Name {
+			return nil, fmt.Errorf("multiple packages found in %s: %s, %s", dir, files[0].Name.Name, f.Name.Name)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func isGeneratedByRtlgen(f *ast.File) bool {
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if strings.HasPrefix(c.Text, "// Code generated by rtlgen") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func annotated(groups ...*ast.CommentGroup) bool {
+	for _, cg := range groups {
+		if cg == nil {
+			continue
+		}
+		for _, c := range cg.List {
+			if strings.TrimSpace(c.Text) == annotation {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectStructs returns the names of the structs to be generated in the order of declaration
+func selectStructs(files []*ast.File, cfg config) ([]string, error) {
+	wanted := make(map[string]bool)
+	for _, name := range cfg.types {
+		if name = strings.TrimSpace(name); name != "" {
+			wanted[name] = true
+		}
+	}
+	var names []string
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if _, ok := ts.Type.(*ast.StructType); !ok || ts.Assign.IsValid() || hasTypeParams(ts) {
+					continue
+				}
+				name := ts.Name.Name
+				if cfg.all || wanted[name] || annotated(gd.Doc, ts.Doc) {
+					names = append(names, name)
+					delete(wanted, name)
+				}
+			}
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("struct %s not found", name)
+	}
+	return names, nil
+}
+
+// structFields returns the encoded fields of the struct ordered by rtlorder, same as the
+// structFields of the reflective encoding.
+func structFields(name string, st *types.Struct) ([]field, error) {
+	var fields []field
+	index := make(map[string]int)
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if !f.Exported() {
+			continue
+		}
+		tag := reflect.StructTag(st.Tag(i))
+		ignored := false
+		for _, t := range strings.Split(tag.Get("rtl"), ",") {
+			if strings.TrimSpace(t) == "-" {
+				ignored = true
+			}
+		}
+		if ignored {
+			continue
+		}
+		order, err := tagNumber(tag, "rtlorder", name, f.Name())
+		if err != nil {
+			return nil, err
+		}
+		version, err := tagNumber(tag, "rtlversion", name, f.Name())
+		if err != nil {
+			return nil, err
+		}
+		index[f.Name()] = i
+		fields = append(fields, field{name: f.Name(), typ: f.Type(), order: order, version: version})
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].order != fields[j].order {
+			return fields[i].order < fields[j].order
+		}
+		return index[fields[i].name] < index[fields[j].name]
+	})
+	for i := range fields {
+		if fields[i].order < 0 {
+			fields[i].order = i
+		} else if fields[i].order < i {
+			return nil, fmt.Errorf("illegal rtlorder (%d) for field %s of type %s, should >= %d",
+				fields[i].order, fields[i].name, name, i)
+		}
+		if fields[i].version < 0 {
+			if i == 0 {
+				fields[i].version = 0
+			} else {
+				fields[i].version = fields[i-1].version
+			}
+		} else if i > 0 && fields[i].version < fields[i-1].version {
+			return nil, fmt.Errorf("illegal rtlversion (%d) for field %s of type %s, should >= %d",
+				fields[i].version, fields[i].name, name, fields[i-1].version)
+		}
+	}
+	return fields, nil
+}
+
+func tagNumber(tag reflect.StructTag, key, typeName, fieldName string) (int, error) {
+	s := strings.TrimSpace(tag.Get(key))
+	if s == "" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return -1, fmt.Errorf("illegal %s (%s) for field %s of type %s", key, s, fieldName, typeName)
+	}
+	return n, nil
+}
+
+func (g *generator) header(pkgName string, body []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by rtlgen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkgName)
+	std, others := []string{"io"}, []string(nil)
+	if g.rtl != "" {
+		others = append(others, rtlPath)
+	}
+	for path := range g.imports {
+		if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+			others = append(others, path)
+		} else {
+			std = append(std, path)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(others)
+	for i, paths := range [][]string{std, others} {
+		if i > 0 && len(paths) > 0 {
+			buf.WriteString("\n")
+		}
+		for _, path := range paths {
+			if path == rtlPath {
+				fmt.Fprintf(&buf, "\trtl %q\n", path)
+			} else if name := g.imports[path]; name != "" && name != filepath.Base(path) {
+				fmt.Fprintf(&buf, "\t%s %q\n", name, path)
+			} else {
+				fmt.Fprintf(&buf, "\t%q\n", path)
+			}
+		}
+	}
+	buf.WriteString(")\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func (g *generator) qualifier(p *types.Package) string {
+	if p == g.pkg {
+		return ""
+	}
+	g.imports[p.Path()] = p.Name()
+	return p.Name()
+}
+
+func (g *generator) typeString(t types.Type) string {
+	return types.TypeString(t, g.qualifier)
+}
+
+func (g *generator) p(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+// versionGroups returns the index of the last field of each version
+func versionGroups(fields []field) []int {
+	var groups []int
+	for i := range fields {
+		if i == len(fields)-1 || fields[i+1].version != fields[i].version {
+			groups = append(groups, i)
+		}
+	}
+	return groups
+}
+
+func (g *generator) genSerialization(t *target) {
+	g.p("")
+	g.p("// Serialization implements rtl.Encoder")
+	g.p("func (s *%s) Serialization(w io.Writer) error {", t.name)
+	g.p("tw := %sNewTokenWriter(w)", g.rtl)
+	g.p("if s == nil {")
+	g.p("return tw.WriteZero()")
+	g.p("}")
+	fields := t.fields
+	if len(fields) == 0 {
+		// no available fields in the struct
+		g.p("return tw.WriteZero()")
+		g.p("}")
+		return
+	}
+
+	// the trailing fields of higher versions are not written if they are all zero values
+	groups := versionGroups(fields)
+	guarded := len(groups) > 1 && fields[len(fields)-1].version > 0
+	if guarded {
+		var cases []string
+		useZero := false
+		for gi := len(groups) - 1; gi > 0; gi-- {
+			var conds []string
+			for j := groups[gi-1] + 1; j <= groups[gi]; j++ {
+				cond := g.nonZero("s."+fields[j].name, "zero."+fields[j].name, fields[j].typ)
+				useZero = useZero || strings.Contains(cond, "zero.")
+				conds = append(conds, cond)
+			}
+			cases = append(cases, strings.Join(conds, " || "))
+		}
+		if useZero {
+			g.p("var zero %s", t.name)
+		}
+		g.p("n := %d", groups[0]+1)
+		g.p("switch {")
+		for i, c := range cases {
+			g.p("case %s:", c)
+			g.p("n = %d", groups[len(groups)-1-i]+1)
+		}
+		g.p("}")
+		g.p("var err error")
+		g.p("switch n {")
+		for gi := len(groups) - 1; gi >= 0; gi-- {
+			last := fields[groups[gi]]
+			if gi == 0 {
+				g.p("default:")
+			} else {
+				g.p("case %d:", groups[gi]+1)
+			}
+			g.p("err = tw.WriteStructHeader(%d, %d)", last.version, last.order+1)
+		}
+		g.p("}")
+		g.p("if err != nil {")
+		g.p("return err")
+		g.p("}")
+	} else {
+		last := fields[len(fields)-1]
+		g.p("if err := tw.WriteStructHeader(%d, %d); err != nil {", last.version, last.order+1)
+		g.p("return err")
+		g.p("}")
+	}
+
+	order := -1
+	for j, f := range fields {
+		if guarded && j > groups[0] {
+			g.p("if n > %d {", j)
+		}
+		if f.order > order+1 {
+			g.p("if err := tw.WriteZeros(%d); err != nil {", f.order-order-1)
+			g.p("return err")
+			g.p("}")
+		}
+		order = f.order
+		g.p("// %s", f.name)
+		g.p("if err := %s; err != nil {", g.encodeExpr("s."+f.name, f.typ))
+		g.p("return err")
+		g.p("}")
+		if guarded && j > groups[0] {
+			g.p("}")
+		}
+	}
+	g.p("return nil")
+	g.p("}")
+}
+
+func (g *generator) genDeserialization(t *target) {
+	g.p("")
+	g.p("// Deserialization implements rtl.Decoder")
+	g.p("func (s *%s) Deserialization(r io.Reader) (bool, error) {", t.name)
+	g.p("vr := %sValueReaderOf(r)", g.rtl)
+	g.p("version, length, isZero, err := %sReadStructHeader(vr)", g.rtl)
+	g.p("if err != nil {")
+	g.p("return false, err")
+	g.p("}")
+	g.p("var zero %s", t.name)
+	g.p("if isZero {")
+	g.p("*s = zero")
+	g.p("return true, nil")
+	g.p("}")
+
+	fields := t.fields
+	if len(fields) == 0 {
+		g.p("_ = version")
+		g.p("for i := 0; i < length; i++ {")
+		g.p("if _, err := vr.Skip(); err != nil {")
+		g.p("return false, err")
+		g.p("}")
+		g.p("}")
+		g.p("return false, nil")
+		g.p("}")
+		return
+	}
+
+	// only the fields existing in the version of the stream will be decoded
+	groups := versionGroups(fields)
+	g.p("n := %d", len(fields))
+	if fields[len(fields)-1].version > 0 {
+		g.p("if version >= 0 {")
+		g.p("switch {")
+		for gi := len(groups) - 1; gi > 0; gi-- {
+			g.p("case version >= %d:", fields[groups[gi]].version)
+			g.p("n = %d", groups[gi]+1)
+		}
+		if fields[0].version > 0 {
+			g.p("case version >= %d:", fields[0].version)
+			g.p("n = %d", groups[0]+1)
+			g.p("default:")
+			g.p("n = 0")
+		} else {
+			g.p("default:")
+			g.p("n = %d", groups[0]+1)
+		}
+		g.p("}")
+		g.p("}")
+	} else {
+		g.p("_ = version")
+	}
+
+	g.p("decoded := 0")
+	g.p("// i is the rtlorder, a run of zero values is one element covering many orders")
+	g.p("for item, i := 0, 0; item < length; item, i = item+1, i+1 {")
+	g.p("run, err := %sReadZeros(vr)", g.rtl)
+	g.p("if err != nil {")
+	g.p("return false, err")
+	g.p("}")
+	g.p("if run > 0 {")
+	for _, f := range fields {
+		g.p("if i <= %d && %d < i+run {", f.order, f.order)
+		g.p("s.%s = zero.%s", f.name, f.name)
+		g.p("}")
+	}
+	g.p("i += run - 1")
+	g.p("continue")
+	g.p("}")
+	g.p("switch {")
+	for j, f := range fields {
+		g.p("case i == %d && n > %d:", f.order, j)
+		g.p("// %s", f.name)
+		g.decodeStmt("s."+f.name, f.typ)
+		g.p("decoded = %d", j+1)
+	}
+	g.p("default:")
+	g.p("if _, err := vr.Skip(); err != nil {")
+	g.p("return false, err")
+	g.p("}")
+	g.p("}")
+	g.p("}")
+	g.p("// fields not in the stream are set to zero")
+	for j, f := range fields {
+		g.p("if decoded <= %d {", j)
+		g.p("s.%s = zero.%s", f.name, f.name)
+		g.p("}")
+	}
+	g.p("return false, nil")
+	g.p("}")
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// rtlgen generates reflection-free Serialization/Deserialization methods (rtl.Encoder and
+// rtl.Decoder) for structs, which produce the same bytes as the reflective encoding.
+//
+// Structs are selected by the annotation in their doc comments:
+//
+//	//rtl:generate
+//	type Block struct {
+//		Height uint64
+//		Hash   [32]byte
+//		Extra  []byte `rtlversion:"1"`
+//	}
+//
+// or by the -type/-all flags. Usually used with go:generate:
+//
+//	//go:generate rtlgen
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: rtlgen [flags] [directory]\n\n")
+	fmt.Fprintf(os.Stderr, "Generates Serialization/Deserialization methods for the structs annotated with\n")
+	fmt.Fprintf(os.Stderr, "%s in the package of directory (default is current directory).\n\n", annotation)
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	typeNames := flag.String("type", "", "comma-separated list of struct names, besides the annotated ones")
+	all := flag.Bool("all", false, "generate for all structs in the package")
+	output := flag.String("output", "", "output file name in the directory, default is <package>_rtl.go")
+	flag.Usage = usage
+	flag.Parse()
+
+	dir := "."
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		dir = flag.Arg(0)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config{all: *all, output: *output}
+	if *typeNames != "" {
+		cfg.types = strings.Split(*typeNames, ",")
+	}
+	if _, err := generate(dir, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "rtlgen: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,459 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// extraTypes covers the field kinds not used by the structs in struct_test.go
+const extraTypes = `
+type (
+	MyInt    int16
+	MyBytes  []byte
+	MyString string
+
+	X_Kinds struct {
+		I    int
+		I8   int8
+		I64  int64
+		U    uint
+		U8   uint8
+		U32  uint32
+		F32  float32
+		F64  float64
+		B    bool
+		S    string
+		Bs   []byte
+		Ba   [4]byte
+		Mi   MyInt
+		Mb   MyBytes
+		Ms   MyString
+		Ints []int
+		M    map[string]uint
+		If   interface{}
+		Big  *big.Int
+		Arr  [2]X_Leaf
+		hide int
+		Skip int ` + "`rtl:\"-\"`" + `
+	}
+
+	X_Leaf struct {
+		A uint
+		B string
+	}
+
+	X_Nested struct {
+		Leaf   X_Leaf
+		LeafP  *X_Leaf   ` + "`rtlversion:\"1\"`" + `
+		Leaves []X_Leaf
+		Self   *X_Nested ` + "`rtlorder:\"5\" rtlversion:\"2\"`" + `
+		Kinds  *X_Kinds
+	}
+
+	X_Empty struct {
+		hide int
+	}
+)
+`
+
+const harnessTest = `package harness
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	rtl "github.com/stephenfire/go-rtl"
+
+	"harness/gen"
+	"harness/ref"
+)
+
+var bigType = reflect.TypeOf(big.Int{})
+
+type typePair struct {
+	name string
+	gen  reflect.Type
+	ref  reflect.Type
+}
+
+var pairs = []typePair{
+/*PAIRS*/}
+
+// fill sets random values to v, the same seed produces the same values for types of same layout
+func fill(r *rand.Rand, v reflect.Value, depth int) {
+	if r.Intn(4) == 0 {
+		// keep zero value
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt((r.Int63() >> uint(r.Intn(63))) - (r.Int63() >> uint(r.Intn(63))))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(r.Uint64() >> uint(r.Intn(64)))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(r.NormFloat64() * 1000)
+	case reflect.String:
+		v.SetString(fmt.Sprint(r.Int63()))
+	case reflect.Slice:
+		n := r.Intn(4)
+		if depth > 3 {
+			n = 0
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			fill(r, s.Index(i), depth+1)
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fill(r, v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for i := r.Intn(3); i > 0; i-- {
+			key, val := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+			fill(r, key, depth+1)
+			fill(r, val, depth+1)
+			m.SetMapIndex(key, val)
+		}
+		v.Set(m)
+	case reflect.Ptr:
+		if depth > 3 {
+			return
+		}
+		if v.Type().Elem() == bigType {
+			v.Set(reflect.ValueOf(big.NewInt(r.Int63() - r.Int63())))
+			return
+		}
+		p := reflect.New(v.Type().Elem())
+		fill(r, p.Elem(), depth+1)
+		v.Set(p)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				fill(r, v.Field(i), depth+1)
+			}
+		}
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(uint(r.Intn(1000))))
+		}
+	}
+}
+
+// same compares values of different types with the same layout
+func same(a, b reflect.Value) bool {
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return same(a.Elem(), b.Elem())
+	case reflect.Slice, reflect.Array:
+		if a.Kind() == reflect.Slice && a.IsNil() != b.IsNil() {
+			return false
+		}
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !same(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.IsNil() != b.IsNil() || a.Len() != b.Len() {
+			return false
+		}
+		for _, key := range a.MapKeys() {
+			bv := b.MapIndex(key)
+			if !bv.IsValid() || !same(a.MapIndex(key), bv) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !same(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return fmt.Sprint(a) == fmt.Sprint(b)
+	}
+}
+
+// marshal encodes v in canonical mode, so that the maps are encoded in the same order
+func marshal(v interface{}, opts ...rtl.Option) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := rtl.NewEncoder(buf, append(opts, rtl.WithCanonical())...).Encode(v)
+	return buf.Bytes(), err
+}
+
+func TestGeneratedEncoding(t *testing.T) {
+	for _, p := range pairs {
+		// nil pointers
+		gbs, gerr := rtl.Marshal(reflect.Zero(reflect.PtrTo(p.gen)).Interface())
+		rbs, rerr := rtl.Marshal(reflect.Zero(reflect.PtrTo(p.ref)).Interface())
+		if gerr != nil || rerr != nil || !bytes.Equal(gbs, rbs) {
+			t.Fatalf("%s: nil pointer generated: %x %v, reflective: %x %v", p.name, gbs, gerr, rbs, rerr)
+		}
+
+		for seed := int64(0); seed < 200; seed++ {
+			gv, rv := reflect.New(p.gen), reflect.New(p.ref)
+			fill(rand.New(rand.NewSource(seed)), gv.Elem(), 0)
+			fill(rand.New(rand.NewSource(seed)), rv.Elem(), 0)
+			gbs, gerr := marshal(gv.Interface())
+			rbs, rerr := marshal(rv.Interface())
+			if gerr != nil || rerr != nil {
+				t.Fatalf("%s(seed:%d): marshal failed: generated: %v, reflective: %v", p.name, seed, gerr, rerr)
+			}
+			if !bytes.Equal(gbs, rbs) {
+				t.Fatalf("%s(seed:%d): %+v generated: %x, reflective: %x", p.name, seed, rv.Elem(), gbs, rbs)
+			}
+			cbs, cerr := marshal(rv.Interface(), rtl.WithCompactZeros())
+			if cerr != nil {
+				t.Fatalf("%s(seed:%d): compact marshal failed: %v", p.name, seed, cerr)
+			}
+
+			// decoding the stream of every type, to check both the compatible and the failed cases
+			for _, q := range pairs {
+				gd, rd := reflect.New(q.gen), reflect.New(q.ref)
+				// decoding into dirty values, the fields not in stream should be cleared
+				fill(rand.New(rand.NewSource(seed+1)), gd.Elem(), 0)
+				fill(rand.New(rand.NewSource(seed+1)), rd.Elem(), 0)
+				gerr := rtl.Unmarshal(gbs, gd.Interface())
+				rerr := rtl.Unmarshal(rbs, rd.Interface())
+				if (gerr == nil) != (rerr == nil) {
+					t.Fatalf("%s(seed:%d) -> %s: generated error: %v, reflective error: %v", p.name, seed, q.name, gerr, rerr)
+				}
+				if gerr == nil && !same(gd.Elem(), rd.Elem()) {
+					t.Fatalf("%s(seed:%d) -> %s: generated: %+v, reflective: %+v", p.name, seed, q.name, gd.Elem(), rd.Elem())
+				}
+
+				// the compact stream is decoded to the same values
+				fill(rand.New(rand.NewSource(seed+1)), gd.Elem(), 0)
+				fill(rand.New(rand.NewSource(seed+1)), rd.Elem(), 0)
+				gerr = rtl.Unmarshal(cbs, gd.Interface())
+				rerr = rtl.Unmarshal(cbs, rd.Interface())
+				if (gerr == nil) != (rerr == nil) {
+					t.Fatalf("%s(seed:%d) -> %s: compact %x generated error: %v, reflective error: %v",
+						p.name, seed, q.name, cbs, gerr, rerr)
+				}
+				if gerr == nil && !same(gd.Elem(), rd.Elem()) {
+					t.Fatalf("%s(seed:%d) -> %s: compact %x generated: %+v, reflective: %+v",
+						p.name, seed, q.name, cbs, gd.Elem(), rd.Elem())
+				}
+			}
+		}
+	}
+}
+`
+
+// localName returns the name of the type declared in function fn
+func localName(fn, name string) string {
+	return "X_" + fn + "_" + name
+}
+
+// collectTypes returns the source of all struct types declared in file, renamed to be exported,
+// and the imports used by them.
+func collectTypes(t *testing.T, file string) (string, []string, []string) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports := make(map[string]string)
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		imports[filepath.Base(path)] = path
+	}
+
+	var specs []*ast.TypeSpec
+	renames := make(map[*ast.TypeSpec]map[string]string)
+	global := make(map[string]string)
+	collect := func(gd *ast.GenDecl, scope map[string]string, fn string) {
+		if gd.Tok != token.TYPE {
+			return
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if _, ok := ts.Type.(*ast.StructType); !ok {
+				continue
+			}
+			if fn == "" {
+				scope[ts.Name.Name] = "X_" + ts.Name.Name
+			} else {
+				scope[ts.Name.Name] = localName(fn, ts.Name.Name)
+			}
+			specs = append(specs, ts)
+			renames[ts] = scope
+		}
+	}
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			collect(d, global, "")
+		case *ast.FuncDecl:
+			if d.Body == nil {
+				continue
+			}
+			scope := make(map[string]string)
+			for name, renamed := range global {
+				scope[name] = renamed
+			}
+			ast.Inspect(d.Body, func(n ast.Node) bool {
+				if ds, ok := n.(*ast.DeclStmt); ok {
+					collect(ds.Decl.(*ast.GenDecl), scope, d.Name.Name)
+				}
+				return true
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	var names []string
+	used := make(map[string]bool)
+	for _, ts := range specs {
+		scope := renames[ts]
+		ast.Inspect(ts, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.SelectorExpr:
+				if id, ok := x.X.(*ast.Ident); ok {
+					used[imports[id.Name]] = true
+				}
+				return false
+			case *ast.Ident:
+				if renamed, ok := scope[x.Name]; ok {
+					x.Name = renamed
+				}
+			}
+			return true
+		})
+		names = append(names, ts.Name.Name)
+		buf.WriteString("type ")
+		if err := printer.Fprint(&buf, fset, ts); err != nil {
+			t.Fatal(err)
+		}
+		buf.WriteString("\n\n")
+	}
+	var paths []string
+	for path := range used {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return buf.String(), names, paths
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestGeneratedMatchesReflective generates the methods for all structs in struct_test.go and
+// some extra types, and checks the generated code produces the same bytes as the reflective
+// encoding, and decodes to the same values.
+func TestGeneratedMatchesReflective(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, names, paths := collectTypes(t, filepath.Join(root, "struct_test.go"))
+	if len(names) == 0 {
+		t.Fatal("no struct found in struct_test.go")
+	}
+	src += extraTypes
+	names = append(names, "X_Kinds", "X_Leaf", "X_Nested", "X_Empty")
+	paths = append(paths, "math/big")
+
+	dir, err := ioutil.TempDir("", "rtlgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile(t, filepath.Join(dir, "go.mod"), fmt.Sprintf("module harness\n\ngo 1.13\n\n"+
+		"require github.com/stephenfire/go-rtl v0.0.0\n\n"+
+		"replace github.com/stephenfire/go-rtl => %s\n", root))
+	var imports strings.Builder
+	done := make(map[string]bool)
+	for _, path := range paths {
+		if !done[path] {
+			done[path] = true
+			fmt.Fprintf(&imports, "\t%q\n", path)
+		}
+	}
+	for _, pkg := range []string{"gen", "ref"} {
+		if err := os.Mkdir(filepath.Join(dir, pkg), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(dir, pkg, "types.go"),
+			fmt.Sprintf("package %s\n\nimport (\n%s)\n\n%s", pkg, imports.String(), src))
+	}
+	if _, err := generate(filepath.Join(dir, "gen"), config{all: true}); err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+
+	var list strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&list, "\t{%q, reflect.TypeOf(gen.%s{}), reflect.TypeOf(ref.%s{})},\n", name, name, name)
+	}
+	writeFile(t, filepath.Join(dir, "harness_test.go"), strings.Replace(harnessTest, "/*PAIRS*/", list.String(), 1))
+
+	cmd := exec.Command(goBin, "test", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off", "GOTOOLCHAIN=local")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		generated, _ := ioutil.ReadFile(filepath.Join(dir, "gen", "gen_rtl.go"))
+		t.Fatalf("harness failed: %v\n%s\ngenerated code:\n%s", err, out, generated)
+	}
+	t.Logf("%s", out)
+}
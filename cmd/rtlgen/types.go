@@ -0,0 +1,266 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+)
+
+// fieldKind decides how a field is encoded/decoded by the generated code
+type fieldKind int
+
+const (
+	kindReflect   fieldKind = iota // use rtl.Encode/rtl.Decode
+	kindInt                        // signed integers
+	kindUint                       // unsigned integers
+	kindFloat32                    // float32
+	kindFloat64                    // float64
+	kindBool                       // bool
+	kindString                     // string
+	kindBytes                      // slice of byte
+	kindByteArray                  // array of byte
+	kindStruct                     // struct generated in the same run
+	kindStructPtr                  // pointer to struct generated in the same run
+)
+
+func hasTypeParams(ts *ast.TypeSpec) bool {
+	return ts.TypeParams != nil && len(ts.TypeParams.List) > 0
+}
+
+func hasMethod(t types.Type, names ...string) bool {
+	for _, typ := range []types.Type{t, types.NewPointer(t)} {
+		mset := types.NewMethodSet(typ)
+		for _, name := range names {
+			if mset.Lookup(nil, name) != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *generator) isTarget(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	_, ok = g.targets[named.Obj()]
+	return ok
+}
+
+func isByte(t types.Type) bool {
+	return types.Identical(t, types.Typ[types.Uint8])
+}
+
+// kindOf returns the fieldKind of t and the bit size of the number
+func (g *generator) kindOf(t types.Type) (fieldKind, int) {
+	if g.isTarget(t) {
+		return kindStruct, 0
+	}
+	if ptr, ok := t.(*types.Pointer); ok && g.isTarget(ptr.Elem()) {
+		return kindStructPtr, 0
+	}
+	// types implementing Encoder/Decoder by themselves, or prior types like big.Int
+	if _, ok := t.(*types.Named); ok && hasMethod(t, "Serialization", "Deserialization") {
+		return kindReflect, 0
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch u.Kind() {
+		case types.Int:
+			return kindInt, 0
+		case types.Int8:
+			return kindInt, 8
+		case types.Int16:
+			return kindInt, 16
+		case types.Int32:
+			return kindInt, 32
+		case types.Int64:
+			return kindInt, 64
+		case types.Uint:
+			return kindUint, 0
+		case types.Uint8:
+			return kindUint, 8
+		case types.Uint16:
+			return kindUint, 16
+		case types.Uint32:
+			return kindUint, 32
+		case types.Uint64:
+			return kindUint, 64
+		case types.Float32:
+			return kindFloat32, 32
+		case types.Float64:
+			return kindFloat64, 64
+		case types.Bool:
+			return kindBool, 0
+		case types.String:
+			return kindString, 0
+		}
+	case *types.Slice:
+		if isByte(u.Elem()) {
+			return kindBytes, 0
+		}
+	case *types.Array:
+		if isByte(u.Elem()) {
+			return kindByteArray, 0
+		}
+	}
+	return kindReflect, 0
+}
+
+// decodedTypes are the types of values returned by rtl.DecodeXXX functions
+var decodedTypes = map[fieldKind]types.Type{
+	kindInt:     types.Typ[types.Int64],
+	kindUint:    types.Typ[types.Uint64],
+	kindFloat32: types.Typ[types.Float64],
+	kindFloat64: types.Typ[types.Float64],
+	kindBool:    types.Typ[types.Bool],
+	kindString:  types.Typ[types.String],
+	kindBytes:   types.NewSlice(types.Typ[types.Uint8]),
+}
+
+// encodeExpr returns the expression which encodes x of type t and returns an error
+func (g *generator) encodeExpr(x string, t types.Type) string {
+	kind, _ := g.kindOf(t)
+	switch kind {
+	case kindInt:
+		return fmt.Sprintf("tw.WriteInt(int64(%s))", x)
+	case kindUint:
+		return fmt.Sprintf("tw.WriteUint(uint64(%s))", x)
+	case kindFloat32:
+		return fmt.Sprintf("tw.WriteFloat32(float32(%s))", x)
+	case kindFloat64:
+		return fmt.Sprintf("tw.WriteFloat64(float64(%s))", x)
+	case kindBool:
+		return fmt.Sprintf("tw.WriteBool(bool(%s))", x)
+	case kindString:
+		return fmt.Sprintf("tw.WriteString(string(%s))", x)
+	case kindBytes:
+		return fmt.Sprintf("tw.WriteBytes([]byte(%s))", x)
+	case kindByteArray:
+		return fmt.Sprintf("tw.WriteBytes(%s[:])", x)
+	case kindStruct, kindStructPtr:
+		return fmt.Sprintf("%s.Serialization(tw)", x)
+	}
+	if _, ok := t.Underlying().(*types.Interface); ok {
+		// the dynamic value of the interface is encoded
+		return fmt.Sprintf("%sEncode(&%s, tw)", g.rtl, x)
+	}
+	return fmt.Sprintf("%sEncode(%s, tw)", g.rtl, x)
+}
+
+// decodeStmt writes the statements which decode the next value in vr into x of type t
+func (g *generator) decodeStmt(x string, t types.Type) {
+	kind, bits := g.kindOf(t)
+	switch kind {
+	case kindInt, kindUint, kindFloat32, kindFloat64, kindBool, kindString, kindBytes:
+		var call string
+		switch kind {
+		case kindInt:
+			call = fmt.Sprintf("DecodeInt(vr, %d)", bits)
+		case kindUint:
+			call = fmt.Sprintf("DecodeUint(vr, %d)", bits)
+		case kindFloat32, kindFloat64:
+			call = fmt.Sprintf("DecodeFloat(vr, %d)", bits)
+		case kindBool:
+			call = "DecodeBool(vr)"
+		case kindString:
+			call = "DecodeString(vr)"
+		case kindBytes:
+			call = fmt.Sprintf("DecodeBytes(vr, []byte(%s))", x)
+		}
+		g.p("v, err := %s%s", g.rtl, call)
+		g.p("if err != nil {")
+		g.p("return false, err")
+		g.p("}")
+		if types.Identical(t, decodedTypes[kind]) {
+			g.p("%s = v", x)
+		} else {
+			g.p("%s = %s(v)", x, g.typeString(t))
+		}
+	case kindByteArray:
+		g.p("if err := %sDecodeByteArray(vr, %s[:]); err != nil {", g.rtl, x)
+		g.p("return false, err")
+		g.p("}")
+	case kindStruct:
+		g.p("if _, err := %s.Deserialization(vr); err != nil {", x)
+		g.p("return false, err")
+		g.p("}")
+	case kindStructPtr:
+		g.p("if %s == nil {", x)
+		g.p("%s = new(%s)", x, g.typeString(t.(*types.Pointer).Elem()))
+		g.p("}")
+		g.p("if isNil, err := %s.Deserialization(vr); err != nil {", x)
+		g.p("return false, err")
+		g.p("} else if isNil {")
+		g.p("%s = nil", x)
+		g.p("}")
+	default:
+		g.p("if err := %sDecode(vr, &%s); err != nil {", g.rtl, x)
+		g.p("return false, err")
+		g.p("}")
+	}
+}
+
+// nonZero returns the condition of x (of type t) is not a zero value, zx is the zero value
+func (g *generator) nonZero(x, zx string, t types.Type) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		info := u.Info()
+		switch {
+		case info&types.IsFloat != 0:
+			g.imports["math"] = "math"
+			return fmt.Sprintf("math.Float64bits(float64(%s)) != 0", x)
+		case info&types.IsNumeric != 0:
+			return x + " != 0"
+		case info&types.IsBoolean != 0:
+			return x
+		case info&types.IsString != 0:
+			return x + ` != ""`
+		}
+	case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature, *types.Interface:
+		return x + " != nil"
+	case *types.Array, *types.Struct:
+		if simpleComparable(t) {
+			return fmt.Sprintf("%s != %s", x, zx)
+		}
+	}
+	return fmt.Sprintf("!%sIsZeroValue(%s)", g.rtl, x)
+}
+
+// simpleComparable returns true if the zero value of t can be checked by comparing with
+// the zero value: no floats (-0.0 == 0.0) and no interfaces (may panic).
+func simpleComparable(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return u.Info()&(types.IsFloat|types.IsComplex) == 0
+	case *types.Pointer, *types.Chan:
+		return true
+	case *types.Array:
+		return simpleComparable(u.Elem())
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			if !simpleComparable(u.Field(i).Type()) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
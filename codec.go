@@ -0,0 +1,252 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+type (
+	// encodeFunc encodes value at the nesting level, returns the length of bytes it write
+	encodeFunc func(w io.Writer, value reflect.Value, nesting int) (int, error)
+
+	// typeCodec is the encoding and decoding plan compiled for a type. Whether the type is an
+	// Encoder/Decoder, which prior type it matches and which kind of writer/reader should be
+	// used, are decided only once when compiling.
+	typeCodec struct {
+		typ       reflect.Type
+		encode    encodeFunc
+		size      sizeFunc
+		decode    headerValueReader
+		isDecoder bool // the type or the type it points to implements Decoder
+
+		// codecs of the element types, which are Elem() of array, slice and pointer, Key() and
+		// Elem() of map, and the types of fields (in the order of structFields) of struct. They
+		// are resolved on first use, so that recursive types can be compiled.
+		childrenOnce sync.Once
+		children     []*typeCodec
+	}
+)
+
+// codecOf returns the typeCodec of typ cached in typeInfoMap, compiles it on first use
+func codecOf(typ reflect.Type) *typeCodec {
+	info := typeInfoOf(typ)
+	info.codecOnce.Do(func() {
+		info.codec = compileCodec(typ)
+	})
+	return info.codec
+}
+
+func compileCodec(typ reflect.Type) *typeCodec {
+	c := &typeCodec{
+		typ:       typ,
+		isDecoder: typ.Implements(TypeOfDecoder) || typ == typeOfRawValue || typ == typeOfNode,
+	}
+	if !c.isDecoder && typ.Kind() == reflect.Ptr {
+		c.isDecoder = typ.Elem().Implements(TypeOfDecoder)
+	}
+	c.encode = c.compileEncoder()
+	c.size = c.compileSizer()
+	c.decode = c.compileDecoder()
+	return c
+}
+
+func (c *typeCodec) child(i int) *typeCodec {
+	c.childrenOnce.Do(func() {
+		typ := c.typ
+		switch typ.Kind() {
+		case reflect.Array, reflect.Slice, reflect.Ptr:
+			c.children = []*typeCodec{codecOf(typ.Elem())}
+		case reflect.Map:
+			c.children = []*typeCodec{codecOf(typ.Key()), codecOf(typ.Elem())}
+		case reflect.Struct:
+			_, fields := structFields(typ)
+			c.children = make([]*typeCodec, len(fields))
+			for j, f := range fields {
+				c.children[j] = codecOf(typ.Field(f.index).Type)
+			}
+		}
+	})
+	return c.children[i]
+}
+
+func withoutNesting(fn writerFunc) encodeFunc {
+	return func(w io.Writer, value reflect.Value, _ int) (int, error) {
+		return fn(w, value)
+	}
+}
+
+func (c *typeCodec) compileEncoder() encodeFunc {
+	typ := c.typ
+	if typ.Implements(TypeOfEncoder) {
+		// if the object can be serialized by itself
+		valueReceiver := typ.Kind() == reflect.Ptr && typ.Elem().Implements(TypeOfEncoder)
+		return func(w io.Writer, value reflect.Value, _ int) (int, error) {
+			if valueReceiver && value.IsNil() {
+				// method with value receiver could not be called by a nil pointer
+				return w.Write(zeroValues)
+			}
+			encoder, _ := value.Interface().(Encoder)
+			return 0, encoder.Serialization(w)
+		}
+	}
+
+	if fn := priorStructWriter(typ); fn != nil {
+		return withoutNesting(fn)
+	}
+
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return withoutNesting(intWriter)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return withoutNesting(uintWriter)
+	case reflect.Float32:
+		return withoutNesting(float32Writer)
+	case reflect.Float64:
+		return withoutNesting(float64Writer)
+	case reflect.Bool:
+		return withoutNesting(boolWriter)
+	case reflect.String:
+		return withoutNesting(stringWriter)
+	case reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			// byte array
+			return withoutNesting(byteArrayWriter)
+		}
+		return func(w io.Writer, value reflect.Value, nesting int) (int, error) {
+			return arrayWriter0(w, value, c.child(0), nesting)
+		}
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			// byte slice(array)
+			return withoutNesting(byteSliceWriter)
+		}
+		return func(w io.Writer, value reflect.Value, nesting int) (int, error) {
+			return sliceWriter0(w, value, c.child(0), nesting)
+		}
+	case reflect.Map:
+		return func(w io.Writer, value reflect.Value, nesting int) (int, error) {
+			return mapWriter0(w, value, c.child(0), c.child(1), nesting)
+		}
+	case reflect.Struct:
+		return func(w io.Writer, value reflect.Value, nesting int) (int, error) {
+			return structWriter0(w, value, c, nesting)
+		}
+	case reflect.Ptr:
+		return func(w io.Writer, value reflect.Value, nesting int) (int, error) {
+			return pointerWriter0(w, value, c.child(0), nesting)
+		}
+	case reflect.Interface:
+		return interfaceWriter0
+	default:
+		return func(_ io.Writer, _ reflect.Value, _ int) (int, error) {
+			return 0, fmt.Errorf("unsupported type %v for encoding", typ)
+		}
+	}
+}
+
+func (c *typeCodec) compileDecoder() headerValueReader {
+	typ := c.typ
+	if fn := priorStructReader(typ); fn != nil {
+		return fn
+	}
+
+	kind := typ.Kind()
+	switch kind {
+	case reflect.Array:
+		return func(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+			return toArrays(c, th, length, vr, value, nesting)
+		}
+	case reflect.Slice:
+		return func(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+			return toSlices(c, th, length, vr, value, nesting)
+		}
+	case reflect.Map:
+		return func(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+			return toMaps(c, th, length, vr, value, nesting)
+		}
+	case reflect.Struct:
+		return func(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+			return toStructs(c, th, length, vr, value, nesting)
+		}
+	case reflect.Ptr:
+		// pointer
+		return func(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+			return toPointers(c, th, length, vr, value, nesting)
+		}
+	case reflect.Interface:
+		// as interface{} type
+		return func(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+			return toInterfaces(typ, kind, th, length, vr, value, nesting)
+		}
+	default:
+		funcMap, ok := primKindTypeHeaderMap[kind]
+		if ok {
+			return func(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+				return typedReader0(th, length, vr, value, nesting, funcMap)
+			}
+		}
+		return func(th TypeHeader, _ int, _ ValueReader, _ reflect.Value, _ int) error {
+			return fmt.Errorf("rtl: unsupported type1 %v (kind: %s, headerType: %s) for decoding", typ, kind, th)
+		}
+	}
+}
+
+// encodeValue encodes value which type is c.typ
+func (c *typeCodec) encodeValue(w io.Writer, value reflect.Value, nesting int) (int, error) {
+	if err := optionsOf(w).checkNesting(nesting); err != nil {
+		return 0, err
+	}
+	return c.encode(w, value, nesting)
+}
+
+// decodeValue decodes next value in vr to value which type is c.typ
+func (c *typeCodec) decodeValue(vr ValueReader, value reflect.Value, nesting int) error {
+	// decode itself if the value implements encoding.Decoder interface
+	if c.isDecoder {
+		isDecoder, err := checkTypeOfDecoder(vr, value)
+		if err != nil {
+			// the error of a Decoder may come from another stream, always records the position
+			// in this stream
+			return &DecodeError{Offset: offsetOf(vr), Type: c.typ, Err: err}
+		}
+		if isDecoder {
+			return nil
+		}
+	}
+
+	// if not an encoding.Decoder implementation, use default decoder
+	th, length, err := vr.ReadHeader()
+	if err != nil {
+		return newDecodeError(vr, c.typ, THInvalid, err)
+	}
+	if err = c.decodeHeader(th, length, vr, value, nesting); err != nil {
+		return newDecodeError(vr, c.typ, th, err)
+	}
+	return nil
+}
+
+// decodeHeader decodes the value with header th and length in vr to value which type is c.typ
+func (c *typeCodec) decodeHeader(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+	if err := optionsOf(vr).checkNesting(nesting); err != nil {
+		return err
+	}
+	return c.decode(th, length, vr, value, nesting)
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type (
+	codecNode struct {
+		Name     string
+		Next     *codecNode
+		Children []codecNode
+	}
+
+	codecTree map[string]codecTree
+)
+
+func TestCodecCache(t *testing.T) {
+	typ := reflect.TypeOf(codecNode{})
+	var wg sync.WaitGroup
+	codecs := make([]*typeCodec, 10)
+	for i := range codecs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codecs[i] = codecOf(typ)
+		}(i)
+	}
+	wg.Wait()
+	for i := 1; i < len(codecs); i++ {
+		if codecs[i] != codecs[0] {
+			t.Fatalf("codec of %s compiled more than once", typ)
+		}
+	}
+	// recursive type: codecNode -> *codecNode -> codecNode
+	if c := codecs[0].child(1).child(0); c != codecs[0] {
+		t.Fatalf("codec of recursive type %s not reused", typ)
+	}
+	if _, fields := structFields(typ); len(fields) != 3 {
+		t.Fatalf("struct fields of %s should be cached with codec, but got %v", typ, fields)
+	}
+}
+
+func TestCodecRecursive(t *testing.T) {
+	node := &codecNode{
+		Name: "root",
+		Next: &codecNode{Name: "next", Next: &codecNode{Name: "last"}},
+		Children: []codecNode{
+			{Name: "child0"},
+			{Name: "child1", Children: []codecNode{{Name: "grandchild"}}},
+		},
+	}
+	tree := codecTree{"a": {"b": {"c": nil}}, "d": {}}
+
+	for _, v := range []interface{}{node, tree} {
+		bs, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("marshal %T failed: %v", v, err)
+		}
+		for name, decode := range map[string]func([]byte, interface{}) error{
+			"V1": Unmarshal,
+			"V2": func(buf []byte, v interface{}) error { return DecodeV2(bytes.NewReader(buf), v) },
+		} {
+			got := reflect.New(reflect.TypeOf(v))
+			if err := decode(bs, got.Interface()); err != nil {
+				t.Errorf("%s: unmarshal %T failed: %v", name, v, err)
+			} else if !reflect.DeepEqual(got.Elem().Interface(), v) {
+				t.Errorf("%s: unmarshal %T got %+v, want %+v", name, v, got.Elem().Interface(), v)
+			}
+		}
+	}
+}
@@ -0,0 +1,360 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+// The functions in this file are used by the Deserialization methods generated by cmd/rtlgen, and
+// the Serialization methods write by TokenWriter. They read the same values as the reflective
+// Decode does, without reflection.
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"reflect"
+)
+
+// IsZeroValue reports whether v is the zero value of its type, as same as the struct version
+// checking of the reflective encoding does.
+func IsZeroValue(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return !rv.IsValid() || rv.IsZero()
+}
+
+// ValueReaderOf returns r if it's a ValueReader already, or wraps it as a ValueReader
+func ValueReaderOf(r io.Reader) ValueReader {
+	if vr, ok := r.(ValueReader); ok {
+		return vr
+	}
+	return NewValueReader(r)
+}
+
+// ReadStructHeader reads the header of a struct. version is -1 if there's no version prefix,
+// isZero is true if the struct is encoded as a zero value, otherwise length is the number of
+// elements of the struct.
+func ReadStructHeader(vr ValueReader) (version int, length int, isZero bool, err error) {
+	th, length, err := vr.ReadHeader()
+	if err != nil {
+		return noVersion, 0, false, err
+	}
+	version, th, length, err = readVersion(th, length, vr)
+	if err != nil {
+		return noVersion, 0, false, err
+	}
+	switch th {
+	case THZeroValue:
+		return version, 0, true, nil
+	case THArraySingle:
+		return version, length, false, nil
+	case THArrayMulti:
+		l, err := vr.ReadMultiLength(length)
+		if err != nil {
+			return version, 0, false, err
+		}
+		return version, int(l), false, nil
+	}
+	return version, 0, false, fmt.Errorf("rtl: type mismatch error: expect struct but %s found", th.Name())
+}
+
+func mismatchError(expect string, th TypeHeader) error {
+	return fmt.Errorf("rtl: type mismatch error: expect %s but %s found", expect, th.Name())
+}
+
+// DecodeInt decodes a signed integer of bitSize bits (0 means int)
+func DecodeInt(vr ValueReader, bitSize int) (int64, error) {
+	if bitSize == 0 {
+		bitSize = bits.UintSize
+	}
+	th, length, err := vr.ReadHeader()
+	if err != nil {
+		return 0, err
+	}
+	switch th {
+	case THSingleByte:
+		return int64(length), nil
+	case THZeroValue:
+		return 0, nil
+	case THPosNumSingle, THNegNumSingle:
+		buf, err := vr.ReadBytes(length, nil)
+		if err != nil {
+			return 0, err
+		}
+		isNegative := th == THNegNumSingle
+		i := Numeric.BytesToInt64(buf, isNegative)
+		if optionsOf(vr).Strict {
+			_, overflow := Numeric.BytesToInt64B(buf, isNegative)
+			if trunc := (i << (64 - uint(bitSize))) >> (64 - uint(bitSize)); overflow || len(buf) > 8 || trunc != i {
+				return 0, overflowError(buf, isNegative, fmt.Sprintf("int%d", bitSize))
+			}
+		}
+		return i, nil
+	}
+	return 0, mismatchError("int", th)
+}
+
+// DecodeUint decodes an unsigned integer of bitSize bits (0 means uint)
+func DecodeUint(vr ValueReader, bitSize int) (uint64, error) {
+	if bitSize == 0 {
+		bitSize = bits.UintSize
+	}
+	th, length, err := vr.ReadHeader()
+	if err != nil {
+		return 0, err
+	}
+	switch th {
+	case THSingleByte:
+		return uint64(length), nil
+	case THZeroValue:
+		return 0, nil
+	case THPosNumSingle:
+		buf, err := vr.ReadBytes(length, nil)
+		if err != nil {
+			return 0, err
+		}
+		u := Numeric.BytesToUint64(buf)
+		if optionsOf(vr).Strict {
+			if trunc := (u << (64 - uint(bitSize))) >> (64 - uint(bitSize)); len(buf) > 8 || trunc != u {
+				return 0, overflowError(buf, false, fmt.Sprintf("uint%d", bitSize))
+			}
+		}
+		return u, nil
+	}
+	return 0, mismatchError("uint", th)
+}
+
+// DecodeFloat decodes a float of bitSize (32 or 64) bits
+func DecodeFloat(vr ValueReader, bitSize int) (float64, error) {
+	th, length, err := vr.ReadHeader()
+	if err != nil {
+		return 0, err
+	}
+	switch th {
+	case THSingleByte:
+		return Numeric.ByteToFloat64(byte(length), false), nil
+	case THZeroValue:
+		return 0, nil
+	case THPosNumSingle, THNegNumSingle:
+		buf, err := vr.ReadBytes(length, nil)
+		if err != nil {
+			return 0, err
+		}
+		isNegative := th == THNegNumSingle
+		var f float64
+		if length == 4 {
+			f = float64(Numeric.BytesToFloat32(buf, isNegative))
+		} else {
+			f = Numeric.BytesToFloat64(buf, isNegative)
+		}
+		if optionsOf(vr).Strict && bitSize == 32 {
+			if a := math.Abs(f); math.MaxFloat32 < a && a <= math.MaxFloat64 {
+				return 0, overflowError(buf, isNegative, "float32")
+			}
+		}
+		return f, nil
+	}
+	return 0, mismatchError("float", th)
+}
+
+// DecodeBool decodes a bool
+func DecodeBool(vr ValueReader) (bool, error) {
+	th, _, err := vr.ReadHeader()
+	if err != nil {
+		return false, err
+	}
+	switch th {
+	case THZeroValue:
+		return false, nil
+	case THTrue:
+		return true, nil
+	}
+	return false, mismatchError("bool", th)
+}
+
+// DecodeString decodes a string
+func DecodeString(vr ValueReader) (string, error) {
+	th, length, err := vr.ReadHeader()
+	if err != nil {
+		return "", err
+	}
+	switch th {
+	case THSingleByte:
+		return string([]byte{byte(length)}), nil
+	case THZeroValue:
+		return "", nil
+	case THStringSingle:
+		buf, err := vr.ReadBytes(length, nil)
+		if err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	case THStringMulti:
+		buf, err := vr.ReadMultiLengthBytes(length, nil)
+		if err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+	return "", mismatchError("string", th)
+}
+
+// resizeBytes returns a byte slice with length, reuses buf if its capacity is enough
+func resizeBytes(vr ValueReader, buf []byte, length int) ([]byte, error) {
+	if err := optionsOf(vr).checkLength(length); err != nil {
+		return buf, err
+	}
+	if length > cap(buf) {
+		if err := allocate(vr, length); err != nil {
+			return buf, err
+		}
+		return make([]byte, length), nil
+	}
+	return buf[:length], nil
+}
+
+// DecodeBytes decodes a byte slice, buf will be reused if its capacity is enough
+func DecodeBytes(vr ValueReader, buf []byte) ([]byte, error) {
+	th, length, err := vr.ReadHeader()
+	if err != nil {
+		return buf, err
+	}
+	switch th {
+	case THSingleByte:
+		if buf, err = resizeBytes(vr, buf, 1); err != nil {
+			return buf, err
+		}
+		buf[0] = byte(length)
+		return buf, nil
+	case THZeroValue:
+		return nil, nil
+	case THEmpty:
+		return make([]byte, 0), nil
+	case THStringSingle, THStringMulti:
+		if th == THStringMulti {
+			l, err := vr.ReadMultiLength(length)
+			if err != nil {
+				return buf, err
+			}
+			length = int(l)
+		}
+		if optionsOf(vr).NoCopy && length > 0 {
+			// a sub-slice of the in-memory input
+			return vr.ReadBytes(length, nil)
+		}
+		if length > cap(buf) && length > allocChunk {
+			if err := optionsOf(vr).checkLength(length); err != nil {
+				return buf, err
+			}
+			// the length in the header is not trusted
+			return readGrowing(vr, vr, length)
+		}
+		if buf, err = resizeBytes(vr, buf, length); err != nil {
+			return buf, err
+		}
+		_, err = io.ReadFull(vr, buf)
+		return buf, err
+	case THArraySingle, THArrayMulti:
+		if th == THArrayMulti {
+			l, err := vr.ReadMultiLength(length)
+			if err != nil {
+				return buf, err
+			}
+			length = int(l)
+		}
+		if err := optionsOf(vr).checkLength(length); err != nil {
+			return buf, err
+		}
+		// grows with the elements decoded
+		if buf, err = resizeBytes(vr, buf, initialCap(length, 1)); err != nil {
+			return buf, err
+		}
+		buf = buf[:0]
+		for i := 0; i < length; i++ {
+			u, err := DecodeUint(vr, 8)
+			if err != nil {
+				return buf, err
+			}
+			buf = append(buf, byte(u))
+		}
+		return buf, nil
+	}
+	return buf, mismatchError("[]byte", th)
+}
+
+// DecodeByteArray decodes a byte array into dst, which is the slice of the array
+func DecodeByteArray(vr ValueReader, dst []byte) error {
+	th, length, err := vr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	switch th {
+	case THSingleByte:
+		if len(dst) >= 1 {
+			dst[0] = byte(length)
+			return nil
+		}
+		return lengthMismatch(vr, "rtl: restore nothing for an 0 length array/slice with %s(byte:%x)", THSingleByte, length)
+	case THZeroValue:
+		for i := range dst {
+			dst[i] = 0
+		}
+		return nil
+	case THStringSingle, THStringMulti:
+		var buf []byte
+		if th == THStringSingle {
+			buf, err = vr.ReadBytes(length, nil)
+		} else {
+			buf, err = vr.ReadMultiLengthBytes(length, nil)
+		}
+		if err != nil {
+			return err
+		}
+		if i := copy(dst, buf); i != len(dst) || i != len(buf) {
+			return lengthMismatch(vr, "rtl: string to array/slice length not match, "+
+				"len(string)=%d, len(array)=%d, %d elements writed", len(buf), len(dst), i)
+		}
+		return nil
+	case THArraySingle, THArrayMulti:
+		if th == THArrayMulti {
+			l, err := vr.ReadMultiLength(length)
+			if err != nil {
+				return err
+			}
+			length = int(l)
+		}
+		i := 0
+		for ; i < length && i < len(dst); i++ {
+			u, err := DecodeUint(vr, 8)
+			if err != nil {
+				return err
+			}
+			dst[i] = byte(u)
+		}
+		if i != len(dst) || i != length {
+			if err := lengthMismatch(vr, "rtl: string to array/slice length not match, "+
+				"len(string)=%d, len(array)=%d, %d elements writed", length, len(dst), i); err != nil {
+				return err
+			}
+			for ; i < length; i++ {
+				if _, err := vr.Skip(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return mismatchError("byte array", th)
+}
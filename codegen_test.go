@@ -0,0 +1,115 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCodegenWriters(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		write func(tw *TokenWriter) error
+	}{
+		{0, func(tw *TokenWriter) error { return tw.WriteInt(0) }},
+		{int64(-300), func(tw *TokenWriter) error { return tw.WriteInt(-300) }},
+		{int8(math.MinInt8), func(tw *TokenWriter) error { return tw.WriteInt(math.MinInt8) }},
+		{uint64(math.MaxUint64), func(tw *TokenWriter) error { return tw.WriteUint(math.MaxUint64) }},
+		{float32(-1.5), func(tw *TokenWriter) error { return tw.WriteFloat32(-1.5) }},
+		{math.Pi, func(tw *TokenWriter) error { return tw.WriteFloat64(math.Pi) }},
+		{true, func(tw *TokenWriter) error { return tw.WriteBool(true) }},
+		{false, func(tw *TokenWriter) error { return tw.WriteBool(false) }},
+		{"", func(tw *TokenWriter) error { return tw.WriteString("") }},
+		{"rtl", func(tw *TokenWriter) error { return tw.WriteString("rtl") }},
+		{[]byte(nil), func(tw *TokenWriter) error { return tw.WriteBytes(nil) }},
+		{[3]byte{1, 2, 3}, func(tw *TokenWriter) error { return tw.WriteBytes([]byte{1, 2, 3}) }},
+		{structV3{A: 1, C: -1}, func(tw *TokenWriter) error {
+			if err := tw.WriteStructHeader(2, 3); err != nil {
+				return err
+			}
+			if err := tw.WriteUint(1); err != nil {
+				return err
+			}
+			if err := tw.WriteZeros(1); err != nil {
+				return err
+			}
+			return tw.WriteInt(-1)
+		}},
+	}
+	for _, test := range tests {
+		want, err := Marshal(test.value)
+		if err != nil {
+			t.Fatalf("marshal %v failed: %v", test.value, err)
+		}
+		buf := new(bytes.Buffer)
+		if err := test.write(NewTokenWriter(buf)); err != nil {
+			t.Fatalf("write %v failed: %v", test.value, err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("write %v got %x, want %x", test.value, buf.Bytes(), want)
+		}
+	}
+}
+
+func TestCodegenReaders(t *testing.T) {
+	bs, err := Marshal([]interface{}{-300, uint(300), float32(2.5), true, "rtl", []byte{1, 2}, "ab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vr := ValueReaderOf(bytes.NewReader(bs))
+	version, length, isZero, err := ReadStructHeader(vr)
+	if err != nil || version != noVersion || length != 7 || isZero {
+		t.Fatalf("read header got version:%d length:%d isZero:%t err:%v", version, length, isZero, err)
+	}
+	if i, err := DecodeInt(vr, 16); err != nil || i != -300 {
+		t.Errorf("decode int got %d, %v", i, err)
+	}
+	if u, err := DecodeUint(vr, 0); err != nil || u != 300 {
+		t.Errorf("decode uint got %d, %v", u, err)
+	}
+	if f, err := DecodeFloat(vr, 32); err != nil || f != 2.5 {
+		t.Errorf("decode float got %f, %v", f, err)
+	}
+	if b, err := DecodeBool(vr); err != nil || !b {
+		t.Errorf("decode bool got %t, %v", b, err)
+	}
+	if s, err := DecodeString(vr); err != nil || s != "rtl" {
+		t.Errorf("decode string got %q, %v", s, err)
+	}
+	if b, err := DecodeBytes(vr, nil); err != nil || !bytes.Equal(b, []byte{1, 2}) {
+		t.Errorf("decode bytes got %x, %v", b, err)
+	}
+	var arr [3]byte
+	if err := DecodeByteArray(vr, arr[:]); err != nil || arr != [3]byte{'a', 'b', 0} {
+		t.Errorf("decode byte array got %x, %v", arr, err)
+	}
+
+	// overflow and type mismatch
+	bs, _ = Marshal(300)
+	if i, err := DecodeInt(NewValueReader(bytes.NewReader(bs)), 8); err != nil || int8(i) != int8(300&0xff) {
+		t.Errorf("lenient decode 300 to int8 got %d, %v", i, err)
+	}
+	if _, err := DecodeInt(NewDecoder(bytes.NewReader(bs), WithStrict()).vr, 8); !errors.Is(err, ErrOverflow) {
+		t.Errorf("strict decode 300 to int8 should fail with %v, but got: %v", ErrOverflow, err)
+	}
+	if _, err := DecodeString(NewValueReader(bytes.NewReader(bs))); err == nil {
+		t.Errorf("decode number to string should fail")
+	}
+}
@@ -17,20 +17,51 @@
 package rtl
 
 import (
-	"bytes"
 	"io"
 	"reflect"
 )
 
+// Unmarshal decodes the value in buf to v, buf is read directly (see NewBytesValueReader), and
+// the decoded values do not refer to it.
 func Unmarshal(buf []byte, v interface{}) error {
-	return Decode(NewValueReader(bytes.NewBuffer(buf)), v)
+	return Decode(NewBytesValueReader(buf), v)
+}
+
+// UnmarshalNoCopy is same as Unmarshal, except that the decoded []byte values and RawValues are
+// sub-slices of buf instead of copies, so decoding large blobs does not double the memory usage.
+// buf must not be modified while the decoded values are in use.
+func UnmarshalNoCopy(buf []byte, v interface{}) error {
+	return Decode(NewBytesValueReader(buf, WithNoCopy()), v)
+}
+
+var defaultEngine = EngineV1
+
+// SetDefaultEngine sets the engine used by Decode and Unmarshal when Options.Engine is
+// EngineDefault, it's EngineV1 if not set. It's not safe to call concurrently with decoding.
+func SetDefaultEngine(engine Engine) {
+	if engine == EngineDefault {
+		engine = EngineV1
+	}
+	defaultEngine = engine
+}
+
+// DefaultEngine returns the engine used by Decode and Unmarshal by default
+func DefaultEngine() Engine {
+	return defaultEngine
 }
 
 // Decode reads bytes from r unmarshal to v, if you want to use same Reader to Decode multi
-// values, you should use encoding.ValueReader as io.Reader.
+// values, you should use encoding.ValueReader as io.Reader, or use StreamDecoder instead.
+// The engine is selected by the Options of r (see WithEngine), or SetDefaultEngine.
 func Decode(r io.Reader, v interface{}) error {
+	engine := optionsOf(r).Engine
+	if engine == EngineDefault {
+		engine = defaultEngine
+	}
+	if engine == EngineV2 {
+		return DecodeV2(r, v)
+	}
 	return DecodeV1(r, v)
-	// return DecodeV2(r, v)
 }
 
 func DecodeV1(r io.Reader, v interface{}) error {
@@ -56,8 +87,9 @@ func DecodeV1(r io.Reader, v interface{}) error {
 	if !ok {
 		vr = NewValueReader(r)
 	}
+	start := offsetOf(vr)
 	if err := valueReader(vr, rev); err != nil {
-		return err
+		return rootDecodeError(rev.Type(), err, start, offsetOf(vr))
 	}
 	return nil
 }
@@ -66,7 +98,32 @@ func DecodeV2(r io.Reader, v interface{}) error {
 	return new(EventDecoder).Decode(r, v)
 }
 
+// ValueDecoder decodes values from the io.Reader with Options
+type ValueDecoder struct {
+	vr *defaultVR
+}
+
+func NewDecoder(r io.Reader, opts ...Option) *ValueDecoder {
+	return &ValueDecoder{vr: newValueReader(r, newOptions(opts...))}
+}
+
+// Decode decodes next value from the stream to v, Options.MaxBytes is applied to each value.
+func (d *ValueDecoder) Decode(v interface{}) error {
+	d.vr.resetLimit()
+	return Decode(d.vr, v)
+}
+
+// DecodeV2 is same as Decode, but uses the EventDecoder
+func (d *ValueDecoder) DecodeV2(v interface{}) error {
+	d.vr.resetLimit()
+	return DecodeV2(d.vr, v)
+}
+
 func checkTypeOfDecoder(r io.Reader, value reflect.Value) (isDecoder bool, err error) {
+	if decoder, ok := rawValueDecoder(value); ok {
+		_, err = decoder.Deserialization(r)
+		return true, err
+	}
 	typ := value.Type()
 
 	if typ.Implements(TypeOfDecoder) {
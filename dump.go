@@ -0,0 +1,295 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDumpBytes is the max number of bytes of a string printed by Dump
+const maxDumpBytes = 64
+
+// Offset returns the number of bytes read from the underlying reader
+func (r *defaultVR) Offset() int {
+	return r.readCount
+}
+
+type dumper struct {
+	w      io.Writer
+	vr     ValueReader
+	offset interface{ Offset() int }
+}
+
+// Dump reads the next value from r and writes it to w as an indented tree, one line for each
+// header: offset of the header, the header byte, name of the TypeHeader, length and the value.
+// If typ is not nil, the elements are annotated with the struct fields (or the types of the
+// elements) of typ. Reuse the ValueReader as r to dump more values in the same stream, the
+// offsets are counted from the beginning of the ValueReader.
+func Dump(w io.Writer, r io.Reader, typ reflect.Type) error {
+	vr := ValueReaderOf(r)
+	d := &dumper{w: w, vr: vr}
+	d.offset, _ = vr.(interface{ Offset() int })
+	return d.value(0, "", typ)
+}
+
+func (d *dumper) pos() int {
+	if d.offset == nil {
+		return -1
+	}
+	return d.offset.Offset()
+}
+
+func (d *dumper) line(offset int, hb byte, th TypeHeader, length int, depth int, label string, value string) error {
+	off := "     -"
+	if offset >= 0 {
+		off = fmt.Sprintf("%06d", offset)
+	}
+	indent := make([]byte, depth*2)
+	for i := range indent {
+		indent[i] = ' '
+	}
+	if label != "" && value != "" {
+		label += " "
+	}
+	text := fmt.Sprintf("%s  %02x  %-8s %-6d %s%s%s", off, hb, th.Name(), length, indent, label, value)
+	_, err := io.WriteString(d.w, strings.TrimRight(text, " ")+"\n")
+	return err
+}
+
+// header reads the full header, and returns the offset and the header byte of it
+func (d *dumper) header() (offset int, hb byte, th TypeHeader, length int, err error) {
+	offset = d.pos()
+	th, length, err = d.vr.ReadFullHeader()
+	if err != nil {
+		return offset, 0, th, length, err
+	}
+	n := length
+	if vt, _ := th.ValueType(); vt == THVTMultiHeader {
+		// the header byte holds the number of bytes of the length
+		n = 0
+		if offset >= 0 {
+			n = d.pos() - offset - 1
+		}
+	}
+	return offset, headerTypeMap[th].WithNumber(byte(n)), th, length, nil
+}
+
+// label returns the annotation of typ
+func label(name string, typ reflect.Type) string {
+	if typ == nil {
+		return name
+	}
+	if name == "" {
+		return typ.String()
+	}
+	return name + ": " + typ.String()
+}
+
+// annotated returns the type whose elements could be annotated
+func annotated(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Implements(TypeOfEncoder) || reflect.PtrTo(typ).Implements(TypeOfEncoder) ||
+		priorStructWriter(typ) != nil || priorStructWriter(reflect.PtrTo(typ)) != nil {
+		return nil
+	}
+	return typ
+}
+
+func (d *dumper) value(depth int, name string, typ reflect.Type) error {
+	offset, hb, th, length, err := d.header()
+	if err != nil {
+		return err
+	}
+	version := noVersion
+	if th.IsVersion() {
+		if th == THVersion {
+			version = length
+		} else {
+			buf, err := d.vr.ReadBytes(length, nil)
+			if err != nil {
+				return err
+			}
+			version = versionNumber(buf)
+		}
+		if err := d.line(offset, hb, th, length, depth, "", fmt.Sprintf("version %d", version)); err != nil {
+			return err
+		}
+		if offset, hb, th, length, err = d.header(); err != nil {
+			return err
+		}
+		if err = checkVersionFollowed(th); err != nil {
+			return err
+		}
+	}
+
+	switch th {
+	case THArraySingle, THArrayMulti:
+		if err := d.line(offset, hb, th, length, depth, label(name, typ), ""); err != nil {
+			return err
+		}
+		return d.elements(depth+1, length, annotated(typ), version)
+	case THSingleByte:
+		return d.line(offset, hb, th, length, depth, label(name, typ), "= "+byteValue(byte(length), typ))
+	case THZeroValue:
+		return d.line(offset, hb, th, length, depth, label(name, typ), "= zero")
+	case THTrue:
+		return d.line(offset, hb, th, length, depth, label(name, typ), "= true")
+	case THEmpty:
+		return d.line(offset, hb, th, length, depth, label(name, typ), "= empty")
+	case THPosNumSingle, THNegNumSingle, THPosBigInt, THNegBigInt:
+		buf, err := d.vr.ReadBytes(length, nil)
+		if err != nil {
+			return err
+		}
+		negative := th == THNegNumSingle || th == THNegBigInt
+		return d.line(offset, hb, th, length, depth, label(name, typ), "= "+numberValue(negative, buf, typ))
+	case THStringSingle, THStringMulti:
+		buf, err := d.vr.ReadBytes(length, nil)
+		if err != nil {
+			return err
+		}
+		return d.line(offset, hb, th, length, depth, label(name, typ), "= "+bytesValue(buf, typ))
+	}
+	return fmt.Errorf("rtl: unknown header %s at %d", th, offset)
+}
+
+func (d *dumper) elements(depth int, length int, typ reflect.Type, version int) error {
+	var fields []fieldName
+	if typ != nil && typ.Kind() == reflect.Struct {
+		_, fields = structFields(typ)
+		fields = fieldsOfVersion(fields, version)
+	}
+	// i is the index of the elements, a run of zero values (THZeros) covers many indexes
+	for item, i := 0, 0; item < length; item, i = item+1, i+1 {
+		offset := d.pos()
+		run, err := ReadZeros(d.vr)
+		if err != nil {
+			return err
+		}
+		if run > 0 {
+			n := 0
+			if offset >= 0 {
+				n = d.pos() - offset - 1
+			}
+			name := "[" + strconv.Itoa(i) + "-" + strconv.Itoa(i+run-1) + "]"
+			if err := d.line(offset, headerTypeMap[THZeros].WithNumber(byte(n)), THZeros, n, depth, name,
+				fmt.Sprintf("= %d zeros", run)); err != nil {
+				return err
+			}
+			i += run - 1
+			continue
+		}
+		name, etyp := "["+strconv.Itoa(i)+"]", reflect.Type(nil)
+		if typ != nil {
+			switch typ.Kind() {
+			case reflect.Struct:
+				name += " (skipped)"
+				for _, f := range fields {
+					if f.order == i {
+						name, etyp = "["+strconv.Itoa(i)+"] "+f.name, typ.Field(f.index).Type
+						break
+					}
+				}
+			case reflect.Array, reflect.Slice:
+				etyp = typ.Elem()
+			case reflect.Map:
+				if i%2 == 0 {
+					name, etyp = name+" key", typ.Key()
+				} else {
+					name, etyp = name+" value", typ.Elem()
+				}
+			}
+		}
+		if err := d.value(depth, name, etyp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func kindOf(typ reflect.Type) reflect.Kind {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil {
+		return reflect.Invalid
+	}
+	return typ.Kind()
+}
+
+func byteValue(b byte, typ reflect.Type) string {
+	switch kindOf(typ) {
+	case reflect.String:
+		return strconv.Quote(string([]byte{b}))
+	case reflect.Array, reflect.Slice:
+		return fmt.Sprintf("0x%02x", b)
+	}
+	return numberValue(false, []byte{b}, typ)
+}
+
+func numberValue(negative bool, buf []byte, typ reflect.Type) string {
+	if len(buf) <= 8 {
+		u := Numeric.BytesToUint64(buf)
+		sign := ""
+		if negative {
+			sign = "-"
+		}
+		switch kindOf(typ) {
+		case reflect.Float32:
+			return sign + strconv.FormatFloat(float64(math.Float32frombits(uint32(u))), 'g', -1, 32)
+		case reflect.Float64:
+			return sign + strconv.FormatFloat(math.Float64frombits(u), 'g', -1, 64)
+		}
+		return sign + strconv.FormatUint(u, 10)
+	}
+	i := new(big.Int).SetBytes(buf)
+	if negative {
+		i.Neg(i)
+	}
+	return i.String()
+}
+
+func bytesValue(buf []byte, typ reflect.Type) string {
+	suffix := ""
+	if len(buf) > maxDumpBytes {
+		suffix = fmt.Sprintf("...(%d bytes)", len(buf))
+		buf = buf[:maxDumpBytes]
+	}
+	switch kind := kindOf(typ); {
+	case kind == reflect.String, kind == reflect.Invalid && utf8.Valid(buf) && printable(buf):
+		return strconv.Quote(string(buf)) + suffix
+	}
+	return fmt.Sprintf("0x%x", buf) + suffix
+}
+
+func printable(buf []byte) bool {
+	for _, r := range string(buf) {
+		if !strconv.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type dumpSample struct {
+	Name  string
+	Score float64
+	Big   *big.Int
+	Tags  map[string]int8
+	Data  []byte
+	Added int `rtlversion:"1"`
+}
+
+func TestDump(t *testing.T) {
+	v := &dumpSample{
+		Name:  "n",
+		Score: 1.5,
+		Big:   new(big.Int).Lsh(big.NewInt(1), 80),
+		Tags:  map[string]int8{"a": -3},
+		Data:  []byte{0, 1, 2},
+		Added: 7,
+	}
+	bs, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		typ  reflect.Type
+		want string
+	}{
+		{reflect.TypeOf(v), `
+000000  f1  Ver      1      version 1
+000001  96  Array    6      *rtl.dumpSample
+000002  6e  Byte     110      [0] Name: string = "n"
+000003  a0  PosNum   8        [1] Score: float64 = 1.5
+000012  b1  PosNum+  11       [2] Big: *big.Int = 1208925819614629174706176
+000025  92  Array    2        [3] Tags: map[string]int8
+000026  61  Byte     97         [0] key: string = "a"
+000027  a9  NegNum   1          [1] value: int8 = -3
+000029  c3  String   3        [4] Data: []uint8 = 0x000102
+000033  07  Byte     7        [5] Added: int = 7
+`},
+		{nil, `
+000000  f1  Ver      1      version 1
+000001  96  Array    6
+000002  6e  Byte     110      [0] = 110
+000003  a0  PosNum   8        [1] = 4609434218613702656
+000012  b1  PosNum+  11       [2] = 1208925819614629174706176
+000025  92  Array    2        [3]
+000026  61  Byte     97         [0] = 97
+000027  a9  NegNum   1          [1] = -3
+000029  c3  String   3        [4] = 0x000102
+000033  07  Byte     7        [5] = 7
+`},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		if err := Dump(buf, bytes.NewReader(bs), test.typ); err != nil {
+			t.Fatalf("dump %v failed: %v", test.typ, err)
+		}
+		if got, want := buf.String(), strings.TrimPrefix(test.want, "\n"); got != want {
+			t.Errorf("dump %v got:\n%s\nwant:\n%s", test.typ, got, want)
+		}
+	}
+
+	// truncated stream
+	if err := Dump(new(bytes.Buffer), bytes.NewReader(bs[:20]), reflect.TypeOf(v)); err == nil {
+		t.Errorf("dump truncated stream should fail")
+	}
+}
+
+func TestDumpZeros(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := NewEncoder(buf, WithCompactZeros()).Encode(&compactStruct{A: 1, B: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if err := Dump(out, bytes.NewReader(buf.Bytes()), reflect.TypeOf((*compactStruct)(nil))); err != nil {
+		t.Fatal(err)
+	}
+	want := `000000  93  Array    3      *rtl.compactStruct
+000001  01  Byte     1        [0] A: uint = 1
+000002  85  Zeros    1        [1-4] = 4 zeros
+000004  78  Byte     120      [5] B: string = "x"
+`
+	if out.String() != want {
+		t.Errorf("dump got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -17,19 +17,88 @@
 package rtl
 
 import (
-	"bytes"
 	"io"
 	"reflect"
+	"sync"
 )
 
 func Marshal(v interface{}) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	var err error
-	err = Encode(v, buf)
-	if err != nil {
+	b := getEncodeBuffer()
+	defer putEncodeBuffer(b)
+	if _, err := valueWriter(b, reflect.ValueOf(v)); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	if len(b.buf) == 0 {
+		return nil, nil
+	}
+	return append([]byte(nil), b.buf...), nil
+}
+
+// AppendMarshal appends the encoding of v to dst and returns the extended buffer. No memory is
+// allocated for the encoded bytes if dst has enough capacity. dst is returned if failed.
+func AppendMarshal(dst []byte, v interface{}) ([]byte, error) {
+	b := getEncodeBuffer()
+	reused := b.buf
+	b.buf = dst
+	_, err := valueWriter(b, reflect.ValueOf(v))
+	ret := b.buf
+	b.buf = reused
+	putEncodeBuffer(b)
+	if err != nil {
+		return dst, err
+	}
+	return ret, nil
+}
+
+// maxPooledBuffer is the max capacity of the buffers kept in encodeBufferPool
+const maxPooledBuffer = 64 << 10
+
+var encodeBufferPool = sync.Pool{New: func() interface{} {
+	return new(encodeBuffer)
+}}
+
+func getEncodeBuffer() *encodeBuffer {
+	b := encodeBufferPool.Get().(*encodeBuffer)
+	b.buf = b.buf[:0]
+	return b
+}
+
+func putEncodeBuffer(b *encodeBuffer) {
+	if cap(b.buf) > maxPooledBuffer {
+		b.buf = nil
+	}
+	encodeBufferPool.Put(b)
+}
+
+// encodeBuffer is the writer of Marshal and AppendMarshal, which appends the encoded bytes to buf,
+// and reuses the scratch for headers. It's pooled by encodeBufferPool with its buf.
+type encodeBuffer struct {
+	buf  []byte
+	opts *Options
+	hbuf [9]byte
+}
+
+func (b *encodeBuffer) options() *Options {
+	return b.opts
+}
+
+func (b *encodeBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+func (b *encodeBuffer) WriteString(s string) (int, error) {
+	b.buf = append(b.buf, s...)
+	return len(s), nil
+}
+
+func (b *encodeBuffer) WriteByte(c byte) error {
+	b.buf = append(b.buf, c)
+	return nil
+}
+
+func (b *encodeBuffer) scratch() []byte {
+	return b.hbuf[:]
 }
 
 func Encode(v interface{}, w io.Writer) error {
@@ -38,6 +107,29 @@ func Encode(v interface{}, w io.Writer) error {
 	return err
 }
 
+// EncodeV2 is same as Encode, but uses the EventEncoder
+func EncodeV2(v interface{}, w io.Writer) error {
+	return new(EventEncoder).Encode(v, w)
+}
+
+// ValueEncoder encodes values to the io.Writer with Options
+type ValueEncoder struct {
+	w *optionsWriter
+}
+
+func NewEncoder(w io.Writer, opts ...Option) *ValueEncoder {
+	return &ValueEncoder{w: &optionsWriter{Writer: w, opts: newOptions(opts...)}}
+}
+
+func (e *ValueEncoder) Encode(v interface{}) error {
+	return Encode(v, e.w)
+}
+
+// EncodeV2 is same as Encode, but uses the EventEncoder
+func (e *ValueEncoder) EncodeV2(v interface{}) error {
+	return EncodeV2(v, e.w)
+}
+
 func EncodeBigInt(v interface{}, w io.Writer) error {
 	value := reflect.ValueOf(v)
 	typ := value.Type()
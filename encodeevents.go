@@ -0,0 +1,475 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+type encodeState struct {
+	val reflect.Value
+	typ reflect.Type
+	// NestedEncoder writing the elements of val, created when the header of val is written, and
+	// collected when it is popped from the stack
+	handler NestedEncoder
+}
+
+func (s *encodeState) info() string {
+	if s == nil {
+		return "<nil>"
+	}
+	if !s.val.IsValid() {
+		return "<NA>"
+	}
+	hstr := ""
+	if s.handler != nil {
+		hstr = fmt.Sprintf(", %s", s.handler.String())
+	}
+	return fmt.Sprintf("{%s(%s)%s}", s.typ.Name(), s.typ.Kind(), hstr)
+}
+
+// EncodeContext is the state of encoding values by EventEncoder. It's also the io.Writer of the
+// output, which could be passed to Encoder implementations.
+type EncodeContext struct {
+	// output writer
+	w io.Writer
+	// tokens writer on w
+	tw *TokenWriter
+	// options attached to the output writer
+	opts *Options
+	// nesting of the bottom of the stack
+	base int
+	// top of stack (last encodeState) is the current processing value
+	stack       []*encodeState
+	stackPool   sync.Pool
+	nestedPools map[reflect.Type]*sync.Pool
+	encoder     *EventEncoder
+}
+
+func (ctx *EncodeContext) options() *Options {
+	return ctx.opts
+}
+
+func newEncodeContext(e *EventEncoder, w io.Writer, base int) *EncodeContext {
+	return &EncodeContext{
+		w:     w,
+		tw:    NewTokenWriter(w),
+		opts:  optionsOf(w),
+		base:  base,
+		stack: nil,
+		stackPool: sync.Pool{New: func() interface{} {
+			return &encodeState{}
+		}},
+		encoder: e,
+	}
+}
+
+func (ctx *EncodeContext) String() string {
+	if ctx == nil {
+		return "EncCtx<nil>"
+	}
+	if len(ctx.stack) == 0 {
+		return "EncCtx{}"
+	}
+	return fmt.Sprintf("EncCtx{Stack:%d Top:%s}", len(ctx.stack), ctx.stack[len(ctx.stack)-1].info())
+}
+
+func (ctx *EncodeContext) StackInfo() string {
+	if ctx == nil {
+		return "<nil>"
+	}
+	if len(ctx.stack) == 0 {
+		return "[]"
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte('[')
+	for i, state := range ctx.stack {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(state.info())
+	}
+	buf.WriteByte(']')
+	return buf.String()
+}
+
+func (ctx *EncodeContext) top() (*encodeState, bool) {
+	if ctx == nil || len(ctx.stack) == 0 {
+		return nil, false
+	}
+	return ctx.stack[len(ctx.stack)-1], true
+}
+
+// nesting returns the nesting times of the top value
+func (ctx *EncodeContext) nesting() int {
+	return ctx.base + len(ctx.stack) - 1
+}
+
+// PopState removes the top state from the stack, which means the current value has been written.
+// The NestedEncoder of the state (if any) is recycled if it's created by NewNested. Then the
+// encoder continues with the state below, which is usually the parent value writing its next
+// element.
+func (ctx *EncodeContext) PopState() error {
+	if len(ctx.stack) == 0 {
+		return ErrEmptyStack
+	}
+	last := ctx.stack[len(ctx.stack)-1]
+	ctx.stack = ctx.stack[:len(ctx.stack)-1]
+
+	if last.handler != nil {
+		typ := reflect.TypeOf(last.handler)
+		nestedPool, exist := ctx.nestedPools[typ]
+		if exist && nestedPool != nil {
+			nestedPool.Put(last.handler)
+		}
+		last.handler = nil
+	}
+	last.val = reflect.Value{}
+	ctx.stackPool.Put(last)
+	return nil
+}
+
+// PushState pushes a new state for writing val on the top of the stack. Usually it's called by a
+// NestedEncoder to write the next element. It fails with ErrNestingOverflow if the stack is
+// deeper than Options.MaxNested.
+func (ctx *EncodeContext) PushState(val reflect.Value) error {
+	if !val.IsValid() {
+		return ErrInvalidValue
+	}
+	if err := ctx.opts.checkNesting(ctx.base + len(ctx.stack)); err != nil {
+		return err
+	}
+	state := ctx.stackPool.Get().(*encodeState)
+	state.val = val
+	state.typ = val.Type()
+	state.handler = nil
+	ctx.stack = append(ctx.stack, state)
+	return nil
+}
+
+// ReplaceStack replaces the value of the top state with val, so that val will be written by the
+// handler of its type instead, e.g. a pointer is replaced by the element it points to.
+func (ctx *EncodeContext) ReplaceStack(val reflect.Value) error {
+	if len(ctx.stack) == 0 {
+		return ErrEmptyStack
+	}
+	if !val.IsValid() {
+		return ErrInvalidValue
+	}
+	state := ctx.stack[len(ctx.stack)-1]
+	if state.handler != nil {
+		return errors.New("invalid updating state value when state in handling")
+	}
+	state.val = val
+	state.typ = val.Type()
+	return nil
+}
+
+// NestedStack attaches handler to the top state, after the header of an array has been written by
+// WriteArray. After that, the encoder calls handler.Element every time the state is on the top of
+// the stack, until the state is popped. Element should push a state for the next element
+// (PushState), or pop the state (PopState) if all the elements have been written.
+func (ctx *EncodeContext) NestedStack(handler NestedEncoder) error {
+	if len(ctx.stack) == 0 {
+		return ErrEmptyStack
+	}
+	if ctx.stack[len(ctx.stack)-1].handler != nil {
+		return errors.New("already has nested handler")
+	}
+	ctx.stack[len(ctx.stack)-1].handler = handler
+	return nil
+}
+
+// NewNested returns a pointer to a value of typ from the pool of the context, which could be used
+// as a NestedEncoder. It will be put back to the pool when the state holding it is popped, so the
+// handler must be reset after getting from NewNested.
+func (ctx *EncodeContext) NewNested(typ reflect.Type) interface{} {
+	if ctx.nestedPools == nil {
+		ctx.nestedPools = make(map[reflect.Type]*sync.Pool)
+	}
+	pool, exist := ctx.nestedPools[typ]
+	if !exist || pool == nil {
+		pool = &sync.Pool{New: func() interface{} {
+			return reflect.New(typ).Interface()
+		}}
+		ctx.nestedPools[typ] = pool
+	}
+	return pool.Get()
+}
+
+// Write writes the encoded bytes p to the output directly
+func (ctx *EncodeContext) Write(p []byte) (int, error) {
+	return ctx.w.Write(p)
+}
+
+// WriteZero writes a zero value (nil, false, 0, "")
+func (ctx *EncodeContext) WriteZero() error {
+	return ctx.tw.WriteZero()
+}
+
+// WriteTrue writes a true value
+func (ctx *EncodeContext) WriteTrue() error {
+	return ctx.tw.WriteBool(true)
+}
+
+// WriteEmpty writes an empty value, such as an empty slice or map
+func (ctx *EncodeContext) WriteEmpty() error {
+	return ctx.tw.WriteEmpty()
+}
+
+// WriteUint writes an unsigned integer
+func (ctx *EncodeContext) WriteUint(u uint64) error {
+	return ctx.tw.WriteUint(u)
+}
+
+// WriteInt writes a signed integer
+func (ctx *EncodeContext) WriteInt(i int64) error {
+	return ctx.tw.WriteInt(i)
+}
+
+// WriteNumber writes a number with its absolute value in big-endian bytes, which could be longer
+// than 8 bytes. Zero is written if bs is empty.
+func (ctx *EncodeContext) WriteNumber(isNegative bool, bs []byte) error {
+	if len(bs) == 0 {
+		return ctx.WriteZero()
+	}
+	return writeErr(_writeNumberBytes(ctx.tw, isNegative, bs))
+}
+
+// WriteBytes writes a byte slice, nil is written as zero value, and an empty slice as empty value
+func (ctx *EncodeContext) WriteBytes(bs []byte) error {
+	return ctx.tw.WriteBytes(bs)
+}
+
+// WriteString writes a string, "" is written as zero value
+func (ctx *EncodeContext) WriteString(s string) error {
+	return ctx.tw.WriteString(s)
+}
+
+// WriteVersion writes the struct version prefix, which should be followed by the array of fields
+func (ctx *EncodeContext) WriteVersion(version uint64) error {
+	return ctx.tw.WriteVersion(version)
+}
+
+// WriteArray writes the header of an array with length (>0) elements, which should be followed
+// by the elements, usually written by a NestedEncoder (see NestedStack).
+func (ctx *EncodeContext) WriteArray(length int) error {
+	// array would +1 to nesting, so equals to MaxNested is overflowed
+	if length > 0 {
+		if err := ctx.opts.checkNesting(ctx.nesting() + 1); err != nil {
+			return err
+		}
+	}
+	return ctx.tw.WriteArrayHeader(length)
+}
+
+type (
+	// EncodeHandler writes the value of the top state of the EncodeContext stack. The handler
+	// must change the stack after writing, by one of:
+	//
+	//   - PopState: the value has been written, e.g. after WriteUint
+	//   - ReplaceStack: let the handler of another value write it
+	//   - NestedStack: write the elements of an array by a NestedEncoder, after WriteArray
+	EncodeHandler interface {
+		Write(ctx *EncodeContext, value reflect.Value) error
+	}
+
+	// NestedEncoder writes the elements of the value of a state, see EncodeContext.NestedStack.
+	// Index returns the index of the element being written.
+	NestedEncoder interface {
+		String() string
+		Element(ctx *EncodeContext) error
+		Index() int
+	}
+)
+
+var (
+	_systemKindEncoders = make(map[reflect.Kind]EncodeHandler)
+	_systemTypeEncoders = make(map[reflect.Type]EncodeHandler)
+	// reflect.Type -> *encodeInfo
+	_encodeInfos sync.Map
+)
+
+func _systemKindEncoder(handler EncodeHandler, kinds ...reflect.Kind) {
+	for _, k := range kinds {
+		_systemKindEncoders[k] = handler
+	}
+}
+
+func _systemTypeEncoder(handler EncodeHandler, typs ...reflect.Type) {
+	for _, typ := range typs {
+		_systemTypeEncoders[typ] = handler
+	}
+}
+
+// EventEncoder encodes values by the EncodeHandlers of their types with an explicit stack instead
+// of recursion, and produces the same bytes as Encode. Handlers are looked up in the order of:
+// handlers registered by RegisterTypeHandler, built-in handlers of types (big.Int, time.Time...),
+// handlers registered by RegisterKindHandler and built-in handlers of kinds. Values implementing
+// Encoder are always encoded by themselves. The zero value is ready to use.
+type EventEncoder struct {
+	kindHandlers map[reflect.Kind]EncodeHandler
+	typeHandlers map[reflect.Type]EncodeHandler
+}
+
+// RegisterTypeHandler registers handler for the values of typ, so that the types not supported
+// (e.g. a type of a third-party package) could be encoded without implementing Encoder. A nil
+// handler removes the registration. Handlers should be registered before encoding, it's not safe
+// to register concurrently with Encode.
+func (e *EventEncoder) RegisterTypeHandler(typ reflect.Type, handler EncodeHandler) {
+	if typ == nil {
+		panic("rtl: register handler for nil type")
+	}
+	if handler == nil {
+		delete(e.typeHandlers, typ)
+		return
+	}
+	if e.typeHandlers == nil {
+		e.typeHandlers = make(map[reflect.Type]EncodeHandler)
+	}
+	e.typeHandlers[typ] = handler
+}
+
+// RegisterKindHandler registers handler for the values of kind, which replaces the built-in
+// handler of kind. A nil handler removes the registration. Handlers should be registered before
+// encoding, it's not safe to register concurrently with Encode.
+func (e *EventEncoder) RegisterKindHandler(kind reflect.Kind, handler EncodeHandler) {
+	if handler == nil {
+		delete(e.kindHandlers, kind)
+		return
+	}
+	if e.kindHandlers == nil {
+		e.kindHandlers = make(map[reflect.Kind]EncodeHandler)
+	}
+	e.kindHandlers[kind] = handler
+}
+
+// encodeInfo is the built-in encoding information of a type, cached in _encodeInfos
+type encodeInfo struct {
+	isEncoder     bool
+	valueReceiver bool // pointer type whose element type implements Encoder
+	typeHandler   EncodeHandler
+	kindHandler   EncodeHandler
+}
+
+func encodeInfoOf(typ reflect.Type) *encodeInfo {
+	if info, ok := _encodeInfos.Load(typ); ok {
+		return info.(*encodeInfo)
+	}
+	info := &encodeInfo{
+		isEncoder:   typ.Implements(TypeOfEncoder),
+		kindHandler: _systemKindEncoders[typ.Kind()],
+	}
+	info.valueReceiver = info.isEncoder && typ.Kind() == reflect.Ptr && typ.Elem().Implements(TypeOfEncoder)
+	if handler, exist := _systemTypeEncoders[typ]; exist {
+		info.typeHandler = handler
+	} else if fn := priorStructWriter(typ); fn != nil {
+		info.typeHandler = writerEncodeHandler{fn}
+	}
+	actual, _ := _encodeInfos.LoadOrStore(typ, info)
+	return actual.(*encodeInfo)
+}
+
+func (e *EventEncoder) _getHandler(typ reflect.Type, info *encodeInfo) EncodeHandler {
+	if handler, exist := e.typeHandlers[typ]; exist && handler != nil {
+		return handler
+	}
+	if info.typeHandler != nil {
+		return info.typeHandler
+	}
+	if handler, exist := e.kindHandlers[typ.Kind()]; exist && handler != nil {
+		return handler
+	}
+	return info.kindHandler
+}
+
+// runEncoder writes the value by itself if it implements Encoder
+func (e *EventEncoder) runEncoder(ctx *EncodeContext, value reflect.Value, info *encodeInfo) (bool, error) {
+	if !info.isEncoder {
+		return false, nil
+	}
+	if info.valueReceiver && value.IsNil() {
+		// method with value receiver could not be called by a nil pointer
+		return true, ctx.WriteZero()
+	}
+	encoder, _ := value.Interface().(Encoder)
+	return true, encoder.Serialization(ctx)
+}
+
+func (e *EventEncoder) handle(ctx *EncodeContext) error {
+	for {
+		state, exist := ctx.top()
+		if !exist {
+			return nil
+		}
+
+		if state.handler != nil {
+			if err := state.handler.Element(ctx); err != nil {
+				return fmt.Errorf("rtl: element(%d) encode failed: %w, at %s",
+					state.handler.Index(), err, ctx.StackInfo())
+			}
+			continue
+		}
+
+		typ := state.typ
+		info := encodeInfoOf(typ)
+		isEncoder, err := e.runEncoder(ctx, state.val, info)
+		if err != nil {
+			return err
+		}
+		if isEncoder {
+			if err = ctx.PopState(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		handler := e._getHandler(typ, info)
+		if handler == nil {
+			return fmt.Errorf("rtl: unsupported type %v for encoding, at %s", typ, ctx.StackInfo())
+		}
+		if err = handler.Write(ctx, state.val); err != nil {
+			return fmt.Errorf("rtl: encode %v failed: %w, at %s", typ, err, ctx.StackInfo())
+		}
+		if top, _ := ctx.top(); top == state && state.handler == nil && state.typ == typ {
+			// the value would be dispatched to the same handler forever
+			return fmt.Errorf("rtl: %T did not change the state of %v, at %s", handler, typ, ctx.StackInfo())
+		}
+	}
+}
+
+func (e *EventEncoder) encode(w io.Writer, value reflect.Value, nesting int) error {
+	if !value.IsValid() {
+		// zero Value, do nothing
+		return nil
+	}
+	ctx := newEncodeContext(e, w, nesting)
+	if err := ctx.PushState(value); err != nil {
+		return err
+	}
+	return e.handle(ctx)
+}
+
+// Encode writes the encoding of v to w
+func (e *EventEncoder) Encode(v interface{}, w io.Writer) error {
+	return e.encode(w, reflect.ValueOf(v), 0)
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type (
+	// writes eventDecimal as a string "123.45"
+	eventDecimalEncoder struct{}
+
+	// writes bool as "yes" or "no"
+	eventYesNoEncoder struct{}
+
+	// does not change the state
+	eventLazyEncoder struct{}
+)
+
+func (eventDecimalEncoder) Write(ctx *EncodeContext, value reflect.Value) error {
+	d := value.Interface().(eventDecimal)
+	s := d.value.String()
+	if d.exp < 0 {
+		for len(s) <= int(-d.exp) {
+			s = "0" + s
+		}
+		s = s[:len(s)+int(d.exp)] + "." + s[len(s)+int(d.exp):]
+	} else {
+		s += strings.Repeat("0", int(d.exp))
+	}
+	if err := ctx.WriteString(s); err != nil {
+		return err
+	}
+	return ctx.PopState()
+}
+
+func (eventYesNoEncoder) Write(ctx *EncodeContext, value reflect.Value) error {
+	s := "no"
+	if value.Bool() {
+		s = "yes"
+	}
+	if err := ctx.WriteString(s); err != nil {
+		return err
+	}
+	return ctx.PopState()
+}
+
+func (eventLazyEncoder) Write(_ *EncodeContext, _ reflect.Value) error {
+	return nil
+}
+
+func TestEncodeV2(t *testing.T) {
+	strMap := make(map[string]int)
+	bigMap := make(map[*big.Int]uint)
+	for i := 0; i < 50; i++ {
+		strMap[fmt.Sprintf("key-%d", i*7)] = i
+		bigMap[new(big.Int).Lsh(big.NewInt(int64(i+1)), uint(i*3))] = uint(i)
+	}
+	bi := BigInt(*big.NewInt(-1000))
+	values := []interface{}{
+		nil,
+		strMap,
+		bigMap,
+		map[string][]int{},
+		&versionHolder{V: &structV3{A: 1, C: 3}, Vs: []structV3{{A: 2, D: []byte{1}}, {}}, N: 9},
+		&structV20{A: 1, B: "v20"},
+		&registryBlock{
+			Tx:  &registryCall{Contract: "c", Inner: registryTransfer{From: "a"}},
+			Txs: []registryTx{registryTransfer{To: "b"}, nil},
+			Any: "any",
+		},
+		&bi,
+		[]*BigInt{&bi, nil},
+		[]Timed{Timed(time.Unix(1700000000, 0).UTC())},
+		&rawEnvelope{Kind: 1, Payload: RawValue{0x81}, Tail: "tail"},
+		[3]interface{}{nil, []byte(nil), [0]int{}},
+		[]**int{nil},
+	}
+	for _, test := range encTests {
+		values = append(values, test.val)
+	}
+
+	for _, v := range values {
+		opts := []Option{WithCanonical()}
+		if _, ok := v.(*registryBlock); ok {
+			opts = append(opts, WithTypedInterfaces())
+		}
+		want := new(bytes.Buffer)
+		if err := NewEncoder(want, opts...).Encode(v); err != nil {
+			t.Fatalf("encode %T failed: %v", v, err)
+		}
+		got := new(bytes.Buffer)
+		if err := NewEncoder(got, opts...).EncodeV2(v); err != nil {
+			t.Fatalf("encode v2 %T failed: %v", v, err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("encode v2 %T got %x, want %x", v, got.Bytes(), want.Bytes())
+		}
+	}
+
+	// nesting
+	v := [][][]int{{{1, 2}, {3}}, {{4}}}
+	for n := 0; n <= 4; n++ {
+		err1 := NewEncoder(new(bytes.Buffer), WithMaxNested(n)).Encode(v)
+		err2 := NewEncoder(new(bytes.Buffer), WithMaxNested(n)).EncodeV2(v)
+		if errors.Is(err1, ErrNestingOverflow) != errors.Is(err2, ErrNestingOverflow) {
+			t.Errorf("encode with MaxNested=%d got %v, but encode v2 got %v", n, err1, err2)
+		}
+	}
+	var deep interface{} = "bottom"
+	for i := 0; i < 100000; i++ {
+		deep = []interface{}{deep}
+	}
+	buf := new(bytes.Buffer)
+	if err := NewEncoder(buf, WithMaxNested(1<<20)).EncodeV2(deep); err != nil {
+		t.Errorf("encode v2 deep value failed: %v", err)
+	} else if buf.Len() != 100000+len("bottom")+1 {
+		t.Errorf("encode v2 deep value got %d bytes", buf.Len())
+	}
+
+	if err := EncodeV2(make(chan int), new(bytes.Buffer)); err == nil {
+		t.Error("encode v2 of chan should fail")
+	}
+}
+
+func TestEncodeHandlers(t *testing.T) {
+	record := &eventRecord{
+		Price: eventDecimal{value: big.NewInt(12345), exp: -2},
+		Cost:  &eventDecimal{value: big.NewInt(1), exp: 3},
+		Done:  true,
+		Prices: []eventDecimal{
+			{value: big.NewInt(5), exp: -1},
+		},
+	}
+
+	encoder := new(EventEncoder)
+	encoder.RegisterTypeHandler(reflect.TypeOf(eventDecimal{}), eventDecimalEncoder{})
+	encoder.RegisterKindHandler(reflect.Bool, eventYesNoEncoder{})
+	buf := new(bytes.Buffer)
+	if err := encoder.Encode(record, buf); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := Marshal([]interface{}{"123.45", "1000", "yes", []string{"0.5"}})
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("encode got %x, want %x", buf.Bytes(), want)
+	}
+
+	// symmetric with the EventDecoder handlers
+	decoder := new(EventDecoder)
+	decoder.RegisterTypeHandler(reflect.TypeOf(eventDecimal{}), eventDecimalHandler{})
+	decoder.RegisterKindHandler(reflect.Bool, eventYesNoHandler{})
+	got := new(eventRecord)
+	if err := decoder.Decode(bytes.NewReader(buf.Bytes()), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Price.value.Cmp(record.Price.value) != 0 || got.Price.exp != record.Price.exp ||
+		got.Cost.value.Int64() != 1000 || !got.Done || len(got.Prices) != 1 {
+		t.Errorf("decode got %+v", got)
+	}
+
+	// remove the kind handler
+	encoder.RegisterKindHandler(reflect.Bool, nil)
+	buf.Reset()
+	if err := encoder.Encode(true, buf); err != nil || !bytes.Equal(buf.Bytes(), trueBools) {
+		t.Errorf("encode bool after removing handler got %x, %v", buf.Bytes(), err)
+	}
+
+	// handler must change the stack
+	encoder.RegisterTypeHandler(reflect.TypeOf(eventDecimal{}), eventLazyEncoder{})
+	if err := encoder.Encode(record, new(bytes.Buffer)); err == nil || !strings.Contains(err.Error(), "did not change") {
+		t.Errorf("encode by a handler not changing the stack got: %v", err)
+	}
+}
@@ -0,0 +1,376 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+type (
+	// writes the value by a writerFunc
+	writerEncodeHandler struct {
+		fn writerFunc
+	}
+	arrayEncodeHandler     struct{}
+	sliceEncodeHandler     struct{}
+	mapEncodeHandler       struct{}
+	structEncodeHandler    struct{}
+	pointerEncodeHandler   struct{}
+	interfaceEncodeHandler struct{}
+)
+
+func init() {
+	_systemKindEncoder(writerEncodeHandler{intWriter},
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64)
+	_systemKindEncoder(writerEncodeHandler{uintWriter},
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64)
+	_systemKindEncoder(writerEncodeHandler{float32Writer}, reflect.Float32)
+	_systemKindEncoder(writerEncodeHandler{float64Writer}, reflect.Float64)
+	_systemKindEncoder(writerEncodeHandler{boolWriter}, reflect.Bool)
+	_systemKindEncoder(writerEncodeHandler{stringWriter}, reflect.String)
+	_systemKindEncoder(arrayEncodeHandler{}, reflect.Array)
+	_systemKindEncoder(sliceEncodeHandler{}, reflect.Slice)
+	_systemKindEncoder(mapEncodeHandler{}, reflect.Map)
+	_systemKindEncoder(structEncodeHandler{}, reflect.Struct)
+	_systemKindEncoder(pointerEncodeHandler{}, reflect.Ptr)
+	_systemKindEncoder(interfaceEncodeHandler{}, reflect.Interface)
+
+	for typ, fn := range _priorStructWriters {
+		_systemTypeEncoder(writerEncodeHandler{fn}, typ)
+	}
+}
+
+func (h writerEncodeHandler) Write(ctx *EncodeContext, value reflect.Value) error {
+	if _, err := h.fn(ctx.tw, value); err != nil {
+		return err
+	}
+	return ctx.PopState()
+}
+
+func (arrayEncodeHandler) Write(ctx *EncodeContext, value reflect.Value) error {
+	if value.Type().Elem().Kind() == reflect.Uint8 {
+		// byte array
+		if _, err := byteArrayWriter(ctx.tw, value); err != nil {
+			return err
+		}
+		return ctx.PopState()
+	}
+	if value.Len() == 0 {
+		if err := ctx.WriteZero(); err != nil {
+			return err
+		}
+		return ctx.PopState()
+	}
+	return writeElements(ctx, value)
+}
+
+func (sliceEncodeHandler) Write(ctx *EncodeContext, value reflect.Value) error {
+	var err error
+	switch {
+	case value.IsNil():
+		err = ctx.WriteZero()
+	case value.Type().Elem().Kind() == reflect.Uint8:
+		// byte slice
+		err = ctx.WriteBytes(value.Bytes())
+	case value.Len() == 0:
+		err = ctx.WriteEmpty()
+	default:
+		return writeElements(ctx, value)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.PopState()
+}
+
+func writeElements(ctx *EncodeContext, value reflect.Value) error {
+	if err := ctx.WriteArray(value.Len()); err != nil {
+		return err
+	}
+	elements := ctx.NewNested(typeOfArrayEncoder).(*arrayEncoder)
+	elements.val = value
+	elements.idx = -1
+	return ctx.NestedStack(elements)
+}
+
+func (mapEncodeHandler) Write(ctx *EncodeContext, value reflect.Value) error {
+	if value.IsNil() || value.Len() == 0 {
+		var err error
+		if value.IsNil() {
+			err = ctx.WriteZero()
+		} else {
+			err = ctx.WriteEmpty()
+		}
+		if err != nil {
+			return err
+		}
+		return ctx.PopState()
+	}
+
+	keys := value.MapKeys()
+	// map would +1 to nesting, so equals to MaxNested is overflowed
+	if err := ctx.WriteArray(len(keys) << 1); err != nil {
+		return err
+	}
+	entries := ctx.NewNested(typeOfMapEncoder).(*mapEncoder)
+	entries.val = value
+	entries.keys = keys
+	entries.keyBytes = entries.keyBytes[:0]
+	entries.idx = -1
+	if ctx.opts.Canonical {
+		if err := entries.sortKeys(ctx); err != nil {
+			return err
+		}
+	}
+	return ctx.NestedStack(entries)
+}
+
+func (structEncodeHandler) Write(ctx *EncodeContext, value reflect.Value) error {
+	_, fields := structFields(value.Type())
+	if len(fields) == 0 {
+		// no available fields in the struct
+		if err := ctx.WriteZero(); err != nil {
+			return err
+		}
+		return ctx.PopState()
+	}
+	// struct would +1 to nesting, so equals to MaxNested is overflowed
+	if err := ctx.opts.checkNesting(ctx.nesting() + 1); err != nil {
+		return err
+	}
+
+	fnum, fields := versionedFields(value, fields)
+	version := structVersion(fields)
+	if ctx.opts.CompactZeros {
+		fnum, fields = compactFields(value, fields)
+	}
+	if version > 0 {
+		// struct version prefix, absent means version 0
+		if err := ctx.WriteVersion(uint64(version)); err != nil {
+			return err
+		}
+	}
+	if fnum == 0 {
+		// all fields omitted
+		if err := ctx.WriteZero(); err != nil {
+			return err
+		}
+		return ctx.PopState()
+	}
+	if err := ctx.WriteArray(fnum); err != nil {
+		return err
+	}
+	elements := ctx.NewNested(typeOfStructEncoder).(*structEncoder)
+	elements.val = value
+	elements.fields = fields
+	elements.idx = -1
+	return ctx.NestedStack(elements)
+}
+
+func (pointerEncodeHandler) Write(ctx *EncodeContext, value reflect.Value) error {
+	if value.IsNil() {
+		if err := ctx.WriteZero(); err != nil {
+			return err
+		}
+		return ctx.PopState()
+	}
+	return ctx.ReplaceStack(value.Elem())
+}
+
+func (interfaceEncodeHandler) Write(ctx *EncodeContext, value reflect.Value) error {
+	if value.IsNil() {
+		if err := ctx.WriteZero(); err != nil {
+			return err
+		}
+		return ctx.PopState()
+	}
+	elem := value.Elem()
+	if !ctx.opts.TypedInterfaces {
+		return ctx.ReplaceStack(elem)
+	}
+	// [id, value]
+	id, ok := registeredID(elem.Type())
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrUnregisteredType, elem.Type())
+	}
+	if err := ctx.WriteArray(2); err != nil {
+		return err
+	}
+	if err := ctx.WriteUint(id); err != nil {
+		return err
+	}
+	typed := ctx.NewNested(typeOfTypedEncoder).(*typedEncoder)
+	typed.val = elem
+	typed.idx = 0
+	return ctx.NestedStack(typed)
+}
+
+// arrayEncoder writes the elements of array or slice
+type arrayEncoder struct {
+	val reflect.Value
+	idx int
+}
+
+var typeOfArrayEncoder = reflect.TypeOf((*arrayEncoder)(nil)).Elem()
+
+func (a *arrayEncoder) String() string {
+	if a == nil {
+		return "arrayEnc<nil>"
+	}
+	return fmt.Sprintf("arrayEnc[%d/%d]", a.idx, a.val.Len())
+}
+
+func (a *arrayEncoder) Element(ctx *EncodeContext) error {
+	a.idx++
+	if a.idx >= a.val.Len() {
+		return ctx.PopState()
+	}
+	return ctx.PushState(a.val.Index(a.idx))
+}
+
+func (a *arrayEncoder) Index() int {
+	return a.idx
+}
+
+// mapEncoder writes the keys and values of map, the keys are written in the order of their
+// encoded bytes in canonical mode
+type mapEncoder struct {
+	val      reflect.Value
+	keys     []reflect.Value
+	keyBytes [][]byte // encoded keys in canonical mode
+	idx      int      // index of keys and values
+}
+
+var typeOfMapEncoder = reflect.TypeOf((*mapEncoder)(nil)).Elem()
+
+func (m *mapEncoder) sortKeys(ctx *EncodeContext) error {
+	buf := new(bytes.Buffer)
+	w := &optionsWriter{Writer: buf, opts: ctx.opts}
+	for _, key := range m.keys {
+		buf.Reset()
+		if err := ctx.encoder.encode(w, key, ctx.nesting()+1); err != nil {
+			return err
+		}
+		m.keyBytes = append(m.keyBytes, append([]byte(nil), buf.Bytes()...))
+	}
+	sort.Stable(m)
+	return nil
+}
+
+func (m *mapEncoder) Len() int           { return len(m.keys) }
+func (m *mapEncoder) Less(i, j int) bool { return bytes.Compare(m.keyBytes[i], m.keyBytes[j]) < 0 }
+func (m *mapEncoder) Swap(i, j int) {
+	m.keys[i], m.keys[j] = m.keys[j], m.keys[i]
+	m.keyBytes[i], m.keyBytes[j] = m.keyBytes[j], m.keyBytes[i]
+}
+
+func (m *mapEncoder) String() string {
+	if m == nil {
+		return "mapEnc<nil>"
+	}
+	return fmt.Sprintf("mapEnc[%d/%d]", m.idx, len(m.keys)*2)
+}
+
+func (m *mapEncoder) Element(ctx *EncodeContext) error {
+	m.idx++
+	if m.idx >= len(m.keys)*2 {
+		m.keys = nil
+		return ctx.PopState()
+	}
+	key := m.keys[m.idx/2]
+	if m.idx%2 == 1 {
+		return ctx.PushState(m.val.MapIndex(key))
+	}
+	if len(m.keyBytes) > 0 {
+		// key already encoded
+		if _, err := ctx.Write(m.keyBytes[m.idx/2]); err != nil {
+			return err
+		}
+		m.idx++
+		return ctx.PushState(m.val.MapIndex(key))
+	}
+	return ctx.PushState(key)
+}
+
+func (m *mapEncoder) Index() int {
+	return m.idx
+}
+
+// structEncoder writes the fields of struct, and zero values for the orders skipped
+type structEncoder struct {
+	val    reflect.Value
+	fields []fieldName
+	idx    int
+}
+
+var typeOfStructEncoder = reflect.TypeOf((*structEncoder)(nil)).Elem()
+
+func (s *structEncoder) String() string {
+	if s == nil {
+		return "structEnc<nil>"
+	}
+	return fmt.Sprintf("structEnc[%d/%d]", s.idx, len(s.fields))
+}
+
+func (s *structEncoder) Element(ctx *EncodeContext) error {
+	s.idx++
+	if s.idx >= len(s.fields) {
+		return ctx.PopState()
+	}
+	order := -1
+	if s.idx > 0 {
+		order = s.fields[s.idx-1].order
+	}
+	field := s.fields[s.idx]
+	if field.order > order+1 {
+		if _, err := zerosPlacehold(ctx.tw, field.order-order-1, ctx.opts.CompactZeros); err != nil {
+			return err
+		}
+	}
+	return ctx.PushState(s.val.Field(field.index))
+}
+
+func (s *structEncoder) Index() int {
+	return s.idx
+}
+
+// typedEncoder writes the value of [id, value] of an interface in the typed interfaces mode
+type typedEncoder struct {
+	val reflect.Value
+	idx int
+}
+
+var typeOfTypedEncoder = reflect.TypeOf((*typedEncoder)(nil)).Elem()
+
+func (t *typedEncoder) String() string {
+	return fmt.Sprintf("typedEnc[%d/2]", t.idx)
+}
+
+func (t *typedEncoder) Element(ctx *EncodeContext) error {
+	t.idx++
+	if t.idx > 1 {
+		return ctx.PopState()
+	}
+	return ctx.PushState(t.val)
+}
+
+func (t *typedEncoder) Index() int {
+	return t.idx
+}
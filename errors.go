@@ -0,0 +1,192 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// DecodeError is returned by Decode (and Unmarshal) when decoding failed, it records where the
+// failure occurred. The cause could be checked by errors.Is/errors.As, e.g.
+//
+//	errors.Is(err, rtl.ErrNestingOverflow)
+type DecodeError struct {
+	// number of bytes read from the stream when failed, -1 if the reader could not tell
+	Offset int
+	// path of the Go value being decoded, e.g. Block.Txs[12].Amount
+	Path string
+	// Go type of the value being decoded
+	Type reflect.Type
+	// header of the value found in the stream, THInvalid if the header has not been read
+	Header TypeHeader
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	buf := new(strings.Builder)
+	buf.WriteString("rtl: decode")
+	if e.Path != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(e.Path)
+	}
+	if e.Type != nil {
+		fmt.Fprintf(buf, " (%v)", e.Type)
+	}
+	if e.Offset >= 0 {
+		fmt.Fprintf(buf, " at offset %d", e.Offset)
+	}
+	if e.Header.IsValid() {
+		fmt.Fprintf(buf, " with %s", e.Header)
+	}
+	buf.WriteString(" failed: ")
+	if e.Err != nil {
+		buf.WriteString(e.Err.Error())
+	} else {
+		buf.WriteString("<nil>")
+	}
+	return buf.String()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// offsetOf returns the number of bytes read from the stream, or -1 if unknown
+func offsetOf(stream interface{}) int {
+	if o, ok := stream.(interface{ Offset() int }); ok {
+		return o.Offset()
+	}
+	return -1
+}
+
+// newDecodeError returns err if it's a *DecodeError already (which is more precise), or wraps it
+// with the current offset of stream.
+func newDecodeError(stream interface{}, typ reflect.Type, th TypeHeader, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*DecodeError); ok {
+		return err
+	}
+	return &DecodeError{Offset: offsetOf(stream), Type: typ, Header: th, Err: err}
+}
+
+// prependPath prepends segment (e.g. ".Name" or "[3]") to the path of err, if it's a *DecodeError
+func prependPath(err error, segment string) error {
+	if de, ok := err.(*DecodeError); ok {
+		de.Path = segment + de.Path
+	}
+	return err
+}
+
+func indexSegment(i int) string {
+	return fmt.Sprintf("[%d]", i)
+}
+
+func keySegment(key reflect.Value) string {
+	if key.IsValid() && key.CanInterface() {
+		return fmt.Sprintf("[%v]", key.Interface())
+	}
+	return "[?]"
+}
+
+// rootDecodeError completes the path of err with the name of the type being decoded. An io.EOF
+// before reading anything (the offset of the stream is still start) is returned as it is, so that
+// the end of a stream could be detected by err == io.EOF. If the stream could not tell its offset
+// (start or offset is -1), nothing is read if no header has been read at the root.
+func rootDecodeError(typ reflect.Type, err error, start, offset int) error {
+	de, ok := err.(*DecodeError)
+	if !ok {
+		return err
+	}
+	if de.Err == io.EOF {
+		nothingRead := offset == start
+		if start < 0 || offset < 0 {
+			nothingRead = de.Path == "" && !de.Header.IsValid()
+		}
+		if nothingRead {
+			return io.EOF
+		}
+	}
+	name := typ.Name()
+	if name == "" {
+		name = typ.String()
+	}
+	de.Path = name + de.Path
+	return de
+}
+
+// FrameError is returned by StreamDecoder in framed mode, if the value in the frame at Offset
+// could not be decoded. The frame has been skipped, the next value could be decoded by calling
+// Decode again.
+type FrameError struct {
+	// number of bytes read from the stream before the frame
+	Offset int
+	Err    error
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("rtl: frame at offset %d failed: %v", e.Offset, e.Err)
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// truncatedError returns the error of decoding a value started at offset start, and failed at
+// offset. An io.EOF after reading some bytes of the value means the stream is truncated, which is
+// reported as io.ErrUnexpectedEOF, the original error is kept in the chain if it's not io.EOF
+// itself. The end of the stream (io.EOF before reading anything) is returned as it is.
+func truncatedError(err error, start, offset int) error {
+	if err == nil || offset <= start || !errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if de, ok := err.(*DecodeError); ok {
+		if de.Err == io.EOF {
+			de.Err = io.ErrUnexpectedEOF
+		} else {
+			de.Err = &unexpectedEOF{err: de.Err}
+		}
+		return de
+	}
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return &unexpectedEOF{err: err}
+}
+
+// unexpectedEOF is an io.ErrUnexpectedEOF caused by err, which could still be found by
+// errors.Is and errors.As
+type unexpectedEOF struct {
+	err error
+}
+
+func (e *unexpectedEOF) Error() string {
+	return fmt.Sprintf("%v: %v", e.err, io.ErrUnexpectedEOF)
+}
+
+func (e *unexpectedEOF) Unwrap() error {
+	return e.err
+}
+
+func (e *unexpectedEOF) Is(target error) bool {
+	return target == io.ErrUnexpectedEOF
+}
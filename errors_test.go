@@ -0,0 +1,170 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type (
+	errTx struct {
+		To     string
+		Amount uint64
+	}
+	errBlock struct {
+		Height uint64
+		Txs    []*errTx
+		Extra  map[string]errTx
+	}
+	// same layout as errBlock, but Amount is a string
+	badTx struct {
+		To     string
+		Amount string
+	}
+	badBlock struct {
+		Height uint64
+		Txs    []*badTx
+		Extra  map[string]badTx
+	}
+)
+
+func TestDecodeError(t *testing.T) {
+	bad := &badBlock{
+		Height: 10,
+		Txs:    []*badTx{{To: "a", Amount: "1"}, {To: "b", Amount: "not a number"}},
+	}
+	bs, err := Marshal(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// offset of the header of the bad amount
+	offset := bytes.Index(bs, []byte("not a number")) - 1
+
+	badExtra := &badBlock{Extra: map[string]badTx{"x": {Amount: "yes"}}}
+	extra, err := Marshal(badExtra)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forEachEngine(t, func(t *testing.T) {
+		var de *DecodeError
+		err := Unmarshal(bs, new(errBlock))
+		if !errors.As(err, &de) {
+			t.Fatalf("decode error expected, but got: %v", err)
+		}
+		if de.Path != "errBlock.Txs[1].Amount" || de.Type != reflect.TypeOf(uint64(0)) ||
+			de.Header != THStringSingle || de.Offset < offset+1 || de.Offset > len(bs) {
+			t.Errorf("decode error got: %+v (offset of header %d), %v", *de, offset, err)
+		}
+		t.Log(err)
+
+		if err := Unmarshal(extra, new(errBlock)); !errors.As(err, &de) || de.Path != "errBlock.Extra[x].Amount" {
+			t.Errorf("decode error of map value got: %v", err)
+		}
+
+		// sentinel errors
+		err = NewDecoder(bytes.NewReader(bs), WithMaxNested(1)).Decode(new(errBlock))
+		if !errors.Is(err, ErrNestingOverflow) || !errors.As(err, &de) || de.Path != "errBlock.Txs[0]" {
+			t.Errorf("nesting overflow expected, but got: %v", err)
+		}
+		txs, _ := Marshal([]errTx{{}, {}, {}})
+		var two [2]errTx
+		err = NewDecoder(bytes.NewReader(txs), WithStrict()).Decode(&two)
+		if !errors.Is(err, ErrLength) || !errors.As(err, &de) || de.Header != THArraySingle {
+			t.Errorf("length error expected, but got: %v", err)
+		}
+
+		// end of stream
+		if err := Decode(bytes.NewReader(nil), new(errBlock)); err != io.EOF {
+			t.Errorf("io.EOF expected, but got: %v", err)
+		}
+		err = Unmarshal(bs[:offset], new(errBlock))
+		if !errors.Is(err, io.EOF) || !errors.As(err, &de) || de.Path != "errBlock.Txs[1].Amount" ||
+			de.Offset != offset {
+			t.Errorf("truncated error got: %v", err)
+		}
+	})
+}
+
+func TestDecodeErrorEndOfStream(t *testing.T) {
+	// the Decoder reads the header, and fails with io.EOF
+	truncated := []byte{0xc5, 'a', 'b'}
+	forEachEngine(t, func(t *testing.T) {
+		p := new(*keptBytes)
+		err := Unmarshal(truncated, &p)
+		var de *DecodeError
+		if err == io.EOF || !errors.As(err, &de) || !errors.Is(err, io.EOF) {
+			t.Errorf("truncated value of Decoder got: %v", err)
+		}
+		if err := Unmarshal(nil, &p); err != io.EOF {
+			t.Errorf("empty stream got: %v", err)
+		}
+	})
+}
+
+// shortRead is an error of a Decoder caused by io.EOF
+type shortRead struct {
+	want int
+}
+
+func (e *shortRead) Error() string { return fmt.Sprintf("short read of %d bytes", e.want) }
+func (e *shortRead) Unwrap() error { return io.EOF }
+
+// shortReader fails with *shortRead after reading a byte
+type shortReader struct{}
+
+func (s *shortReader) Deserialization(r io.Reader) (bool, error) {
+	if _, err := ValueReaderOf(r).ReadByte(); err != nil {
+		return false, err
+	}
+	return false, &shortRead{want: 2}
+}
+
+func TestTruncatedErrorChain(t *testing.T) {
+	for _, cause := range []error{
+		&shortRead{want: 1},
+		&DecodeError{Offset: 1, Err: &shortRead{want: 1}},
+		fmt.Errorf("read header: %w", io.EOF),
+	} {
+		err := truncatedError(cause, 0, 1)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("truncated %v got: %v", cause, err)
+		}
+		var sr *shortRead
+		if errors.As(cause, &sr) && !errors.As(err, &sr) {
+			t.Errorf("cause of %v lost: %v", cause, err)
+		}
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("cause of %v lost: %v", cause, err)
+		}
+		if again := truncatedError(err, 0, 2); again != err {
+			t.Errorf("truncated again got: %v", again)
+		}
+	}
+
+	dec := NewStreamDecoder(bytes.NewReader([]byte{0x05}))
+	err := dec.Decode(new(shortReader))
+	var sr *shortRead
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !errors.As(err, &sr) || sr.want != 2 {
+		t.Errorf("truncated stream got: %v", err)
+	}
+}
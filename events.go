@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"strings"
 	"sync"
 )
 
@@ -32,6 +33,8 @@ type handleState struct {
 	th     TypeHeader
 	length int
 	buf    []byte
+	// struct version read from the stream before the value, noVersion means not found
+	version int
 	// NestedHandler with the current nested kind val object parsing state, created when val is
 	// pushed to the stack for the first time, and collected when it is popped from the stack
 	handler NestedHandler
@@ -83,6 +86,8 @@ func (s *handleState) updateValue(val reflect.Value) error {
 type HandleContext struct {
 	// input reader
 	vr ValueReader
+	// options attached to the input reader
+	opts *Options
 	// top of stack (last handleState) is the current processing value
 	stack       []*handleState
 	stackPool   sync.Pool
@@ -95,6 +100,10 @@ type HandleContext struct {
 // 	ctx.counter[name] = c + 1
 // }
 
+func (ctx *HandleContext) options() *Options {
+	return ctx.opts
+}
+
 func NewHandleContext(r io.Reader) *HandleContext {
 	vr, ok := r.(ValueReader)
 	if !ok {
@@ -102,6 +111,7 @@ func NewHandleContext(r io.Reader) *HandleContext {
 	}
 	ctx := &HandleContext{
 		vr:    vr,
+		opts:  optionsOf(vr),
 		stack: nil,
 		stackPool: sync.Pool{New: func() interface{} {
 			return &handleState{}
@@ -148,6 +158,10 @@ func (ctx *HandleContext) top() (*handleState, bool) {
 	return ctx.stack[len(ctx.stack)-1], true
 }
 
+// PopState removes the top state from the stack, which means decoding of the current value is
+// finished. The NestedHandler of the state (if any) is recycled if it's created by NewNested.
+// Then the decoder continues with the state below, which is usually the parent value waiting for
+// its next element.
 func (ctx *HandleContext) PopState() error {
 	if len(ctx.stack) == 0 {
 		return ErrEmptyStack
@@ -168,22 +182,34 @@ func (ctx *HandleContext) PopState() error {
 	return nil
 }
 
+// PushState pushes a new state for decoding val on the top of the stack, val must be settable.
+// Usually it's called by a NestedHandler to decode the next element into val, with th=THInvalid,
+// length=0, buf=nil and handler=nil, so that the header of the element will be read from the
+// stream. It fails with ErrNestingOverflow if the stack is deeper than Options.MaxNested.
 func (ctx *HandleContext) PushState(val reflect.Value, th TypeHeader, length int, buf []byte, handler NestedHandler) error {
 	if !val.IsValid() {
 		return ErrInvalidValue
 	}
+	if err := ctx.opts.checkNesting(len(ctx.stack)); err != nil {
+		return err
+	}
 	state := ctx.stackPool.Get().(*handleState)
 	state.val = val
 	state.typ = val.Type()
 	state.th = th
 	state.length = length
 	state.buf = buf
+	state.version = noVersion
 	state.handler = handler
 	ctx.stack = append(ctx.stack, state)
 	// ctx._count("push")
 	return nil
 }
 
+// ReplaceStack replaces the value of the top state with val, keeping the header (and the bytes)
+// already read, so that the same event will be dispatched again to the handler of the type of
+// val. It's used to delegate the decoding to another value, e.g. an addressable struct value to
+// the pointer of it, or a pointer to the element it points to.
 func (ctx *HandleContext) ReplaceStack(val reflect.Value) error {
 	if len(ctx.stack) == 0 {
 		return ErrEmptyStack
@@ -192,6 +218,11 @@ func (ctx *HandleContext) ReplaceStack(val reflect.Value) error {
 	return ctx.stack[len(ctx.stack)-1].updateValue(val)
 }
 
+// NestedStack attaches handler to the top state, which is used when the value is an array in the
+// stream (EventHandler.Array). After that, instead of reading headers, the decoder calls
+// handler.Element every time the state is on the top of the stack, until the state is popped.
+// Element should push a state for the next element (PushState), or pop the state (PopState) if
+// all the elements have been decoded.
 func (ctx *HandleContext) NestedStack(handler NestedHandler) error {
 	if len(ctx.stack) == 0 {
 		return ErrEmptyStack
@@ -204,16 +235,69 @@ func (ctx *HandleContext) NestedStack(handler NestedHandler) error {
 	return nil
 }
 
+// pathSegmenter is implemented by the NestedHandlers of the package, describes the element being
+// decoded, such as ".Name", "[3]", in the path of DecodeError
+type pathSegmenter interface {
+	pathSegment() string
+}
+
+// decodeError wraps err with the position of state, which is the state being handled, its path is
+// built from the elements being decoded by the NestedHandlers in the stack.
+func (ctx *HandleContext) decodeError(state *handleState, err error) error {
+	path := new(strings.Builder)
+	for _, s := range ctx.stack {
+		if s.handler == nil {
+			continue
+		}
+		if segmenter, ok := s.handler.(pathSegmenter); ok {
+			path.WriteString(segmenter.pathSegment())
+		} else if i := s.handler.Index(); i >= 0 {
+			path.WriteString(indexSegment(i))
+		}
+	}
+	de := &DecodeError{Offset: offsetOf(ctx.vr), Path: path.String(), Header: THInvalid, Err: err}
+	if state != nil {
+		de.Type = state.typ
+		de.Header = state.th
+	}
+	return de
+}
+
+// keepVersion records the struct version of the current state, and resets the type header of it, so
+// that the header of the value following the version could be read.
+func (ctx *HandleContext) keepVersion(inputs []byte) error {
+	state, exist := ctx.top()
+	if !exist {
+		return ErrEmptyStack
+	}
+	if state.handler != nil {
+		return errors.New("invalid version when state in handling")
+	}
+	if state.version != noVersion {
+		return errVersionFollowed
+	}
+	state.version = versionNumber(inputs)
+	state.th = THInvalid
+	state.length = 0
+	state.buf = nil
+	return nil
+}
+
+// SkipReader skips the next length values in the stream, it's used to ignore the elements not
+// needed, e.g. the extra elements of an array.
 func (ctx *HandleContext) SkipReader(length int) error {
 	for i := 0; i < length; i++ {
 		if _, err := ctx.vr.Skip(); err != nil {
-			return fmt.Errorf("reader skipping %d/%d failed: %v", i, length, err)
+			return fmt.Errorf("reader skipping %d/%d failed: %w", i, length, err)
 		}
 	}
 	// ctx._count("skip")
 	return nil
 }
 
+// NewNested returns a pointer to a value of typ from the pool of the context, which could be used
+// as a NestedHandler. It will be put back to the pool when the state holding it is popped, so the
+// handler must be reset after getting from NewNested.
 func (ctx *HandleContext) NewNested(typ reflect.Type) interface{} {
 	if ctx.nestedPools == nil {
 		ctx.nestedPools = make(map[reflect.Type]*sync.Pool)
@@ -231,6 +315,20 @@ func (ctx *HandleContext) NewNested(typ reflect.Type) interface{} {
 }
 
 type (
+	// EventHandler handles the events of decoding a value from the stream by EventDecoder. Each
+	// method is called with the value of the top state of the HandleContext stack, when the
+	// header of the value has been read. For example, Number is called with the bytes of the
+	// number, and Array with the number of the elements. The handler must change the stack after
+	// handling an event, by one of:
+	//
+	//   - PopState: the value has been decoded
+	//   - ReplaceStack: let the handler of another value handle the same event
+	//   - NestedStack: decode the elements of an array by a NestedHandler, or SkipReader and
+	//     PopState to ignore them
+	//
+	// Version is called for the struct version prefix (followed by the header of the value),
+	// handlers not supporting it should return ErrUnsupported, as all the methods of
+	// DefaultEventHandler do, which could be embedded to handle only the events needed.
 	EventHandler interface {
 		Byte(ctx *HandleContext, value reflect.Value, input byte) error
 		Zero(ctx *HandleContext, value reflect.Value) error
@@ -265,6 +363,8 @@ func (h DefaultEventHandler) Version(_ *HandleContext, _ reflect.Value, _ ...byt
 }
 
 type (
+	// NestedHandler decodes the elements of an array in the stream into the value of a state,
+	// see HandleContext.NestedStack. Index returns the index of the element being decoded.
 	NestedHandler interface {
 		String() string
 		Element(ctx *HandleContext) error
@@ -289,11 +389,47 @@ func _systemTypeHandler(handler EventHandler, typs ...reflect.Type) {
 	}
 }
 
+// EventDecoder decodes values by the EventHandlers of their types, which are looked up in the
+// order of: handlers registered by RegisterTypeHandler, built-in handlers of types (big.Int,
+// time.Time...), handlers registered by RegisterKindHandler and built-in handlers of kinds.
+// Values implementing Decoder are always decoded by themselves. The zero value is ready to use.
 type EventDecoder struct {
 	kindHandlers map[reflect.Kind]EventHandler
 	typeHandlers map[reflect.Type]EventHandler
 }
 
+// RegisterTypeHandler registers handler for the values of typ, so that the types not supported
+// (e.g. a type of a third-party package) could be decoded without implementing Decoder. A nil
+// handler removes the registration. Handlers should be registered before decoding, it's not safe
+// to register concurrently with Decode.
+func (e *EventDecoder) RegisterTypeHandler(typ reflect.Type, handler EventHandler) {
+	if typ == nil {
+		panic("rtl: register handler for nil type")
+	}
+	if handler == nil {
+		delete(e.typeHandlers, typ)
+		return
+	}
+	if e.typeHandlers == nil {
+		e.typeHandlers = make(map[reflect.Type]EventHandler)
+	}
+	e.typeHandlers[typ] = handler
+}
+
+// RegisterKindHandler registers handler for the values of kind, which replaces the built-in
+// handler of kind. A nil handler removes the registration. Handlers should be registered before
+// decoding, it's not safe to register concurrently with Decode.
+func (e *EventDecoder) RegisterKindHandler(kind reflect.Kind, handler EventHandler) {
+	if handler == nil {
+		delete(e.kindHandlers, kind)
+		return
+	}
+	if e.kindHandlers == nil {
+		e.kindHandlers = make(map[reflect.Kind]EventHandler)
+	}
+	e.kindHandlers[kind] = handler
+}
+
 func (e *EventDecoder) runDecoder(r io.Reader, value reflect.Value, typ reflect.Type) (bool, error) {
 	if typ.Implements(TypeOfDecoder) {
 		newCreate := false
@@ -317,6 +453,10 @@ func (e *EventDecoder) runDecoder(r io.Reader, value reflect.Value, typ reflect.
 }
 
 func (e *EventDecoder) checkTypeOfDecoder(r io.Reader, value reflect.Value) (bool, error) {
+	if decoder, ok := rawValueDecoder(value); ok {
+		_, err := decoder.Deserialization(r)
+		return true, err
+	}
 	typ := value.Type()
 	isDecoder, err := e.runDecoder(r, value, typ)
 	if isDecoder || err != nil {
@@ -341,7 +481,7 @@ func (e *EventDecoder) _getTypeHandler(typ reflect.Type) (EventHandler, error) {
 	if exist && handler != nil {
 		return handler, nil
 	}
-	return nil, nil
+	return priorTypeHandler(typ), nil
 }
 
 func (e *EventDecoder) _getKindHandler(kind reflect.Kind) (EventHandler, error) {
@@ -376,14 +516,13 @@ func (e *EventDecoder) handle(ctx *HandleContext) error {
 		if state.handler != nil {
 			err = state.handler.Element(ctx)
 			if err != nil {
-				return fmt.Errorf("rtl: element(%d) handle failed: %v, at %s",
-					state.handler.Index(), err, ctx.StackInfo())
+				return ctx.decodeError(state, err)
 			}
 		} else {
 			if !state.th.IsValid() {
 				isDecoder, err := e.checkTypeOfDecoder(ctx.vr, state.val)
 				if err != nil {
-					return err
+					return ctx.decodeError(state, err)
 				}
 				if isDecoder {
 					if err = ctx.PopState(); err != nil {
@@ -394,29 +533,30 @@ func (e *EventDecoder) handle(ctx *HandleContext) error {
 
 				th, length, err := ctx.vr.ReadFullHeader()
 				if err != nil {
-					return fmt.Errorf("rtl: read header failed: %v, at %s", err, ctx.StackInfo())
+					return ctx.decodeError(state, err)
 				}
 				state.th = th
 				state.length = length
 
-				if th.FollowedByBytes() {
+				if th.FollowedByBytes() || th == THVersionSingle {
 					buf, err := ctx.vr.ReadBytes(state.length, nil)
 					if err != nil {
-						return fmt.Errorf("rtl: read value failed: %v, at %s", err, ctx.StackInfo())
+						return ctx.decodeError(state, err)
 					}
 					state.buf = buf
 				}
 			}
 
-			handler, err := e._getTypeHandler(state.typ)
+			typ := state.typ
+			handler, err := e._getTypeHandler(typ)
 			if err != nil {
-				return fmt.Errorf("rtl: get handler for type %s failed: %v", state.typ.Name(), err)
+				return ctx.decodeError(state, fmt.Errorf("get handler for type %s failed: %w", state.typ.Name(), err))
 			}
 			if handler == nil {
 				handler, err = e._getKindHandler(state.typ.Kind())
 				if err != nil || handler == nil {
-					return fmt.Errorf("rtl: get handler for type:%s, kind:%s failed: %v",
-						state.typ.Name(), state.typ.Kind(), err)
+					return ctx.decodeError(state, fmt.Errorf("get handler for type:%s, kind:%s failed: %v",
+						state.typ.Name(), state.typ.Kind(), err))
 				}
 			}
 
@@ -439,10 +579,16 @@ func (e *EventDecoder) handle(ctx *HandleContext) error {
 				err = handler.Version(ctx, state.val, byte(state.length))
 			case THVersionSingle:
 				err = handler.Version(ctx, state.val, state.buf...)
+			case THZeros:
+				err = fmt.Errorf("%w: a run of zero values is not an element of struct", ErrDecode)
 			}
 
 			if err != nil {
-				return fmt.Errorf("rtl: header handle failed: %v, at %s", err, ctx.StackInfo())
+				return ctx.decodeError(state, err)
+			}
+			if top, _ := ctx.top(); top == state && state.handler == nil && state.th.IsValid() && state.typ == typ {
+				// the same event would be dispatched to the same handler forever
+				return ctx.decodeError(state, fmt.Errorf("%T did not change the state of %s", handler, state.th))
 			}
 		}
 	}
@@ -474,7 +620,8 @@ func (e *EventDecoder) Decode(r io.Reader, obj interface{}) error {
 	}
 	rtyp := rev.Type()
 	rkind := rtyp.Kind()
-	if !canBeDecodeTo(rkind) {
+	if !canBeDecodeTo(rkind) && rkind != reflect.Interface &&
+		e.typeHandlers[rtyp] == nil && e.kindHandlers[rkind] == nil {
 		return fmt.Errorf("unsupported decoding to %v (kind: %s)", rtyp, rkind.String())
 	}
 
@@ -487,7 +634,9 @@ func (e *EventDecoder) Decode(r io.Reader, obj interface{}) error {
 	if err := ctx.PushState(rev, THInvalid, 0, nil, nil); err != nil {
 		return err
 	}
-	err = e.handle(ctx)
-	// log.Printf("%s", ctx)
-	return err
+	start := offsetOf(vr)
+	if err = e.handle(ctx); err != nil {
+		return rootDecodeError(rtyp, err, start, offsetOf(vr))
+	}
+	return nil
 }
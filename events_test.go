@@ -19,9 +19,163 @@ package rtl
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
 	"testing"
 )
 
+type (
+	// a third-party type which could not be decoded directly, like decimal.Decimal
+	eventDecimal struct {
+		value *big.Int
+		exp   int32
+	}
+
+	// decodes eventDecimal from a string "123.45", or an array [12345, -2]
+	eventDecimalHandler struct {
+		DefaultEventHandler
+	}
+
+	eventDecimalElement struct {
+		val   reflect.Value
+		index int
+		value *big.Int
+		exp   int32
+	}
+
+	// decodes bool from "yes" or "no"
+	eventYesNoHandler struct {
+		DefaultEventHandler
+	}
+
+	// does not change the state
+	eventLazyHandler struct {
+		DefaultEventHandler
+	}
+
+	eventRecord struct {
+		Price  eventDecimal
+		Cost   *eventDecimal
+		Done   bool
+		Prices []eventDecimal
+	}
+)
+
+func (eventDecimalHandler) Bytes(ctx *HandleContext, value reflect.Value, inputs []byte) error {
+	s := string(inputs)
+	exp := 0
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		exp = i - len(s) + 1
+		s = s[:i] + s[i+1:]
+	}
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return fmt.Errorf("illegal decimal %q", inputs)
+	}
+	value.Set(reflect.ValueOf(eventDecimal{value: v, exp: int32(exp)}))
+	return ctx.PopState()
+}
+
+func (eventDecimalHandler) Array(ctx *HandleContext, value reflect.Value, length int) error {
+	if length != 2 {
+		return fmt.Errorf("2 elements expected, but %d", length)
+	}
+	return ctx.NestedStack(&eventDecimalElement{val: value, index: -1, value: new(big.Int)})
+}
+
+func (e *eventDecimalElement) String() string { return fmt.Sprintf("decimal[%d]", e.index) }
+func (e *eventDecimalElement) Index() int     { return e.index }
+
+func (e *eventDecimalElement) Element(ctx *HandleContext) error {
+	e.index++
+	switch e.index {
+	case 0:
+		return ctx.PushState(reflect.ValueOf(e.value).Elem(), THInvalid, 0, nil, nil)
+	case 1:
+		return ctx.PushState(reflect.ValueOf(&e.exp).Elem(), THInvalid, 0, nil, nil)
+	default:
+		e.val.Set(reflect.ValueOf(eventDecimal{value: e.value, exp: e.exp}))
+		return ctx.PopState()
+	}
+}
+
+func (eventYesNoHandler) Zero(ctx *HandleContext, value reflect.Value) error {
+	value.SetBool(false)
+	return ctx.PopState()
+}
+
+func (eventYesNoHandler) Bytes(ctx *HandleContext, value reflect.Value, inputs []byte) error {
+	switch string(inputs) {
+	case "yes":
+		value.SetBool(true)
+	case "no":
+		value.SetBool(false)
+	default:
+		return fmt.Errorf("illegal bool %q", inputs)
+	}
+	return ctx.PopState()
+}
+
+func (eventLazyHandler) Bytes(_ *HandleContext, _ reflect.Value, _ []byte) error {
+	return nil
+}
+
+func TestEventHandlers(t *testing.T) {
+	bs, err := Marshal([]interface{}{
+		"123.45",
+		[]interface{}{1000, -1},
+		"yes",
+		[]interface{}{"0.5", []interface{}{-7, 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := new(EventDecoder)
+	if err := decoder.Decode(bytes.NewReader(bs), new(eventRecord)); err == nil {
+		t.Fatal("decode without handlers should fail")
+	}
+
+	decoder.RegisterTypeHandler(reflect.TypeOf(eventDecimal{}), eventDecimalHandler{})
+	decoder.RegisterKindHandler(reflect.Bool, eventYesNoHandler{})
+	got := new(eventRecord)
+	if err := decoder.Decode(bytes.NewReader(bs), got); err != nil {
+		t.Fatal(err)
+	}
+	want := &eventRecord{
+		Price: eventDecimal{value: big.NewInt(12345), exp: -2},
+		Cost:  &eventDecimal{value: big.NewInt(1000), exp: -1},
+		Done:  true,
+		Prices: []eventDecimal{
+			{value: big.NewInt(5), exp: -1},
+			{value: big.NewInt(-7), exp: 2},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decode got %+v, want %+v", got, want)
+	}
+
+	// handlers are not shared between decoders
+	if err := new(EventDecoder).Decode(bytes.NewReader(bs), new(eventRecord)); err == nil {
+		t.Error("decode with another decoder should fail")
+	}
+
+	// remove the kind handler, then "yes" could not be decoded to bool
+	decoder.RegisterKindHandler(reflect.Bool, nil)
+	if err := decoder.Decode(bytes.NewReader(bs), new(eventRecord)); err == nil {
+		t.Error("decode after removing the handler should fail")
+	}
+
+	// handler must change the stack
+	decoder.RegisterTypeHandler(reflect.TypeOf(eventDecimal{}), eventLazyHandler{})
+	var d eventDecimal
+	if err := decoder.Decode(bytes.NewReader(bs[1:]), &d); err == nil || !strings.Contains(err.Error(), "did not change") {
+		t.Errorf("decode by a handler not changing the stack got: %v", err)
+	}
+}
+
 func TestDecode(t *testing.T) {
 	stream, err := hex.DecodeString("959c94d47a0e5d870b13de0d0477a96c9680aca69ff1ad10c095d29d9cf6b79486ca9dbe34255748ffe247d8e206420cb6d0a606a90877afa9e122fb844913ee76cfea884919d56d045308aad271dd71836ee071b883e1fab47fc920af8094d46189c19778df0c695405101303c2e07d6bf6ca8cc081f73697eee00615f2709ce8934c66b0b318d6daf865b0ee85f70b9419464a55d2cf28c93d342a4e3e1481d755e1a4c435b7d68094d41adc5545e33a1dee6e78ffa0c28dc005efe20c6ac0b2c7021478445fcf2f0ab8e9001997616f4d38947a6902d52833a1786d43c018c7631c02c3eb2b1f8094d4c16a9a755a633f70431ede2ece0c3fa492259163c0ee5a466c2fc48023c73dc07c59e97f0f1af32c8150223b47af49d2fbc971d6d5c649c87ebd2a108094d4f94d8683ca4e0a366288fb2db51d1d97f368b94fc0c22c60a13128276291f1fae10fe3df5d3449ae6815cf2b82a70f32bdd0cb468fd58209f6f66f6b43e3d87f3d153ee581368f8e034c728094d43f92af1e9dc5f97f50bdc09873b35a39875a8b3ac0dcda1aeb9cdbd35b4737ce2eb0f5aa409cbb0f0f44ecb5177a5a9bb17ce14b01ddfa76bc07b828840549cd830fe23cee720d6efb7aa5b877e8aa002867418094d40e8c0caf536326f17d3c0a8ef3e227903c5d4469c09837d34545a5bc542ef8942bbe26a249fd096ea2e41af66e686d45f9c8c02bb5c69bbbc60d33738094d4d94145af96cd4e130251c61a7e091b7e9ae8957cc071f4fb267d9827ee599487e468209d5264d09c7bb42c43e56fbe3044a05e857ccea3704e0732c5219253f9b5e8e1008094d472a33e79adb6f2cbd6e4269a21e5c68a9af43cdbc000a581b7a1eccfc08d850fe6c81d6cd4d99fa6deb21689a7d8c853fb32fcc9d7dabd5fe024ead8d850aa12dd15c073cab98fed3b0743d2b4d7ef178094d43b66603053dfda851aff3a34ed5361eb31699cfcc0804cbb8ef2514cd49b9668e9787fbca807b605af9565d2022963954b31b4aecac6eb46ffe720898094d4aa6c0124c3778e85f701976d1c810b0e59cc7bd5c00deccf3a5754120d479d335461ca563b24db7e055028f9f62a5320a5eee6e1f0e12195242c00513b5c019390236737301789db6c126df43a489781091fbae24ff544f68094d452bb70a8778e4815b2679c4ac0d67ab1553f7bdcc0b55446bd5895b6878bfd9c9e81ab83189741f08786b2643a47cfc075ea4f0773d5fed055eaa54db2518634c4388a60fb8b7aa1d976ad808918d43f92af1e9dc5f97f50bdc09873b35a39875a8b3aa0c028c8572bdcbee1d40e8c0caf536326f17d3c0a8ef3e227903c5d4469a0cb4f67f553531415d472a33e79adb6f2cbd6e4269a21e5c68a9af43cdba004ef5f668e1e8d4cd4aa6c0124c3778e85f701976d1c810b0e59cc7bd5a04f424bd846556e56d452bb70a8778e4815b2679c4ac0d67ab1553f7bdca07de5e25a337800dfd41adc5545e33a1dee6e78ffa0c28dc005efe20c6aa078f4fd291c4ad085d4c16a9a755a633f70431ede2ece0c3fa492259163a007b29b1088504a22d4f94d8683ca4e0a366288fb2db51d1d97f368b94fa009322278d1af23bad4d94145af96cd4e130251c61a7e091b7e9ae8957ca00c90ebce2acd1c06d43b66603053dfda851aff3a34ed5361eb31699cfca02dd856dff94b8d10d47a0e5d870b13de0d0477a96c9680aca69ff1ad10a0dede62369b774a7ed46189c19778df0c695405101303c2e07d6bf6ca8ca0a2463a4f6e5aca760c80a43f263e90")
 	if err != nil {
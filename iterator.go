@@ -0,0 +1,202 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// ArrayIterator decodes the elements of an array value in the stream one at a time, so that a
+// huge array could be read without creating the whole slice, and it's not limited by
+// Options.MaxSliceSize. Options.MaxBytes is applied to each element.
+//
+//	it := rtl.NewArrayIterator(r, reflect.TypeOf(Row{}))
+//	for row := new(Row); it.Next(row); row = new(Row) {
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// After all the elements are read, the reader is at the end of the array value.
+type ArrayIterator struct {
+	vr     *defaultVR
+	typ    reflect.Type // type of the elements, nil for any
+	length int
+	index  int // index of the next element
+	err    error
+}
+
+// NewArrayIterator reads the header of the array value in r, whose elements will be decoded as
+// elemType (nil for any type). Reuse the ValueReader as r to read the values after the array.
+func NewArrayIterator(r io.Reader, elemType reflect.Type, opts ...Option) *ArrayIterator {
+	vr, ok := r.(*defaultVR)
+	if !ok || len(opts) > 0 {
+		var options *Options
+		if len(opts) > 0 {
+			options = newOptions(opts...)
+		}
+		vr = newValueReader(r, options)
+	}
+	it := &ArrayIterator{vr: vr, typ: elemType}
+	it.length, it.err = it.readHeader()
+	return it
+}
+
+func (it *ArrayIterator) readHeader() (int, error) {
+	th, length, err := it.vr.ReadHeader()
+	if err != nil {
+		return 0, err
+	}
+	switch th {
+	case THZeroValue, THEmpty:
+		return 0, nil
+	case THArraySingle:
+		return length, nil
+	case THArrayMulti:
+		// not checked with the bytes left, the reader may be larger than MaxSliceSize
+		l, err := ReadMultiLengthFromReader(it.vr, length)
+		if err != nil {
+			return 0, err
+		}
+		if l > math.MaxInt32 {
+			return 0, fmt.Errorf("%w: array length %d", ErrTooLarge, l)
+		}
+		return int(l), nil
+	}
+	return 0, mismatchError("array", th)
+}
+
+// Len returns the number of elements of the array
+func (it *ArrayIterator) Len() int {
+	return it.length
+}
+
+// Index returns the index of the next element
+func (it *ArrayIterator) Index() int {
+	return it.index
+}
+
+// Next decodes the next element into v, which must be a pointer to the element type. It returns
+// false if there's no more element, or failed (see Err).
+func (it *ArrayIterator) Next(v interface{}) bool {
+	if it.err != nil || it.index >= it.length {
+		return false
+	}
+	if it.typ != nil {
+		if typ := reflect.TypeOf(v); typ == nil || typ.Kind() != reflect.Ptr || typ.Elem() != it.typ {
+			it.err = fmt.Errorf("rtl: %T is not a pointer to %v", v, it.typ)
+			return false
+		}
+	}
+	it.vr.resetLimit()
+	if err := Decode(it.vr, v); err != nil {
+		it.err = it.elementError(err)
+		return false
+	}
+	it.index++
+	return true
+}
+
+// Skip skips the next element without decoding it. It returns false if there's no more element,
+// or failed (see Err).
+func (it *ArrayIterator) Skip() bool {
+	if it.err != nil || it.index >= it.length {
+		return false
+	}
+	it.vr.resetLimit()
+	if _, err := it.vr.Skip(); err != nil {
+		it.err = it.elementError(err)
+		return false
+	}
+	it.index++
+	return true
+}
+
+// elementError wraps the error of the element at index, an io.EOF means the array is truncated
+// because the header claimed more elements.
+func (it *ArrayIterator) elementError(err error) error {
+	err = truncatedError(err, -1, it.vr.Offset())
+	return fmt.Errorf("rtl: element %d of %d: %w", it.index, it.length, err)
+}
+
+// Err returns the error occurred while iterating, nil if all elements are read successfully
+func (it *ArrayIterator) Err() error {
+	return it.err
+}
+
+// ArrayWriter writes an array value of a known number of elements one at a time. Encode must be
+// called exactly length times, which is checked by Close.
+type ArrayWriter struct {
+	w      *optionsWriter
+	length int
+	count  int
+}
+
+// NewArrayWriter writes the header of an array with length elements to w
+func NewArrayWriter(w io.Writer, length int, opts ...Option) (*ArrayWriter, error) {
+	if length < 0 {
+		return nil, fmt.Errorf("%w: array length %d", ErrLength, length)
+	}
+	options := optionsOf(w)
+	if len(opts) > 0 {
+		options = newOptions(opts...)
+	}
+	aw := &ArrayWriter{w: &optionsWriter{Writer: w, opts: options}, length: length}
+	var err error
+	if length == 0 {
+		// as same as an empty slice
+		_, err = aw.w.Write(emptyValues)
+	} else {
+		_, err = writeArrayHeader(aw.w, length)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// Encode writes v as the next element of the array
+func (a *ArrayWriter) Encode(v interface{}) error {
+	if a.count >= a.length {
+		return fmt.Errorf("%w: more than %d elements", ErrLength, a.length)
+	}
+	value := reflect.ValueOf(v)
+	var err error
+	if !value.IsValid() {
+		// nil interface
+		_, err = a.w.Write(zeroValues)
+	} else {
+		_, err = valueWriter0(a.w, value, 1)
+	}
+	if err != nil {
+		return err
+	}
+	a.count++
+	return nil
+}
+
+// Close checks whether all the elements have been written
+func (a *ArrayWriter) Close() error {
+	if a.count != a.length {
+		return fmt.Errorf("%w: %d of %d elements written", ErrLength, a.count, a.length)
+	}
+	return nil
+}
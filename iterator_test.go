@@ -0,0 +1,195 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func writeArray(t *testing.T, recs []*streamRecord) []byte {
+	buf := new(bytes.Buffer)
+	aw, err := NewArrayWriter(buf, len(recs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		if err := aw.Encode(rec); err != nil {
+			t.Fatalf("encode %+v failed: %v", rec, err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArrayIterator(t *testing.T) {
+	recs := streamRecords(1000)
+	bs := writeArray(t, recs)
+	marshaled, err := Marshal(recs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bs, marshaled) {
+		t.Fatalf("array writer got %x, want %x", bs, marshaled)
+	}
+
+	forEachEngine(t, func(t *testing.T) {
+		// not limited by the max slice size
+		it := NewArrayIterator(bytes.NewReader(bs), reflect.TypeOf(streamRecord{}), WithMaxSliceSize(10))
+		if it.Len() != len(recs) {
+			t.Fatalf("length got %d, want %d", it.Len(), len(recs))
+		}
+		for {
+			idx := it.Index()
+			if idx%3 == 1 {
+				if !it.Skip() {
+					break
+				}
+				continue
+			}
+			rec := new(streamRecord)
+			if !it.Next(rec) {
+				break
+			}
+			if !reflect.DeepEqual(rec, recs[idx]) {
+				t.Fatalf("element %d got %+v, want %+v", idx, rec, recs[idx])
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if it.Index() != len(recs) {
+			t.Fatalf("index got %d, want %d", it.Index(), len(recs))
+		}
+	})
+}
+
+func TestArrayIteratorFollowed(t *testing.T) {
+	// the values after the array could be read by the same reader
+	bs, _ := Marshal([]uint{1, 2, 3})
+	tail, _ := Marshal("tail")
+	vr := NewValueReader(bytes.NewReader(append(bs, tail...)), 0)
+	it := NewArrayIterator(vr, nil)
+	var sum uint
+	for u := uint(0); it.Next(&u); {
+		sum += u
+	}
+	if it.Err() != nil || sum != 6 {
+		t.Fatalf("sum got %d, err %v", sum, it.Err())
+	}
+	var s string
+	if err := Decode(vr, &s); err != nil || s != "tail" {
+		t.Fatalf("tail got %q, err %v", s, err)
+	}
+}
+
+func TestArrayIteratorEmpty(t *testing.T) {
+	for _, v := range []interface{}{[]uint(nil), []uint{}} {
+		bs, _ := Marshal(v)
+		it := NewArrayIterator(bytes.NewReader(bs), nil)
+		var u uint
+		if it.Len() != 0 || it.Next(&u) || it.Err() != nil {
+			t.Errorf("%x: length %d, err %v", bs, it.Len(), it.Err())
+		}
+	}
+	buf := new(bytes.Buffer)
+	aw, err := NewArrayWriter(buf, 0)
+	if err != nil || aw.Close() != nil || !bytes.Equal(buf.Bytes(), emptyValues) {
+		t.Fatalf("empty array writer got %x, err %v", buf.Bytes(), err)
+	}
+}
+
+func TestArrayIteratorErrors(t *testing.T) {
+	bs := writeArray(t, streamRecords(3))
+	typ := reflect.TypeOf(streamRecord{})
+
+	// truncated
+	it := NewArrayIterator(bytes.NewReader(bs[:len(bs)-3]), typ)
+	for it.Next(new(streamRecord)) {
+	}
+	if it.Index() != 2 || !errors.Is(it.Err(), io.ErrUnexpectedEOF) {
+		t.Errorf("truncated: index %d, err %v", it.Index(), it.Err())
+	}
+	it = NewArrayIterator(bytes.NewReader(bs[:len(bs)-3]), typ)
+	for it.Skip() {
+	}
+	if !errors.Is(it.Err(), io.ErrUnexpectedEOF) {
+		t.Errorf("truncated skip: err %v", it.Err())
+	}
+
+	// wrong type
+	it = NewArrayIterator(bytes.NewReader(bs), typ)
+	if it.Next(new(string)) || it.Err() == nil {
+		t.Errorf("wrong type should fail")
+	}
+
+	// not an array
+	str, _ := Marshal("abc")
+	it = NewArrayIterator(bytes.NewReader(str), nil)
+	if it.Next(new(string)) || it.Err() == nil {
+		t.Errorf("not an array should fail")
+	}
+	it = NewArrayIterator(bytes.NewReader(nil), nil)
+	if it.Next(new(string)) || it.Err() != io.EOF {
+		t.Errorf("empty stream got %v", it.Err())
+	}
+
+	// number of elements written
+	aw, err := NewArrayWriter(new(bytes.Buffer), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); !errors.Is(err, ErrLength) {
+		t.Errorf("close got %v", err)
+	}
+	if err := aw.Encode(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Encode(1); !errors.Is(err, ErrLength) {
+		t.Errorf("extra element got %v", err)
+	}
+	if _, err := NewArrayWriter(new(bytes.Buffer), -1); !errors.Is(err, ErrLength) {
+		t.Errorf("negative length got %v", err)
+	}
+}
+
+func TestArrayIteratorLong(t *testing.T) {
+	if testing.Short() {
+		t.Skip("iterates more than MaxSliceSize bytes")
+	}
+	bs, err := Marshal(&streamRecord{Seq: 1, Name: string(bytes.Repeat([]byte{'n'}, 1<<20))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := MaxSliceSize/len(bs) + 10
+	head := new(bytes.Buffer)
+	if err := NewTokenWriter(head).WriteArrayHeader(count); err != nil {
+		t.Fatal(err)
+	}
+	it := NewArrayIterator(repeatedReader(head.Bytes(), bs, count), reflect.TypeOf(streamRecord{}))
+	rec := new(streamRecord)
+	for it.Next(rec) {
+	}
+	if err := it.Err(); err != nil || it.Index() != count {
+		t.Errorf("iterated %d of %d: %v", it.Index(), count, err)
+	}
+}
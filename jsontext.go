@@ -0,0 +1,472 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSON text of RTL stream
+//
+// Each value in the stream is transcoded into a JSON object, whose key is the name of the
+// TypeHeader (TypeHeader.Name()) and value is:
+//
+//	{"Byte": 5}                        single byte, 0-127
+//	{"Zero": null}                     also "True" and "Empty"
+//	{"Array": [{"Byte": 1}, ...]}      also "Array+", elements are JSON objects of values
+//	{"PosNum": "1000"}                 also "NegNum", "PosNum+" and "NegNum+", the absolute value
+//	                                   in decimal, or the bytes in hex with "0x" prefix if there
+//	                                   are leading zeros
+//	{"String": "abc"}                  also "String+", or {"String": {"hex": "ff00"}} if the bytes
+//	                                   are not a valid UTF-8 string
+//	{"Zeros": 5}                       a run of zero values, the number of them
+//
+// The struct version prefix is another key of the object of the value following it, such as
+// {"Ver": 1, "Array": [...]}, "Ver+" is used for versions larger than 15 (the version number or
+// bytes in hex as the numbers). If the number of length bytes of a multi-bytes header ("Array+",
+// "PosNum+", "NegNum+" and "String+") or the count bytes of "Zeros" is not the minimum one, it's
+// recorded by the key "size".
+// So that the stream could be re-encoded byte-identically from the JSON text.
+
+const (
+	jsonKeySize = "size"
+	jsonKeyHex  = "hex"
+)
+
+// jsonHeaders maps TypeHeader names to the TypeHeaders
+var jsonHeaders = func() map[string]TypeHeader {
+	m := make(map[string]TypeHeader, len(headerTypeMap))
+	for th, thv := range headerTypeMap {
+		m[thv.N] = TypeHeader(th)
+	}
+	return m
+}()
+
+// ToJSON transcodes all the values in the RTL stream r into JSON texts (one value each line)
+// written to w, which could be transcoded back by FromJSON.
+func ToJSON(r io.Reader, w io.Writer) error {
+	t := &jsonTranscoder{vr: ValueReaderOf(r)}
+	t.opts = optionsOf(t.vr)
+	for {
+		b, err := t.vr.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		t.buf.Reset()
+		if err := t.value(b, 0); err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		t.buf.WriteByte('\n')
+		if _, err := w.Write(t.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+type jsonTranscoder struct {
+	vr   ValueReader
+	opts *Options
+	buf  bytes.Buffer
+}
+
+func (t *jsonTranscoder) key(name string) {
+	t.buf.WriteByte('"')
+	t.buf.WriteString(name)
+	t.buf.WriteString(`": `)
+}
+
+// length reads the length of a multi-bytes header, and writes the size of it if it's not the
+// minimum one
+func (t *jsonTranscoder) length(n int) (int, error) {
+	lbuf, err := t.vr.ReadBytes(n, nil)
+	if err != nil {
+		return 0, err
+	}
+	if !minimalBytes(lbuf) {
+		t.key(jsonKeySize)
+		fmt.Fprintf(&t.buf, "%d, ", n)
+	}
+	l := Numeric.BytesToUint64(lbuf)
+	if l > uint64(MaxSliceSize) {
+		return 0, fmt.Errorf("%w: length %d", ErrTooLarge, l)
+	}
+	if err := t.opts.checkLength(int(l)); err != nil {
+		return 0, err
+	}
+	return int(l), nil
+}
+
+func (t *jsonTranscoder) value(b byte, depth int) error {
+	if err := t.opts.checkNesting(depth); err != nil {
+		return err
+	}
+	th, n, err := ParseRTLHeader(b)
+	if err != nil {
+		return fmt.Errorf("rtl: illegal header 0x%02x: %w", b, err)
+	}
+	t.buf.WriteByte('{')
+	if th.IsVersion() {
+		t.key(th.Name())
+		if th == THVersion {
+			fmt.Fprintf(&t.buf, "%d", n)
+		} else {
+			body, err := t.vr.ReadBytes(n, nil)
+			if err != nil {
+				return err
+			}
+			if minimalBytes(body) {
+				t.buf.WriteString(new(big.Int).SetBytes(body).String())
+			} else {
+				t.writeString("0x" + hex.EncodeToString(body))
+			}
+		}
+		t.buf.WriteString(", ")
+		if b, err = t.vr.ReadByte(); err != nil {
+			return err
+		}
+		if th, n, err = ParseRTLHeader(b); err != nil {
+			return fmt.Errorf("rtl: illegal header 0x%02x: %w", b, err)
+		}
+		if err = checkVersionFollowed(th); err != nil {
+			return err
+		}
+	}
+
+	var count []byte
+	switch th {
+	case THArrayMulti, THPosBigInt, THNegBigInt, THStringMulti:
+		if n, err = t.length(n); err != nil {
+			return err
+		}
+	case THZeros:
+		if count, err = t.vr.ReadBytes(n, nil); err != nil {
+			return err
+		}
+		if !minimalBytes(count) {
+			t.key(jsonKeySize)
+			fmt.Fprintf(&t.buf, "%d, ", n)
+		}
+	}
+	t.key(th.Name())
+	switch th {
+	case THSingleByte:
+		fmt.Fprintf(&t.buf, "%d", n)
+	case THZeros:
+		fmt.Fprintf(&t.buf, "%d", Numeric.BytesToUint64(count))
+	case THZeroValue, THTrue, THEmpty:
+		t.buf.WriteString("null")
+	case THArraySingle, THArrayMulti:
+		if n == 0 {
+			t.buf.WriteString("[]")
+			break
+		}
+		t.buf.WriteByte('[')
+		indent := strings.Repeat("  ", depth+1)
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				t.buf.WriteByte(',')
+			}
+			t.buf.WriteByte('\n')
+			t.buf.WriteString(indent)
+			eb, err := t.vr.ReadByte()
+			if err != nil {
+				return err
+			}
+			if err := t.value(eb, depth+1); err != nil {
+				return err
+			}
+		}
+		t.buf.WriteByte('\n')
+		t.buf.WriteString(indent[2:])
+		t.buf.WriteByte(']')
+	case THPosNumSingle, THNegNumSingle, THPosBigInt, THNegBigInt:
+		body, err := t.vr.ReadBytes(n, nil)
+		if err != nil {
+			return err
+		}
+		if minimalBytes(body) {
+			t.writeString(new(big.Int).SetBytes(body).String())
+		} else {
+			t.writeString("0x" + hex.EncodeToString(body))
+		}
+	case THStringSingle, THStringMulti:
+		body, err := t.vr.ReadBytes(n, nil)
+		if err != nil {
+			return err
+		}
+		if utf8.Valid(body) {
+			t.writeString(string(body))
+		} else {
+			t.buf.WriteString(`{"` + jsonKeyHex + `": "` + hex.EncodeToString(body) + `"}`)
+		}
+	default:
+		return fmt.Errorf("rtl: illegal header 0x%02x", b)
+	}
+	t.buf.WriteByte('}')
+	return nil
+}
+
+func (t *jsonTranscoder) writeString(s string) {
+	enc := json.NewEncoder(&t.buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	// remove the newline added by Encode
+	t.buf.Truncate(t.buf.Len() - 1)
+}
+
+// minimalBytes returns true if bs is the minimum big-endian bytes of a number (no leading zeros)
+func minimalBytes(bs []byte) bool {
+	return len(bs) == 1 || (len(bs) > 1 && bs[0] != 0)
+}
+
+// FromJSON transcodes all the JSON texts in r (produced by ToJSON, see the format above) into
+// the RTL stream written to w.
+func FromJSON(r io.Reader, w io.Writer) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	buf := new(bytes.Buffer)
+	for {
+		var node interface{}
+		if err := dec.Decode(&node); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		buf.Reset()
+		if err := encodeJSONNode(buf, node, 0); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+func encodeJSONNode(buf *bytes.Buffer, node interface{}, depth int) error {
+	if err := defaultOptions.checkNesting(depth); err != nil {
+		return err
+	}
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("rtl: JSON object expected, but %v found", node)
+	}
+	size := 0
+	th, value := THInvalid, interface{}(nil)
+	for k, v := range obj {
+		switch k {
+		case THVersion.Name(), THVersionSingle.Name():
+		case jsonKeySize:
+			num, ok := v.(json.Number)
+			if !ok {
+				return fmt.Errorf("rtl: illegal %s %v", jsonKeySize, v)
+			}
+			s, err := num.Int64()
+			if err != nil || s <= 0 || s > 8 {
+				return fmt.Errorf("rtl: illegal %s %v", jsonKeySize, v)
+			}
+			size = int(s)
+		default:
+			h, ok := jsonHeaders[k]
+			if !ok || h.IsVersion() {
+				return fmt.Errorf("rtl: unknown key %q", k)
+			}
+			if th != THInvalid {
+				return fmt.Errorf("rtl: both %s and %s found in one value", th, h)
+			}
+			th, value = h, v
+		}
+	}
+	if th == THInvalid {
+		return fmt.Errorf("rtl: no value found in %v", obj)
+	}
+
+	if v, ok := obj[THVersion.Name()]; ok {
+		if _, dup := obj[THVersionSingle.Name()]; dup {
+			return fmt.Errorf("rtl: both %s and %s found in one value", THVersion, THVersionSingle)
+		}
+		n, err := jsonUint(v, 0xF)
+		if err != nil {
+			return fmt.Errorf("rtl: illegal %s: %w", THVersion, err)
+		}
+		buf.WriteByte(headerTypeMap[THVersion].WithNumber(byte(n)))
+	} else if v, ok := obj[THVersionSingle.Name()]; ok {
+		body, err := jsonNumberBytes(v)
+		if err != nil || len(body) == 0 || len(body) > 8 {
+			return fmt.Errorf("rtl: illegal %s %v", THVersionSingle, v)
+		}
+		buf.WriteByte(headerTypeMap[THVersionSingle].WithNumber(byte(len(body))))
+		buf.Write(body)
+	}
+
+	var (
+		body     []byte
+		elements []interface{}
+		length   int
+	)
+	switch th {
+	case THSingleByte:
+		n, err := jsonUint(value, 0x7F)
+		if err != nil {
+			return fmt.Errorf("rtl: illegal %s: %w", th, err)
+		}
+		buf.WriteByte(byte(n))
+		return nil
+	case THZeroValue, THTrue, THEmpty:
+		if value != nil {
+			return fmt.Errorf("rtl: null expected for %s, but %v found", th, value)
+		}
+		buf.WriteByte(headerTypeMap[th].C)
+		return nil
+	case THZeros:
+		n, err := jsonUint(value, math.MaxUint32)
+		if err != nil {
+			return fmt.Errorf("rtl: illegal %s: %w", th, err)
+		}
+		count := Numeric.UintToBytes(n)
+		if len(count) == 0 {
+			count = []byte{0}
+		}
+		if size > 0 {
+			if size < len(count) || size > 4 {
+				return fmt.Errorf("rtl: illegal %s %d of %s %d", jsonKeySize, size, th, n)
+			}
+			count = append(make([]byte, size-len(count)), count...)
+		}
+		buf.WriteByte(headerTypeMap[th].WithNumber(byte(len(count))))
+		buf.Write(count)
+		return nil
+	case THArraySingle, THArrayMulti:
+		if elements, ok = value.([]interface{}); !ok || elements == nil {
+			return fmt.Errorf("rtl: JSON array expected for %s, but %v found", th, value)
+		}
+		length = len(elements)
+	case THPosNumSingle, THNegNumSingle, THPosBigInt, THNegBigInt:
+		var err error
+		if body, err = jsonNumberBytes(value); err != nil {
+			return fmt.Errorf("rtl: illegal %s %v: %w", th, value, err)
+		}
+		length = len(body)
+	case THStringSingle, THStringMulti:
+		switch v := value.(type) {
+		case string:
+			body = []byte(v)
+		case map[string]interface{}:
+			h, ok := v[jsonKeyHex].(string)
+			if !ok || len(v) != 1 {
+				return fmt.Errorf("rtl: illegal %s %v", th, value)
+			}
+			var err error
+			if body, err = hex.DecodeString(h); err != nil {
+				return fmt.Errorf("rtl: illegal %s %v: %w", th, value, err)
+			}
+		default:
+			return fmt.Errorf("rtl: illegal %s %v", th, value)
+		}
+		length = len(body)
+	}
+
+	if length == 0 && elements == nil {
+		return fmt.Errorf("rtl: empty %s", th)
+	}
+	thv := headerTypeMap[th]
+	if thv.T == THVTMultiHeader {
+		lbuf := Numeric.UintToBytes(uint64(length))
+		if len(lbuf) == 0 {
+			lbuf = []byte{0}
+		}
+		if size > 0 {
+			if size < len(lbuf) {
+				return fmt.Errorf("rtl: %s %d is too small for length %d", jsonKeySize, size, length)
+			}
+			lbuf = append(make([]byte, size-len(lbuf)), lbuf...)
+		}
+		buf.WriteByte(thv.WithNumber(byte(len(lbuf))))
+		buf.Write(lbuf)
+	} else {
+		if length <= 0 || length > int(thv.W)+1 {
+			return fmt.Errorf("rtl: illegal length %d of %s", length, th)
+		}
+		buf.WriteByte(thv.WithNumber(byte(length)))
+	}
+	if elements != nil {
+		for _, e := range elements {
+			if err := encodeJSONNode(buf, e, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	buf.Write(body)
+	return nil
+}
+
+// jsonUint parses the JSON number v, which should not larger than max
+func jsonUint(v interface{}, max uint64) (uint64, error) {
+	num, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("number expected, but %v found", v)
+	}
+	i, ok := new(big.Int).SetString(num.String(), 10)
+	if !ok || i.Sign() < 0 || !i.IsUint64() || i.Uint64() > max {
+		return 0, fmt.Errorf("%v out of range [0, %d]", v, max)
+	}
+	return i.Uint64(), nil
+}
+
+// jsonNumberBytes returns the bytes of the number v, which is a decimal number (string), or the
+// hex bytes with "0x" prefix
+func jsonNumberBytes(v interface{}) ([]byte, error) {
+	var s string
+	switch n := v.(type) {
+	case string:
+		s = n
+	case json.Number:
+		s = n.String()
+	default:
+		return nil, fmt.Errorf("number expected, but %v found", v)
+	}
+	if strings.HasPrefix(s, "0x") {
+		bs, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return nil, err
+		}
+		return bs, nil
+	}
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok || i.Sign() < 0 {
+		return nil, fmt.Errorf("illegal number %s", s)
+	}
+	if i.Sign() == 0 {
+		return []byte{0}, nil
+	}
+	return i.Bytes(), nil
+}
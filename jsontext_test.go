@@ -0,0 +1,152 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func jsonRoundTrip(t *testing.T, bs []byte) string {
+	text := new(bytes.Buffer)
+	if err := ToJSON(bytes.NewReader(bs), text); err != nil {
+		t.Fatalf("to json of %x failed: %v", bs, err)
+	}
+	out := new(bytes.Buffer)
+	if err := FromJSON(bytes.NewReader(text.Bytes()), out); err != nil {
+		t.Fatalf("from json failed: %v\n%s", err, text.String())
+	}
+	if !bytes.Equal(out.Bytes(), bs) {
+		t.Errorf("round trip of %x got %x\n%s", bs, out.Bytes(), text.String())
+	}
+	return text.String()
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, test := range encTests {
+		if _, ok := test.val.(Encoder); ok {
+			// customized encoding, may not be a valid stream
+			continue
+		}
+		bs, err := Marshal(test.val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jsonRoundTrip(t, bs)
+	}
+
+	// non-canonical encodings, and multiple values in one stream
+	for _, s := range []string{
+		"05 80 81 82",
+		"a2 0001",                             // leading zero of number
+		"a1 00",                               // number zero
+		"b1 02 ffff",                          // big number header of a small number
+		"ba 0003 ffffff",                      // too many bytes of the length
+		"89 02 05 06",                         // multi-header array with 2 elements
+		"89 00",                               // empty array
+		"c2 ff00",                             // invalid UTF-8 string
+		"e1 21" + strings.Repeat("41", 33),    // multi-header string
+		"e2 0001 41",                          // multi-header string with leading zero length
+		"f3 92 01 02",                         // struct version
+		"ea 0010 92 01 02",                    // Ver+ with leading zero
+		"e9 10 90" + strings.Repeat("80", 16), // Ver+ and 16 elements
+		"c4 3c263e22",                         // <&>" not escaped
+		"a8 ffffffffffffffff",                 // max uint64
+		"ac 80000000",                         // negative
+		"b9 09 010000000000000000",            // negative big number
+		"93 01 85 04 41",                      // run of zero values in struct
+		"92 84 00000103 86 0003",              // runs of zero values with leading zeros
+	} {
+		bs, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		text := jsonRoundTrip(t, bs)
+		t.Logf("%s:\n%s", s, text)
+	}
+}
+
+func TestJSONEdit(t *testing.T) {
+	src := &simplestruct{A: 1, B: "before"}
+	bs, err := Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := new(bytes.Buffer)
+	if err := ToJSON(bytes.NewReader(bs), text); err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"Array\": [\n  {\"Byte\": 1},\n  {\"String\": \"before\"}\n]}\n"
+	if text.String() != want {
+		t.Fatalf("to json got:\n%s\nwant:\n%s", text.String(), want)
+	}
+
+	edited := strings.Replace(text.String(), `"before"`, `"after, longer than before"`, 1)
+	edited = strings.Replace(edited, `{"Byte": 1}`, `{"PosNum": "1000"}`, 1)
+	out := new(bytes.Buffer)
+	if err := FromJSON(strings.NewReader(edited), out); err != nil {
+		t.Fatal(err)
+	}
+	got := new(simplestruct)
+	if err := Unmarshal(out.Bytes(), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.A != 1000 || got.B != "after, longer than before" {
+		t.Errorf("edited value got %+v", got)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	for _, s := range []string{
+		"92 01",    // truncated
+		"83",       // reserved header
+		"f1 f1 80", // duplicated versions
+	} {
+		bs, _ := hex.DecodeString(strings.Replace(s, " ", "", -1))
+		if err := ToJSON(bytes.NewReader(bs), new(bytes.Buffer)); err == nil {
+			t.Errorf("to json of %s should fail", s)
+		}
+	}
+
+	for _, s := range []string{
+		`[1]`,
+		`{"Byte": 128}`,
+		`{"Byte": 1, "Zero": null}`,
+		`{"Zero": 1}`,
+		`{"Unknown": 1}`,
+		`{"Array": null}`,
+		`{"Array": [1]}`,
+		`{"PosNum": "-1"}`,
+		`{"PosNum": "18446744073709551616"}`,
+		`{"String": ""}`,
+		`{"String": "` + strings.Repeat("a", 33) + `"}`,
+		`{"String": {"hex": "zz"}}`,
+		`{"Ver": 16, "Zero": null}`,
+		`{"Ver": 1, "Ver+": 16, "Zero": null}`,
+		`{"size": 1, "String+": "` + strings.Repeat("a", 256) + `"}`,
+		`{"Byte": 1`,
+		`{"Zeros": -1}`,
+		`{"Zeros": 4294967296}`,
+		`{"size": 5, "Zeros": 1}`,
+	} {
+		if err := FromJSON(strings.NewReader(s), new(bytes.Buffer)); err == nil {
+			t.Errorf("from json of %s should fail", s)
+		}
+	}
+}
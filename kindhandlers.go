@@ -86,6 +86,11 @@ func (intHandler) Number(ctx *HandleContext, value reflect.Value, isPositive boo
 		return errors.New("too many bytes for int64")
 	}
 	i := Numeric.BytesToInt64(inputs, !isPositive)
+	if ctx.options().Strict {
+		if _, overflow := Numeric.BytesToInt64B(inputs, !isPositive); overflow || value.OverflowInt(i) {
+			return overflowError(inputs, !isPositive, value.Type())
+		}
+	}
 	value.SetInt(i)
 	return ctx.PopState()
 }
@@ -108,6 +113,9 @@ func (uintHandler) Number(ctx *HandleContext, value reflect.Value, isPositive bo
 		return errors.New("too many bytes for uint64")
 	}
 	i := Numeric.BytesToUint64(inputs)
+	if ctx.options().Strict && value.OverflowUint(i) {
+		return overflowError(inputs, false, value.Type())
+	}
 	value.SetUint(i)
 	return ctx.PopState()
 }
@@ -132,6 +140,9 @@ func (floatHandler) Number(ctx *HandleContext, value reflect.Value, isPositive b
 	} else {
 		f = Numeric.BytesToFloat64(inputs, !isPositive)
 	}
+	if ctx.options().Strict && value.OverflowFloat(f) {
+		return overflowError(inputs, !isPositive, value.Type())
+	}
 	value.SetFloat(f)
 	return ctx.PopState()
 }
@@ -175,7 +186,7 @@ func (mapHandler) Empty(ctx *HandleContext, value reflect.Value) error {
 func (mapHandler) Array(ctx *HandleContext, value reflect.Value, length int) error {
 	nested, err := newMapElement(ctx, value, length)
 	if err != nil {
-		return fmt.Errorf("new map nested handler failed: %v", err)
+		return fmt.Errorf("new map nested handler failed: %w", err)
 	}
 	return ctx.NestedStack(nested)
 }
@@ -188,20 +199,28 @@ func (structHandler) Zero(ctx *HandleContext, value reflect.Value) error {
 func (structHandler) Array(ctx *HandleContext, value reflect.Value, length int) error {
 	nested, err := newStructElement(ctx, value, length)
 	if err != nil {
-		return fmt.Errorf("new struct nested handler failed: %v", err)
+		return fmt.Errorf("new struct nested handler failed: %w", err)
 	}
 	return ctx.NestedStack(nested)
 }
 
+func (structHandler) Version(ctx *HandleContext, _ reflect.Value, inputs ...byte) error {
+	return ctx.keepVersion(inputs)
+}
+
 func (a arrayHandler) _bytes(ctx *HandleContext, value reflect.Value, inputs ...byte) error {
 	etyp := value.Type().Elem()
 	if etyp == typeOfByte {
+		if len(inputs) != value.Len() && ctx.options().Strict {
+			return lengthMismatch(ctx, "rtl: string to array length not match, len(string)=%d, len(array)=%d",
+				len(inputs), value.Len())
+		}
 		reflect.Copy(value, reflect.ValueOf(inputs))
 		return ctx.PopState()
 	} else {
 		nested, err := newString2ArraySlice(ctx, value, inputs)
 		if err != nil {
-			return fmt.Errorf("new string 2 array nested handler failed: %v", err)
+			return fmt.Errorf("new string 2 array nested handler failed: %w", err)
 		}
 		return ctx.NestedStack(nested)
 	}
@@ -223,13 +242,19 @@ func (a arrayHandler) Bytes(ctx *HandleContext, value reflect.Value, inputs []by
 func (a arrayHandler) Array(ctx *HandleContext, value reflect.Value, length int) error {
 	nested, err := newArrayElement(ctx, value, length)
 	if err != nil {
-		return fmt.Errorf("new array nested handler failed: %v", err)
+		return fmt.Errorf("new array nested handler failed: %w", err)
 	}
 	return ctx.NestedStack(nested)
 }
 
 func (s sliceHandler) _bytes(ctx *HandleContext, value reflect.Value, inputs ...byte) error {
-	checkSlice0(len(inputs), value)
+	if ctx.options().NoCopy && value.Type().Elem() == typeOfByte {
+		value.SetBytes(inputs)
+		return ctx.PopState()
+	}
+	if err := checkSlice0(ctx, len(inputs), value); err != nil {
+		return err
+	}
 	etyp := value.Type().Elem()
 	if etyp == typeOfByte {
 		reflect.Copy(value, reflect.ValueOf(inputs))
@@ -237,7 +262,7 @@ func (s sliceHandler) _bytes(ctx *HandleContext, value reflect.Value, inputs ...
 	} else {
 		nested, err := newString2ArraySlice(ctx, value, inputs)
 		if err != nil {
-			return fmt.Errorf("new string 2 slice nested handler failed: %v", err)
+			return fmt.Errorf("new string 2 slice nested handler failed: %w", err)
 		}
 		return ctx.NestedStack(nested)
 	}
@@ -267,7 +292,7 @@ func (s sliceHandler) Bytes(ctx *HandleContext, value reflect.Value, inputs []by
 func (s sliceHandler) Array(ctx *HandleContext, value reflect.Value, length int) error {
 	nested, err := newSliceElement(ctx, value, length)
 	if err != nil {
-		return fmt.Errorf("new slice nested handler failed: %v", err)
+		return fmt.Errorf("new slice nested handler failed: %w", err)
 	}
 	return ctx.NestedStack(nested)
 }
@@ -335,7 +360,22 @@ func (p pointerHandler) Version(ctx *HandleContext, value reflect.Value, _ ...by
 	return p._handle(ctx, value)
 }
 
-func (interfaceHandler) Byte(ctx *HandleContext, value reflect.Value, input byte) error {
+// _typed returns nil if the value could be decoded as an untyped interface value. In typed
+// interfaces mode, only zero value and [id, value] array are legal.
+func (interfaceHandler) _typed(ctx *HandleContext, value reflect.Value, event string) error {
+	if ctx.options().TypedInterfaces {
+		return fmt.Errorf("rtl: typed interface value expected, but %s found", event)
+	}
+	if value.Type().NumMethod() != 0 {
+		return fmt.Errorf("rtl: unsupported type %v for decoding, decode it in typed interfaces mode", value.Type())
+	}
+	return nil
+}
+
+func (i interfaceHandler) Byte(ctx *HandleContext, value reflect.Value, input byte) error {
+	if err := i._typed(ctx, value, "byte"); err != nil {
+		return err
+	}
 	nv := reflect.New(typeOfUint64).Elem()
 	nv.SetUint(uint64(input))
 	value.Set(nv)
@@ -343,16 +383,22 @@ func (interfaceHandler) Byte(ctx *HandleContext, value reflect.Value, input byte
 }
 
 func (interfaceHandler) Zero(ctx *HandleContext, value reflect.Value) error {
-	value.Set(reflect.Zero(typeOfInterface))
+	value.Set(reflect.Zero(value.Type()))
 	return ctx.PopState()
 }
 
-func (interfaceHandler) Empty(ctx *HandleContext, value reflect.Value) error {
+func (i interfaceHandler) Empty(ctx *HandleContext, value reflect.Value) error {
+	if err := i._typed(ctx, value, "empty"); err != nil {
+		return err
+	}
 	value.Set(reflect.MakeSlice(typeOfInterfaceSlice, 0, 0))
 	return ctx.PopState()
 }
 
-func (interfaceHandler) Number(ctx *HandleContext, value reflect.Value, isPositive bool, inputs []byte) error {
+func (i interfaceHandler) Number(ctx *HandleContext, value reflect.Value, isPositive bool, inputs []byte) error {
+	if err := i._typed(ctx, value, "number"); err != nil {
+		return err
+	}
 	l := len(inputs)
 	if l <= 8 {
 		if !isPositive {
@@ -376,15 +422,41 @@ func (interfaceHandler) Number(ctx *HandleContext, value reflect.Value, isPositi
 	return ctx.PopState()
 }
 
-func (interfaceHandler) Bytes(ctx *HandleContext, value reflect.Value, inputs []byte) error {
+func (i interfaceHandler) Bytes(ctx *HandleContext, value reflect.Value, inputs []byte) error {
+	if err := i._typed(ctx, value, "bytes"); err != nil {
+		return err
+	}
 	nv := reflect.New(typeOfString).Elem()
 	nv.SetString(string(inputs))
 	value.Set(nv)
 	return ctx.PopState()
 }
 
-func (interfaceHandler) Array(ctx *HandleContext, value reflect.Value, size int) error {
-	slice := reflect.MakeSlice(typeOfInterfaceSlice, size, size)
-	value.Set(slice)
-	return ctx.ReplaceStack(slice)
+func (i interfaceHandler) Array(ctx *HandleContext, value reflect.Value, size int) error {
+	if ctx.options().TypedInterfaces {
+		nested, err := newTypedInterfaceElement(ctx, value, size)
+		if err != nil {
+			return fmt.Errorf("new typed interface nested handler failed: %w", err)
+		}
+		return ctx.NestedStack(nested)
+	}
+	if err := i._typed(ctx, value, "array"); err != nil {
+		return err
+	}
+	// the elements are decoded into a growing slice, which is set to value when finished
+	nested, err := newSliceElement(ctx, reflect.New(typeOfInterfaceSlice).Elem(), size)
+	if err != nil {
+		return fmt.Errorf("new slice nested handler failed: %w", err)
+	}
+	nested.zeros = true
+	nested.owner = value
+	return ctx.NestedStack(nested)
+}
+
+func (i interfaceHandler) Version(ctx *HandleContext, value reflect.Value, inputs ...byte) error {
+	if err := i._typed(ctx, value, "version"); err != nil {
+		return err
+	}
+	// struct version is meaningless to interface{}
+	return ctx.keepVersion(inputs)
 }
@@ -28,6 +28,8 @@ type mapElement struct {
 	dataIdx        int
 	kType, vType   reflect.Type
 	kValue, vValue reflect.Value
+	prevKey        []byte // encoded bytes of the previous key, only used in canonical mode
+	keyMark        int    // mark of recording the raw bytes of the key, -1 if not recording
 }
 
 var typeOfMapElement = reflect.TypeOf((*mapElement)(nil)).Elem()
@@ -43,8 +45,8 @@ func newMapElement(ctx *HandleContext, val reflect.Value, size int) (*mapElement
 	if size%2 != 0 {
 		return nil, fmt.Errorf("length of the array must be even when decode to a map, but length=%d", size)
 	}
-	if val.IsNil() {
-		val.Set(reflect.MakeMapWithSize(typ, size/2))
+	if err := makeMap(ctx, val, size/2); err != nil {
+		return nil, err
 	}
 	ktyp := typ.Key()
 	vtyp := typ.Elem()
@@ -54,6 +56,8 @@ func newMapElement(ctx *HandleContext, val reflect.Value, size int) (*mapElement
 	ret.dataIdx = -1
 	ret.kType = ktyp
 	ret.vType = vtyp
+	ret.prevKey = nil
+	ret.keyMark = -1
 	// ctx._count("mapElement")
 	return ret, nil
 	// return &mapElement{
@@ -78,6 +82,9 @@ func (m *mapElement) Element(ctx *HandleContext) error {
 		if !m.kValue.IsValid() || !m.vValue.IsValid() {
 			return fmt.Errorf("missing k-v values when %d/%d", m.dataIdx, m.dataSize)
 		}
+		if err := allocEntry(ctx, m.val); err != nil {
+			return err
+		}
 		m.val.SetMapIndex(m.kValue, m.vValue)
 		m.kValue = reflect.Value{}
 		m.vValue = reflect.Value{}
@@ -92,6 +99,9 @@ func (m *mapElement) Element(ctx *HandleContext) error {
 			return fmt.Errorf("a valid key value already in cache when %d/%d", m.dataIdx, m.dataSize)
 		}
 		m.kValue = reflect.New(m.kType).Elem()
+		if vr, ok := ctx.vr.(*defaultVR); ok && ctx.options().Canonical {
+			m.keyMark = vr.record(nil)
+		}
 		return ctx.PushState(m.kValue, THInvalid, 0, nil, nil)
 	} else {
 		if m.vValue.IsValid() {
@@ -100,6 +110,17 @@ func (m *mapElement) Element(ctx *HandleContext) error {
 		if !m.kValue.IsValid() {
 			return fmt.Errorf("missing key value when %d/%d", m.dataIdx, m.dataSize)
 		}
+		if opts := ctx.options(); opts.Canonical {
+			var raw []byte
+			if m.keyMark >= 0 {
+				raw = ctx.vr.(*defaultVR).recorded(m.keyMark)
+				m.keyMark = -1
+			}
+			var err error
+			if m.prevKey, err = checkCanonicalKey(opts, m.prevKey, m.kValue, raw); err != nil {
+				return err
+			}
+		}
 		m.vValue = reflect.New(m.vType).Elem()
 		return ctx.PushState(m.vValue, THInvalid, 0, nil, nil)
 	}
@@ -109,10 +130,23 @@ func (m *mapElement) Index() int {
 	return m.dataIdx
 }
 
+func (m *mapElement) pathSegment() string {
+	if m.dataIdx < 0 {
+		return ""
+	}
+	if m.dataIdx%2 == 0 {
+		return fmt.Sprintf("[key %d]", m.dataIdx/2)
+	}
+	return keySegment(m.kValue)
+}
+
 type sliceElement struct {
 	val      reflect.Value
 	dataSize int
+	itemIdx  int // index of the items in the stream, a run of zero values is one item
 	dataIdx  int
+	zeros    bool          // runs of zero values (THZeros) are expanded, for the elements of interface{}
+	owner    reflect.Value // interface set with val when finished, if valid
 }
 
 var typeOfsliceElement = reflect.TypeOf((*sliceElement)(nil)).Elem()
@@ -125,18 +159,16 @@ func newSliceElement(ctx *HandleContext, val reflect.Value, size int) (*sliceEle
 	if typ.Kind() != reflect.Slice {
 		return nil, errors.New("not a slice")
 	}
-	checkSlice0(size, val)
-	if size > val.Cap() {
-		newv := reflect.MakeSlice(typ, size, size)
-		val.Set(newv)
-	}
-	if size != val.Len() {
-		val.SetLen(size)
+	if err := allocSlice(ctx, size, val); err != nil {
+		return nil, err
 	}
 	ret := ctx.NewNested(typeOfsliceElement).(*sliceElement)
 	ret.val = val
+	ret.itemIdx = -1
 	ret.dataIdx = -1
 	ret.dataSize = size
+	ret.zeros = false
+	ret.owner = reflect.Value{}
 	// ctx._count("sliceElement")
 	return ret, nil
 	// return &sliceElement{
@@ -154,10 +186,41 @@ func (s *sliceElement) String() string {
 }
 
 func (s *sliceElement) Element(ctx *HandleContext) error {
-	s.dataIdx++
-	if s.dataIdx >= s.dataSize {
+	s.itemIdx++
+	if s.itemIdx >= s.dataSize {
+		if s.owner.IsValid() {
+			s.owner.Set(s.val)
+			s.owner = reflect.Value{}
+		}
 		return ctx.PopState()
 	}
+	s.dataIdx++
+	// the length of the slice if there's no more run of zero values
+	length := s.dataIdx + s.dataSize - s.itemIdx
+	if s.zeros {
+		run, err := ReadZeros(ctx.vr)
+		if err != nil {
+			return err
+		}
+		if run > 0 {
+			length += run - 1
+			if err := ctx.options().checkLength(length); err != nil {
+				return err
+			}
+			end := s.dataIdx + run - 1
+			if err := growSlice(ctx, s.val, end, length); err != nil {
+				return err
+			}
+			for i := s.dataIdx; i <= end; i++ {
+				s.val.Index(i).Set(reflect.Zero(s.val.Type().Elem()))
+			}
+			s.dataIdx = end
+			return nil
+		}
+	}
+	if err := growSlice(ctx, s.val, s.dataIdx, length); err != nil {
+		return err
+	}
 	evalue := s.val.Index(s.dataIdx)
 	return ctx.PushState(evalue, THInvalid, 0, nil, nil)
 }
@@ -166,6 +229,10 @@ func (s *sliceElement) Index() int {
 	return s.dataIdx
 }
 
+func (s *sliceElement) pathSegment() string {
+	return indexSegment(s.dataIdx)
+}
+
 type arrayElement struct {
 	val       reflect.Value
 	dataSize  int
@@ -183,6 +250,9 @@ func newArrayElement(ctx *HandleContext, val reflect.Value, size int) (*arrayEle
 	if typ.Kind() != reflect.Array {
 		return nil, errors.New("not an array")
 	}
+	if size != val.Len() && ctx.options().Strict {
+		return nil, lengthMismatch(ctx, "rtl: array length not match, len(data)=%d, len(array)=%d", size, val.Len())
+	}
 	ret := ctx.NewNested(typeOfArrayElement).(*arrayElement)
 	ret.val = val
 	ret.dataSize = size
@@ -226,6 +296,10 @@ func (s *arrayElement) Index() int {
 	return s.dataIdx
 }
 
+func (s *arrayElement) pathSegment() string {
+	return indexSegment(s.dataIdx)
+}
+
 type string2ArraySlice struct {
 	val       reflect.Value
 	buf       []byte
@@ -248,7 +322,12 @@ func newString2ArraySlice(ctx *HandleContext, val reflect.Value, buf []byte) (*s
 		return nil, errors.New("not an array or a slice")
 	}
 	if kind == reflect.Slice {
-		checkSlice0(len(buf), val)
+		if err := checkSlice0(ctx, len(buf), val); err != nil {
+			return nil, err
+		}
+	} else if len(buf) != val.Len() && ctx.options().Strict {
+		return nil, lengthMismatch(ctx, "rtl: string to array length not match, len(string)=%d, len(array)=%d",
+			len(buf), val.Len())
 	}
 	ret := ctx.NewNested(typeOfString2ArraySlice).(*string2ArraySlice)
 	ret.val = val
@@ -285,11 +364,79 @@ func (s *string2ArraySlice) Index() int {
 	return s.idx
 }
 
+func (s *string2ArraySlice) pathSegment() string {
+	return indexSegment(s.idx)
+}
+
+// typedInterfaceElement decodes [id, value] of typed interfaces mode into an interface value
+type typedInterfaceElement struct {
+	val  reflect.Value // the interface value
+	idx  int           // the last processed element index
+	id   reflect.Value // id of the registered type
+	elem reflect.Value // value of the registered type
+}
+
+var typeOfTypedInterfaceElement = reflect.TypeOf((*typedInterfaceElement)(nil)).Elem()
+
+func newTypedInterfaceElement(ctx *HandleContext, val reflect.Value, size int) (*typedInterfaceElement, error) {
+	if !val.IsValid() {
+		return nil, ErrInvalidValue
+	}
+	if val.Kind() != reflect.Interface {
+		return nil, errors.New("not an interface")
+	}
+	if size != 2 {
+		return nil, fmt.Errorf("rtl: typed interface value expected, but array (length:%d) found", size)
+	}
+	ret := ctx.NewNested(typeOfTypedInterfaceElement).(*typedInterfaceElement)
+	ret.val = val
+	ret.idx = -1
+	ret.id = reflect.New(typeOfUint64).Elem()
+	ret.elem = reflect.Value{}
+	return ret, nil
+}
+
+func (t *typedInterfaceElement) String() string {
+	if t == nil {
+		return "typedInterface<nil>"
+	}
+	return fmt.Sprintf("typedInterface[%d]", t.idx)
+}
+
+func (t *typedInterfaceElement) Element(ctx *HandleContext) error {
+	t.idx++
+	switch t.idx {
+	case 0:
+		return ctx.PushState(t.id, THInvalid, 0, nil, nil)
+	case 1:
+		elem, err := typedValue(t.id.Uint(), t.val.Type())
+		if err != nil {
+			return err
+		}
+		t.elem = elem
+		return ctx.PushState(t.elem, THInvalid, 0, nil, nil)
+	default:
+		t.val.Set(t.elem)
+		return ctx.PopState()
+	}
+}
+
+func (t *typedInterfaceElement) Index() int {
+	return t.idx
+}
+
+func (t *typedInterfaceElement) pathSegment() string {
+	// the interface itself
+	return ""
+}
+
 type structElement struct {
 	val      reflect.Value
 	dataSize int         // data size
-	dataIdx  int         // the last processed data index
+	itemIdx  int         // the last processed element index, a run of zero values is one element
+	dataIdx  int         // the last processed data index (order), a run of zero values covers many
 	fields   []fieldName // structure
+	fieldNum int         // the number of fields existing in the version of the data
 	fieldIdx int         // the last processed field index of the structure
 }
 
@@ -304,11 +451,17 @@ func newStructElement(ctx *HandleContext, val reflect.Value, size int) (*structE
 		return nil, errors.New("not a struct")
 	}
 	_, fields := structFields(typ)
+	version := noVersion
+	if state, exist := ctx.top(); exist {
+		version = state.version
+	}
 	ret := ctx.NewNested(typeOfStructElement).(*structElement)
 	ret.val = val
 	ret.dataSize = size
+	ret.itemIdx = -1
 	ret.dataIdx = -1
 	ret.fields = fields
+	ret.fieldNum = len(fieldsOfVersion(fields, version))
 	ret.fieldIdx = -1
 	// ctx._count("structElement")
 	return ret, nil
@@ -330,20 +483,35 @@ func (s *structElement) String() string {
 
 func (s *structElement) Element(ctx *HandleContext) error {
 	nextField := s.fieldIdx + 1
-	if nextField < len(s.fields) {
-		fieldOrder := s.fields[nextField].order
-		s.dataIdx++
-		if s.dataIdx < s.dataSize {
-			if s.dataIdx == fieldOrder {
+	if nextField < s.fieldNum && s.itemIdx+1 < s.dataSize {
+		run, err := ReadZeros(ctx.vr)
+		if err != nil {
+			return err
+		}
+		s.itemIdx++
+		if run > 0 {
+			// set zero values to the fields covered by the run
+			s.dataIdx += run
+			for ; nextField < s.fieldNum && s.fields[nextField].order <= s.dataIdx; nextField++ {
 				fvalue := s.val.Field(s.fields[nextField].index)
+				if fvalue.CanSet() {
+					fvalue.Set(reflect.Zero(fvalue.Type()))
+				}
 				s.fieldIdx = nextField
-				return ctx.PushState(fvalue, THInvalid, 0, nil, nil)
-			} else if s.dataIdx < fieldOrder {
-				return ctx.SkipReader(1)
-			} else {
-				return fmt.Errorf("illegal status found: dataIdx:%d fieldIdx:%d %s",
-					s.dataIdx, nextField, s.fields[nextField])
 			}
+			return nil
+		}
+		fieldOrder := s.fields[nextField].order
+		s.dataIdx++
+		if s.dataIdx == fieldOrder {
+			fvalue := s.val.Field(s.fields[nextField].index)
+			s.fieldIdx = nextField
+			return ctx.PushState(fvalue, THInvalid, 0, nil, nil)
+		} else if s.dataIdx < fieldOrder {
+			return ctx.SkipReader(1)
+		} else {
+			return fmt.Errorf("illegal status found: dataIdx:%d fieldIdx:%d %s",
+				s.dataIdx, nextField, s.fields[nextField])
 		}
 	}
 	// set zero values
@@ -354,12 +522,12 @@ func (s *structElement) Element(ctx *HandleContext) error {
 		}
 	}
 
-	if s.dataIdx < s.dataSize-1 {
+	if s.itemIdx < s.dataSize-1 {
 		// skip datas and pop stack
 		if err := ctx.PopState(); err != nil {
 			return err
 		}
-		return ctx.SkipReader(s.dataSize - 1 - s.dataIdx)
+		return ctx.SkipReader(s.dataSize - 1 - s.itemIdx)
 	} else {
 		return ctx.PopState()
 	}
@@ -368,3 +536,14 @@ func (s *structElement) Element(ctx *HandleContext) error {
 func (s *structElement) Index() int {
 	return s.dataIdx
 }
+
+func (s *structElement) pathSegment() string {
+	if s.fieldIdx >= 0 && s.fieldIdx < len(s.fields) && s.fields[s.fieldIdx].order == s.dataIdx {
+		return "." + s.fields[s.fieldIdx].name
+	}
+	if s.dataIdx >= 0 {
+		// skipping the element which is not a field
+		return indexSegment(s.dataIdx)
+	}
+	return ""
+}
@@ -0,0 +1,359 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// Node is a value of the RTL stream decoded without a Go type. Unlike decoding into interface{},
+// it keeps the TypeHeader and the payload of every value, so that strings and numbers, Zero and
+// Empty, and the version prefixes of structs could be told, and the Node is encoded to exactly
+// the same bytes as it was decoded from.
+//
+//	var node rtl.Node
+//	err := rtl.Unmarshal(bs, &node)
+//	amount, err := node.Index(3).AsBigInt()
+//	bs, err = rtl.Marshal(node)
+//
+// The Header and the payload must match when encoding, NewNode could be used to create Nodes from
+// Go values. The zero Node (e.g. a field of Node missing in the stream) is encoded as a zero value.
+type Node struct {
+	Header TypeHeader
+	// version prefix of the value, whose Header is THVersion or THVersionSingle, nil if absent
+	Version *Node
+	// number of bytes of the length of a multi-bytes header (Array+, PosNum+, NegNum+, String+),
+	// 0 means the minimum one
+	Size int
+	// payload of the value: the byte of THSingleByte or THVersion, the absolute value of a
+	// number in big-endian, the bytes of a string, the number of a version (THVersionSingle) or
+	// the count of a run of zero values (THZeros). Leading zeros are kept.
+	Bytes []byte
+	// items of an array, a run of zero values (THZeros) is one item. A nil item is encoded as a
+	// zero value.
+	Items []*Node
+}
+
+var typeOfNode = reflect.TypeOf(Node{})
+
+// NewNode returns the Node of the encoded v
+func NewNode(v interface{}) (*Node, error) {
+	bs, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	node := new(Node)
+	if err := Unmarshal(bs, node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// Serialization implements Encoder
+func (n Node) Serialization(w io.Writer) error {
+	return n.write(w, optionsOf(w), 0)
+}
+
+// Deserialization implements Decoder, a nil *Node is decoded from a zero value. io.ErrUnexpectedEOF
+// is returned if the value is truncated.
+func (n *Node) Deserialization(r io.Reader) (bool, error) {
+	vr, ok := r.(*defaultVR)
+	if !ok {
+		// reads exactly the bytes of the next value from r
+		vr = newValueReader(r, nil)
+	}
+	d := &nodeReader{vr: vr, opts: optionsOf(vr)}
+	start := vr.Offset()
+	if err := d.read(n, 0); err != nil {
+		return false, truncatedError(err, start, vr.Offset())
+	}
+	return n.Header == THZeroValue && n.Version == nil, nil
+}
+
+// Len returns the number of items of an array
+func (n *Node) Len() int {
+	if n == nil {
+		return 0
+	}
+	return len(n.Items)
+}
+
+// Index returns the item i of an array, or nil if there's no such item
+func (n *Node) Index(i int) *Node {
+	if n == nil || i < 0 || i >= len(n.Items) {
+		return nil
+	}
+	return n.Items[i]
+}
+
+func (n *Node) header() TypeHeader {
+	if n == nil {
+		return THInvalid
+	}
+	return n.Header
+}
+
+// AsBigInt returns the integer of the Node, or the number of a version
+func (n *Node) AsBigInt() (*big.Int, error) {
+	switch th := n.header(); th {
+	case THZeroValue:
+		return new(big.Int), nil
+	case THSingleByte, THPosNumSingle, THPosBigInt, THVersion, THVersionSingle:
+		return new(big.Int).SetBytes(n.Bytes), nil
+	case THNegNumSingle, THNegBigInt:
+		i := new(big.Int).SetBytes(n.Bytes)
+		return i.Neg(i), nil
+	default:
+		return nil, mismatchError("integer", th)
+	}
+}
+
+// AsUint returns the unsigned integer of the Node, ErrOverflow if it could not be held by uint64
+func (n *Node) AsUint() (uint64, error) {
+	switch th := n.header(); th {
+	case THZeroValue, THSingleByte, THPosNumSingle, THPosBigInt, THVersion, THVersionSingle:
+		i, _ := n.AsBigInt()
+		if !i.IsUint64() {
+			return 0, overflowError(n.Bytes, false, "uint64")
+		}
+		return i.Uint64(), nil
+	default:
+		return 0, mismatchError("uint", th)
+	}
+}
+
+// AsInt returns the signed integer of the Node, ErrOverflow if it could not be held by int64
+func (n *Node) AsInt() (int64, error) {
+	i, err := n.AsBigInt()
+	if err != nil {
+		return 0, err
+	}
+	if !i.IsInt64() {
+		return 0, overflowError(n.Bytes, i.Sign() < 0, "int64")
+	}
+	return i.Int64(), nil
+}
+
+// AsBool returns the bool of the Node
+func (n *Node) AsBool() (bool, error) {
+	switch th := n.header(); th {
+	case THZeroValue:
+		return false, nil
+	case THTrue:
+		return true, nil
+	default:
+		return false, mismatchError("bool", th)
+	}
+}
+
+// AsBytes returns the bytes of a string. Zero value is nil and Empty is an empty slice. Note that
+// a string of one byte less than 0x80 is encoded as THSingleByte.
+func (n *Node) AsBytes() ([]byte, error) {
+	switch th := n.header(); th {
+	case THZeroValue:
+		return nil, nil
+	case THEmpty:
+		return []byte{}, nil
+	case THSingleByte, THStringSingle, THStringMulti:
+		return n.Bytes, nil
+	default:
+		return nil, mismatchError("string", th)
+	}
+}
+
+// AsString returns the string of the Node
+func (n *Node) AsString() (string, error) {
+	bs, err := n.AsBytes()
+	return string(bs), err
+}
+
+// writeNodeHeader writes the header of th with length, the bytes of the length of a multi-bytes header
+// are extended to size if it's larger than the minimum one.
+func writeNodeHeader(w io.Writer, th TypeHeader, length int, size int) error {
+	var buf [9]byte
+	thv := headerTypeMap[th]
+	switch thv.T {
+	case THVTMultiHeader:
+		lbuf := Numeric.UintToBytes(uint64(length))
+		if len(lbuf) == 0 {
+			lbuf = []byte{0}
+		}
+		if size > 0 {
+			if size < len(lbuf) || size > 8 {
+				return fmt.Errorf("rtl: size %d of %s is illegal for length %d", size, th, length)
+			}
+			lbuf = append(make([]byte, size-len(lbuf)), lbuf...)
+		}
+		buf[0] = thv.WithNumber(byte(len(lbuf)))
+		_, err := w.Write(append(buf[:1], lbuf...))
+		return err
+	case THVTSingleHeader:
+		if length <= 0 || length > int(thv.W)+1 {
+			return fmt.Errorf("rtl: illegal length %d of %s", length, th)
+		}
+		buf[0] = thv.WithNumber(byte(length))
+	default:
+		buf[0] = thv.C
+	}
+	_, err := w.Write(buf[:1])
+	return err
+}
+
+func (n *Node) write(w io.Writer, opts *Options, depth int) error {
+	if err := opts.checkNesting(depth); err != nil {
+		return err
+	}
+	if n == nil {
+		_, err := w.Write(zeroValues)
+		return err
+	}
+	if v := n.Version; v != nil {
+		switch {
+		case v.Header == THVersion && len(v.Bytes) == 1 && v.Bytes[0] <= 0xF:
+			if _, err := w.Write([]byte{headerTypeMap[THVersion].WithNumber(v.Bytes[0])}); err != nil {
+				return err
+			}
+		case v.Header == THVersionSingle && len(v.Bytes) > 0 && len(v.Bytes) <= 8:
+			if err := writeNodeHeader(w, THVersionSingle, len(v.Bytes), 0); err != nil {
+				return err
+			}
+			if _, err := w.Write(v.Bytes); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("rtl: illegal version %s %x", v.Header, v.Bytes)
+		}
+	}
+
+	switch n.Header {
+	case THSingleByte:
+		if n.Bytes == nil {
+			// Node{}
+			_, err := w.Write(zeroValues)
+			return err
+		}
+		if len(n.Bytes) != 1 || n.Bytes[0] > 0x7F {
+			return fmt.Errorf("rtl: illegal %s %x", n.Header, n.Bytes)
+		}
+		_, err := w.Write(n.Bytes)
+		return err
+	case THZeroValue, THTrue, THEmpty:
+		return writeNodeHeader(w, n.Header, 0, 0)
+	case THArraySingle, THArrayMulti:
+		if n.Header == THArraySingle && len(n.Items) == 0 {
+			return fmt.Errorf("rtl: empty %s", n.Header)
+		}
+		if err := writeNodeHeader(w, n.Header, len(n.Items), n.Size); err != nil {
+			return err
+		}
+		for _, item := range n.Items {
+			if err := item.write(w, opts, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case THPosNumSingle, THNegNumSingle, THPosBigInt, THNegBigInt, THStringSingle, THStringMulti, THZeros:
+		if len(n.Bytes) == 0 {
+			return fmt.Errorf("rtl: empty %s", n.Header)
+		}
+		if err := writeNodeHeader(w, n.Header, len(n.Bytes), n.Size); err != nil {
+			return err
+		}
+		_, err := w.Write(n.Bytes)
+		return err
+	default:
+		return fmt.Errorf("rtl: illegal header %s of node", n.Header)
+	}
+}
+
+// nodeReader decodes Nodes from the stream
+type nodeReader struct {
+	vr   *defaultVR
+	opts *Options
+}
+
+func (d *nodeReader) read(n *Node, depth int) error {
+	if err := d.opts.checkNesting(depth); err != nil {
+		return err
+	}
+	th, length, err := d.vr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	*n = Node{}
+	if th.IsVersion() {
+		n.Version = &Node{Header: th}
+		if th == THVersion {
+			n.Version.Bytes = []byte{byte(length)}
+		} else if n.Version.Bytes, err = d.vr.ReadBytes(length, nil); err != nil {
+			return err
+		}
+		if th, length, err = d.vr.ReadHeader(); err != nil {
+			return err
+		}
+		if err = checkVersionFollowed(th); err != nil {
+			return err
+		}
+	}
+	n.Header = th
+
+	if headerTypeMap[th].T == THVTMultiHeader {
+		lbuf, err := d.vr.ReadBytes(length, nil)
+		if err != nil {
+			return err
+		}
+		if !minimalBytes(lbuf) {
+			n.Size = length
+		}
+		l := Numeric.BytesToUint64(lbuf)
+		if l > math.MaxInt32 {
+			return fmt.Errorf("%w: length %d", ErrTooLarge, l)
+		}
+		length = int(l)
+	}
+
+	switch th {
+	case THSingleByte:
+		n.Bytes = []byte{byte(length)}
+	case THZeroValue, THTrue, THEmpty:
+	case THArraySingle, THArrayMulti:
+		if err := d.opts.checkLength(length); err != nil {
+			return err
+		}
+		// grows with the items actually decoded
+		n.Items = make([]*Node, 0, initialCap(length, typeOfNode.Size()))
+		for i := 0; i < length; i++ {
+			if err := d.vr.alloc(int(typeOfNode.Size())); err != nil {
+				return err
+			}
+			item := new(Node)
+			if err := d.read(item, depth+1); err != nil {
+				return err
+			}
+			n.Items = append(n.Items, item)
+		}
+	default:
+		if n.Bytes, err = d.vr.ReadBytes(length, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
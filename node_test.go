@@ -0,0 +1,296 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestNodeRoundTrip(t *testing.T) {
+	var streams [][]byte
+	for _, test := range encTests {
+		if _, ok := test.val.(Encoder); ok {
+			// customized encoding, may not be a valid stream
+			continue
+		}
+		bs, err := Marshal(test.val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, bs)
+	}
+	// non-canonical encodings
+	for _, s := range []string{
+		"a2 0001",                             // leading zero of number
+		"b1 02 ffff",                          // big number header of a small number
+		"ba 0003 ffffff",                      // too many bytes of the length
+		"89 00",                               // empty array
+		"e2 0001 41",                          // multi-header string with leading zero length
+		"ea 0010 92 01 02",                    // Ver+ with leading zero
+		"e9 10 90" + strings.Repeat("80", 16), // Ver+ and 16 elements
+		"b9 09 010000000000000000",            // negative big number
+		"92 84 00000103 86 0003",              // runs of zero values with leading zeros
+	} {
+		streams = append(streams, hexStream(t, s))
+	}
+
+	forEachEngine(t, func(t *testing.T) {
+		for _, bs := range streams {
+			var node Node
+			if err := Unmarshal(bs, &node); err != nil {
+				t.Errorf("unmarshal %x failed: %v", bs, err)
+				continue
+			}
+			out, err := Marshal(node)
+			if err != nil {
+				t.Errorf("marshal node of %x failed: %v", bs, err)
+				continue
+			}
+			if !bytes.Equal(out, bs) {
+				t.Errorf("round trip of %x got %x", bs, out)
+			}
+		}
+	})
+}
+
+func TestNodeAccessors(t *testing.T) {
+	type inner struct {
+		Name  string
+		Flag  bool
+		Empty []uint
+	}
+	type outer struct {
+		A uint64
+		B int16
+		C *big.Int
+		D []byte
+		E inner
+		F string
+	}
+	src := outer{A: 1 << 40, B: -300, C: big.NewInt(-1), D: []byte{0xff, 0}, E: inner{Name: "x", Flag: true, Empty: []uint{}}, F: "b"}
+	node, err := NewNode(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Header != THArraySingle || node.Len() != 6 {
+		t.Fatalf("node got %s with %d items", node.Header, node.Len())
+	}
+	if u, err := node.Index(0).AsUint(); err != nil || u != 1<<40 {
+		t.Errorf("A got %d, %v", u, err)
+	}
+	if i, err := node.Index(1).AsInt(); err != nil || i != -300 {
+		t.Errorf("B got %d, %v", i, err)
+	}
+	if _, err := node.Index(1).AsUint(); err == nil {
+		t.Errorf("negative number as uint should fail")
+	}
+	if i, err := node.Index(2).AsBigInt(); err != nil || i.Int64() != -1 {
+		t.Errorf("C got %v, %v", i, err)
+	}
+	if bs, err := node.Index(3).AsBytes(); err != nil || !bytes.Equal(bs, src.D) {
+		t.Errorf("D got %x, %v", bs, err)
+	}
+	if s, err := node.Index(4).Index(0).AsString(); err != nil || s != "x" {
+		t.Errorf("E.Name got %q, %v", s, err)
+	}
+	if b, err := node.Index(4).Index(1).AsBool(); err != nil || !b {
+		t.Errorf("E.Flag got %t, %v", b, err)
+	}
+	if th := node.Index(4).Index(2).Header; th != THEmpty {
+		t.Errorf("E.Empty got %s", th)
+	}
+	if s, err := node.Index(5).AsString(); err != nil || s != "b" {
+		t.Errorf("F got %q, %v", s, err)
+	}
+	if _, err := node.Index(6).AsUint(); err == nil {
+		t.Errorf("missing item should fail")
+	}
+	if _, err := node.Index(4).Index(1).AsUint(); err == nil {
+		t.Errorf("bool as uint should fail")
+	}
+
+	big := &Node{Header: THPosBigInt, Bytes: bytes.Repeat([]byte{0xff}, 9)}
+	if _, err := big.AsUint(); !errors.Is(err, ErrOverflow) {
+		t.Errorf("big number as uint got %v", err)
+	}
+
+	version, err := NewNode(structV20{A: 1, B: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := version.Version.AsUint(); err != nil || v != 20 {
+		t.Errorf("version got %d, %v", v, err)
+	}
+}
+
+func TestNodeEdit(t *testing.T) {
+	type holder struct {
+		Name  string
+		Value Node
+		Ptr   *Node
+	}
+	value, err := NewNode(&simplestruct{A: 1, B: "before"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value.Items[0], err = NewNode(uint(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value.Items[1], err = NewNode("after, longer than before and 32 bytes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &holder{Name: "h", Value: *value}
+	bs, err := Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forEachEngine(t, func(t *testing.T) {
+		got := new(holder)
+		if err := Unmarshal(bs, got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Ptr != nil || got.Name != "h" {
+			t.Errorf("holder got %+v", got)
+		}
+		edited := new(simplestruct)
+		if err := Unmarshal(bs[2:], edited); err != nil {
+			t.Fatal(err)
+		}
+		if edited.A != 1000 || edited.B != "after, longer than before and 32 bytes" {
+			t.Errorf("edited value got %+v", edited)
+		}
+		out, err := Marshal(got)
+		if err != nil || !bytes.Equal(out, bs) {
+			t.Errorf("re-encoded %x, %v, want %x", out, err, bs)
+		}
+	})
+}
+
+func TestNodeErrors(t *testing.T) {
+	for _, node := range []*Node{
+		{Bytes: []byte{}},
+		{Header: THStringSingle, Bytes: bytes.Repeat([]byte{'a'}, 33)},
+		{Header: THArraySingle},
+		{Header: THPosNumSingle},
+		{Header: THStringMulti, Size: 1, Bytes: bytes.Repeat([]byte{'a'}, 256)},
+		{Header: THZeroValue, Version: &Node{Header: THVersion, Bytes: []byte{16}}},
+		{Header: THInvalid},
+	} {
+		if bs, err := Marshal(node); err == nil {
+			t.Errorf("marshal %+v should fail, got %x", node, bs)
+		}
+	}
+
+	for _, s := range []string{
+		"92 01",    // truncated
+		"83",       // reserved header
+		"f1 f1 80", // duplicated versions
+	} {
+		if err := Unmarshal(hexStream(t, s), new(Node)); err == nil {
+			t.Errorf("unmarshal %s should fail", s)
+		}
+	}
+
+	nested := hexStream(t, "91 91 91 01")
+	if err := NewDecoder(bytes.NewReader(nested), WithMaxNested(2)).Decode(new(Node)); !errors.Is(err, ErrNestingOverflow) {
+		t.Errorf("nested nodes got %v", err)
+	}
+}
+
+func TestNodeNoCopy(t *testing.T) {
+	bs := hexStream(t, "92 c3 616263 a2 0100")
+	node := new(Node)
+	if err := Unmarshal(bs, node); err != nil {
+		t.Fatal(err)
+	}
+	if aliases(node.Index(0).Bytes, bs) {
+		t.Errorf("bytes of node should be copied")
+	}
+	if err := UnmarshalNoCopy(bs, node); err != nil {
+		t.Fatal(err)
+	}
+	if !aliases(node.Index(0).Bytes, bs) || !aliases(node.Index(1).Bytes, bs) {
+		t.Errorf("bytes of node should reference the input in NoCopy mode")
+	}
+}
+
+func TestNodeTruncated(t *testing.T) {
+	for _, s := range []string{
+		"92 01",
+		"92 c3 6162",
+		"f1",
+		"b8",
+		"d8 01",
+	} {
+		bs := hexStream(t, s)
+		if _, err := new(Node).Deserialization(bytes.NewReader(bs)); err != io.ErrUnexpectedEOF {
+			t.Errorf("deserialization of %s got: %v", s, err)
+		}
+		forEachEngine(t, func(t *testing.T) {
+			if err := Unmarshal(bs, new(Node)); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("unmarshal %s got: %v", s, err)
+			}
+		})
+	}
+	if err := Unmarshal(nil, new(Node)); err != io.EOF {
+		t.Errorf("unmarshal nothing got: %v", err)
+	}
+}
+
+func TestNodeZero(t *testing.T) {
+	for _, v := range []interface{}{Node{}, &Node{}, []Node{{}, {Header: THTrue}}} {
+		bs, err := Marshal(v)
+		if err != nil {
+			t.Errorf("marshal %+v failed: %v", v, err)
+			continue
+		}
+		if err := Unmarshal(bs, new(Node)); err != nil {
+			t.Errorf("unmarshal %x failed: %v", bs, err)
+		}
+	}
+	if bs, err := Marshal(Node{}); err != nil || !bytes.Equal(bs, zeroValues) {
+		t.Errorf("marshal Node{} got %x, %v", bs, err)
+	}
+
+	// a missing field of Node
+	type holder struct {
+		A uint
+		N Node
+	}
+	forEachEngine(t, func(t *testing.T) {
+		h := new(holder)
+		if err := Unmarshal(hexStream(t, "91 01"), h); err != nil || h.A != 1 {
+			t.Fatalf("unmarshal got %+v, %v", h, err)
+		}
+		bs, err := Marshal(h)
+		if err != nil {
+			t.Fatalf("marshal %+v failed: %v", h, err)
+		}
+		got := new(holder)
+		if err := Unmarshal(bs, got); err != nil || got.A != 1 || got.N.Header != THZeroValue {
+			t.Errorf("unmarshal %x got %+v, %v", bs, got, err)
+		}
+	})
+}
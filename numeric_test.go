@@ -188,4 +188,23 @@ func TestCodec(t *testing.T) {
 	b := make([]byte, 8)
 	binary.BigEndian.PutUint64(b, a)
 	t.Logf("%x", b)
+
+	forEachEngine(t, func(t *testing.T) {
+		for _, m := range []interface{}{u64, u32, u16, u8, i64, i32, i16, i8} {
+			keys := reflect.ValueOf(m).MapKeys()
+			for _, key := range keys {
+				bs, err := Marshal(key.Interface())
+				if err != nil {
+					t.Fatalf("marshal %v failed: %v", key, err)
+				}
+				nv := reflect.New(key.Type())
+				if err := Unmarshal(bs, nv.Interface()); err != nil {
+					t.Fatalf("unmarshal %x failed: %v", bs, err)
+				}
+				if nv.Elem().Interface() != key.Interface() {
+					t.Errorf("%x decoded %v, want %v", bs, nv.Elem(), key)
+				}
+			}
+		}
+	})
 }
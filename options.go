@@ -0,0 +1,240 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"fmt"
+	"io"
+)
+
+// Options controls the limitations and behaviors of encoding and decoding. Options is attached to
+// the io.Writer of encoding or the ValueReader of decoding, so that all values (including the
+// values encoded/decoded by Encoder/Decoder implementations with the same stream) share it.
+type Options struct {
+	// max nested times of pointer, slice, array, map and struct when encoding or decoding
+	MaxNested int
+	// max number of elements (or bytes) of a slice, map or string created when decoding
+	MaxSliceSize int
+	// max number of bytes read when decoding one value, 0 means no limitation
+	MaxBytes int
+	// max number of bytes allocated for slices, maps and strings when decoding one value, 0 means
+	// no limitation
+	MaxAlloc int
+	// In strict mode, decoding will fail if the length of the array/slice in the stream does not
+	// match the target, or the number overflows the target. Otherwise, it's the lenient mode.
+	Strict bool
+	// In canonical mode, map entries are encoded in the order of the encoded bytes of their keys,
+	// so that the same map always has the same encoding. And decoding will fail if the keys of a
+	// map are not in that order or duplicated.
+	Canonical bool
+	// In typed interfaces mode, a non-nil interface value is encoded as a 2-elements array of the
+	// id of its dynamic type (registered by RegisterType) and the value, so that it could be
+	// decoded into an interface with methods. Both sides of the stream must use this mode.
+	TypedInterfaces bool
+	// In compact zeros mode, the orders skipped by the fields of a struct are encoded as a run of
+	// zero values (THZeros) instead of one zero value for each, and the trailing fields of zero
+	// values are omitted. Streams in this mode could not be decoded by the versions without THZeros.
+	// Decoding into interface{}, a run is expanded to nil elements.
+	CompactZeros bool
+	// In framed mode, StreamEncoder writes each value as a byte string (length-prefixed frame) of
+	// its encoding, so that StreamDecoder could skip a corrupt value and continue with the next.
+	Framed bool
+	// In no-copy mode, the []byte values and RawValues decoded from an in-memory input (Unmarshal,
+	// NewBytesValueReader) are sub-slices of the input instead of copies, the input must not be
+	// modified while they are in use.
+	NoCopy bool
+	// Engine selects the implementation of decoding, EngineDefault follows SetDefaultEngine
+	Engine Engine
+}
+
+// Engine is the implementation of decoding
+type Engine int
+
+const (
+	// EngineDefault uses the engine set by SetDefaultEngine
+	EngineDefault Engine = iota
+	// EngineV1 is the recursive decoder
+	EngineV1
+	// EngineV2 is the EventDecoder, which is not recursive, so that the depth of the value is
+	// not limited by the goroutine stack
+	EngineV2
+)
+
+func (e Engine) String() string {
+	switch e {
+	case EngineDefault:
+		return "Default"
+	case EngineV1:
+		return "V1"
+	case EngineV2:
+		return "V2"
+	default:
+		return fmt.Sprintf("Engine(%d)", int(e))
+	}
+}
+
+type Option func(opts *Options)
+
+// WithMaxNested sets the max nested times when encoding or decoding
+func WithMaxNested(n int) Option {
+	return func(opts *Options) {
+		opts.MaxNested = n
+	}
+}
+
+// WithMaxSliceSize sets the max size of slice, map and string could be created when decoding
+func WithMaxSliceSize(n int) Option {
+	return func(opts *Options) {
+		opts.MaxSliceSize = n
+	}
+}
+
+// WithMaxBytes sets the max number of bytes could be read when decoding a value
+func WithMaxBytes(n int) Option {
+	return func(opts *Options) {
+		opts.MaxBytes = n
+	}
+}
+
+// WithMaxAlloc sets the max number of bytes could be allocated for slices, maps and strings when
+// decoding a value
+func WithMaxAlloc(n int) Option {
+	return func(opts *Options) {
+		opts.MaxAlloc = n
+	}
+}
+
+// WithStrict decodes in strict mode
+func WithStrict() Option {
+	return func(opts *Options) {
+		opts.Strict = true
+	}
+}
+
+// WithLenient decodes in lenient mode, which is the default mode
+func WithLenient() Option {
+	return func(opts *Options) {
+		opts.Strict = false
+	}
+}
+
+// WithCanonical encodes maps deterministically, and rejects non-canonical maps when decoding
+func WithCanonical() Option {
+	return func(opts *Options) {
+		opts.Canonical = true
+	}
+}
+
+// WithTypedInterfaces encodes/decodes interface values with the ids of their registered types
+func WithTypedInterfaces() Option {
+	return func(opts *Options) {
+		opts.TypedInterfaces = true
+	}
+}
+
+// WithCompactZeros encodes the skipped orders and the trailing zero fields of structs compactly
+func WithCompactZeros() Option {
+	return func(opts *Options) {
+		opts.CompactZeros = true
+	}
+}
+
+// WithFramed encodes or decodes the values of a stream in length-prefixed frames, it's used by
+// StreamEncoder and StreamDecoder only
+func WithFramed() Option {
+	return func(opts *Options) {
+		opts.Framed = true
+	}
+}
+
+// WithNoCopy decodes []byte values and RawValues from an in-memory input without copying
+func WithNoCopy() Option {
+	return func(opts *Options) {
+		opts.NoCopy = true
+	}
+}
+
+// WithEngine decodes with the specified engine
+func WithEngine(engine Engine) Option {
+	return func(opts *Options) {
+		opts.Engine = engine
+	}
+}
+
+var defaultOptions = Options{
+	MaxNested:    MaxNested,
+	MaxSliceSize: MaxSliceSize,
+}
+
+func newOptions(opts ...Option) *Options {
+	ret := defaultOptions
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&ret)
+		}
+	}
+	return &ret
+}
+
+// checkLength checks whether a slice, map or string with length elements could be created
+func (o *Options) checkLength(length int) error {
+	if length < 0 || (o.MaxSliceSize > 0 && length > o.MaxSliceSize) {
+		return fmt.Errorf("%w: length %d exceeds the limit %d", ErrTooLarge, length, o.MaxSliceSize)
+	}
+	return nil
+}
+
+// checkNesting checks whether the nesting times is overflowed
+func (o *Options) checkNesting(nesting int) error {
+	if nesting > o.MaxNested {
+		return ErrNestingOverflow
+	}
+	return nil
+}
+
+type optionsHolder interface {
+	options() *Options
+}
+
+// optionsOf returns the Options attached to the stream, or the default options if not found.
+func optionsOf(stream interface{}) *Options {
+	if holder, ok := stream.(optionsHolder); ok {
+		if opts := holder.options(); opts != nil {
+			return opts
+		}
+	}
+	return &defaultOptions
+}
+
+// optionsWriter attaches Options to an io.Writer, with a scratch buffer for headers
+type optionsWriter struct {
+	io.Writer
+	opts *Options
+	hbuf [9]byte
+}
+
+func (w *optionsWriter) options() *Options {
+	return w.opts
+}
+
+func (w *optionsWriter) scratch() []byte {
+	return w.hbuf[:]
+}
+
+func (w *optionsWriter) WriteString(s string) (int, error) {
+	return io.WriteString(w.Writer, s)
+}
@@ -0,0 +1,520 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type decodeFunc func(d *ValueDecoder, v interface{}) error
+
+var decodeFuncs = map[string]decodeFunc{
+	"V1": (*ValueDecoder).Decode,
+	"V2": (*ValueDecoder).DecodeV2,
+}
+
+// forEachEngine runs test with each decoding engine as the default engine of Decode and Unmarshal
+func forEachEngine(t *testing.T, test func(t *testing.T)) {
+	defer SetDefaultEngine(DefaultEngine())
+	for _, engine := range []Engine{EngineV1, EngineV2} {
+		SetDefaultEngine(engine)
+		t.Run(engine.String(), test)
+	}
+}
+
+func TestOptionsMaxNested(t *testing.T) {
+	v := [][][]int{{{1, 2}, {3}}, {{4}}}
+
+	buf := new(bytes.Buffer)
+	if err := NewEncoder(buf, WithMaxNested(1)).Encode(v); !errors.Is(err, ErrNestingOverflow) {
+		t.Fatalf("encode with MaxNested=1 should fail with %v, but got: %v", ErrNestingOverflow, err)
+	}
+
+	buf.Reset()
+	if err := NewEncoder(buf, WithMaxNested(3)).Encode(v); err != nil {
+		t.Fatalf("encode with MaxNested=3 failed: %v", err)
+	}
+	bs := buf.Bytes()
+
+	for name, decode := range decodeFuncs {
+		var got [][][]int
+		if err := decode(NewDecoder(bytes.NewReader(bs), WithMaxNested(1)), &got); !errors.Is(err, ErrNestingOverflow) {
+			t.Errorf("%s: decode with MaxNested=1 should fail with %v, but got: %v", name, ErrNestingOverflow, err)
+		}
+		got = nil
+		if err := decode(NewDecoder(bytes.NewReader(bs), WithMaxNested(3)), &got); err != nil {
+			t.Errorf("%s: decode with MaxNested=3 failed: %v", name, err)
+		} else if len(got) != 2 || len(got[0]) != 2 || got[0][0][1] != 2 || got[1][0][0] != 4 {
+			t.Errorf("%s: decode %v, want %v", name, got, v)
+		}
+	}
+}
+
+func TestOptionsMaxSliceSize(t *testing.T) {
+	ints := make([]int, 100)
+	for i := range ints {
+		ints[i] = i
+	}
+	intsBytes, err := Marshal(ints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	str := strings.Repeat("rtl", 100)
+	strBytes, err := Marshal(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapBytes, err := Marshal(map[int]int{1: 1, 2: 2, 3: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, decode := range decodeFuncs {
+		var gotInts []int
+		if err := decode(NewDecoder(bytes.NewReader(intsBytes), WithMaxSliceSize(10)), &gotInts); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: decode slice should fail with %v, but got: %v", name, ErrTooLarge, err)
+		}
+		var gotStr string
+		if err := decode(NewDecoder(bytes.NewReader(strBytes), WithMaxSliceSize(10)), &gotStr); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: decode string should fail with %v, but got: %v", name, ErrTooLarge, err)
+		}
+		var gotMap map[int]int
+		if err := decode(NewDecoder(bytes.NewReader(mapBytes), WithMaxSliceSize(2)), &gotMap); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: decode map should fail with %v, but got: %v", name, ErrTooLarge, err)
+		}
+
+		gotInts = nil
+		if err := decode(NewDecoder(bytes.NewReader(intsBytes), WithMaxSliceSize(100)), &gotInts); err != nil {
+			t.Errorf("%s: decode slice failed: %v", name, err)
+		} else if len(gotInts) != 100 || gotInts[99] != 99 {
+			t.Errorf("%s: decode slice got %v", name, gotInts)
+		}
+	}
+}
+
+func TestOptionsMaxBytes(t *testing.T) {
+	type sample struct {
+		A string
+		B []uint
+	}
+	v := sample{A: "max bytes", B: []uint{1, 2, 300, 40000}}
+	bs, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two values in one stream, MaxBytes is applied to each of them
+	stream := append(append([]byte(nil), bs...), bs...)
+
+	for name, decode := range decodeFuncs {
+		var got sample
+		if err := decode(NewDecoder(bytes.NewReader(bs), WithMaxBytes(len(bs)-1)), &got); !errors.Is(err, ErrExceedMaxBytes) {
+			t.Errorf("%s: decode should fail with %v, but got: %v", name, ErrExceedMaxBytes, err)
+		}
+
+		dec := NewDecoder(bytes.NewReader(stream), WithMaxBytes(len(bs)))
+		for i := 0; i < 2; i++ {
+			got = sample{}
+			if err := decode(dec, &got); err != nil {
+				t.Errorf("%s: decode the %dth value failed: %v", name, i, err)
+			} else if got.A != v.A || len(got.B) != len(v.B) || got.B[3] != v.B[3] {
+				t.Errorf("%s: decode the %dth value got %+v, want %+v", name, i, got, v)
+			}
+		}
+	}
+}
+
+func TestOptionsStrict(t *testing.T) {
+	arrBytes, err := Marshal([3]int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	numBytes, err := Marshal(300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strBytes, err := Marshal("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, decode := range decodeFuncs {
+		var arr [2]int
+		if err := decode(NewDecoder(bytes.NewReader(arrBytes)), &arr); err != nil || arr != [2]int{1, 2} {
+			t.Errorf("%s: lenient decode array got %v, %v", name, arr, err)
+		}
+		if err := decode(NewDecoder(bytes.NewReader(arrBytes), WithStrict()), &arr); !errors.Is(err, ErrLength) {
+			t.Errorf("%s: strict decode array should fail with %v, but got: %v", name, ErrLength, err)
+		}
+
+		var bs [2]byte
+		if err := decode(NewDecoder(bytes.NewReader(strBytes)), &bs); err != nil || bs != [2]byte{'a', 'b'} {
+			t.Errorf("%s: lenient decode byte array got %v, %v", name, bs, err)
+		}
+		if err := decode(NewDecoder(bytes.NewReader(strBytes), WithStrict()), &bs); !errors.Is(err, ErrLength) {
+			t.Errorf("%s: strict decode byte array should fail with %v, but got: %v", name, ErrLength, err)
+		}
+
+		var i8 int8
+		if err := decode(NewDecoder(bytes.NewReader(numBytes), WithLenient()), &i8); err != nil || i8 != int8(300&0xff) {
+			t.Errorf("%s: lenient decode int8 got %d, %v", name, i8, err)
+		}
+		if err := decode(NewDecoder(bytes.NewReader(numBytes), WithStrict()), &i8); !errors.Is(err, ErrOverflow) {
+			t.Errorf("%s: strict decode int8 should fail with %v, but got: %v", name, ErrOverflow, err)
+		}
+		var u16 uint16
+		if err := decode(NewDecoder(bytes.NewReader(numBytes), WithStrict()), &u16); err != nil || u16 != 300 {
+			t.Errorf("%s: strict decode uint16 got %d, %v", name, u16, err)
+		}
+	}
+}
+
+type canonicalKey struct {
+	A string
+	B int
+}
+
+func TestOptionsCanonicalEncode(t *testing.T) {
+	strMap := make(map[string]int)
+	structMap := make(map[canonicalKey]string)
+	bigMap := make(map[*big.Int]uint)
+	for i := 0; i < 50; i++ {
+		strMap[fmt.Sprintf("key-%d", i*7)] = i
+		structMap[canonicalKey{A: fmt.Sprintf("%x", i*13), B: -i}] = fmt.Sprint(i)
+		bigMap[new(big.Int).Lsh(big.NewInt(int64(i+1)), uint(i*3))] = uint(i)
+	}
+
+	for _, v := range []interface{}{strMap, structMap, bigMap} {
+		var first []byte
+		for i := 0; i < 20; i++ {
+			buf := new(bytes.Buffer)
+			if err := NewEncoder(buf, WithCanonical()).Encode(v); err != nil {
+				t.Fatalf("canonical encode %T failed: %v", v, err)
+			}
+			if first == nil {
+				first = buf.Bytes()
+			} else if !bytes.Equal(first, buf.Bytes()) {
+				t.Fatalf("canonical encoding of %T is not deterministic", v)
+			}
+		}
+
+		for name, decode := range decodeFuncs {
+			got := reflect.New(reflect.TypeOf(v))
+			if err := decode(NewDecoder(bytes.NewReader(first), WithCanonical()), got.Interface()); err != nil {
+				t.Errorf("%s: canonical decode %T failed: %v", name, v, err)
+			} else if got.Elem().Len() != 50 {
+				t.Errorf("%s: canonical decode %T got %d entries", name, v, got.Elem().Len())
+			}
+		}
+	}
+}
+
+func TestOptionsCanonicalDecode(t *testing.T) {
+	unsorted, err := Marshal([]interface{}{"b", 1, "a", 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplicated, err := Marshal([]interface{}{"a", 1, "a", 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sorted, err := Marshal([]interface{}{"a", 1, "b", 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, decode := range decodeFuncs {
+		for _, bs := range [][]byte{unsorted, duplicated} {
+			var got map[string]int
+			if err := decode(NewDecoder(bytes.NewReader(bs)), &got); err != nil {
+				t.Errorf("%s: decode %x failed: %v", name, bs, err)
+			}
+			got = nil
+			if err := decode(NewDecoder(bytes.NewReader(bs), WithCanonical()), &got); !errors.Is(err, ErrNonCanonical) {
+				t.Errorf("%s: canonical decode %x should fail with %v, but got: %v", name, bs, ErrNonCanonical, err)
+			}
+		}
+		var got map[string]int
+		if err := decode(NewDecoder(bytes.NewReader(sorted), WithCanonical()), &got); err != nil || got["a"] != 1 || got["b"] != 2 {
+			t.Errorf("%s: canonical decode got %v, %v", name, got, err)
+		}
+	}
+
+	// keys in non-minimal encodings, of {"a": 1, "bb": 2} and {5: 1}
+	canonical := hexStream(t, "94 61 01 c26262 02")
+	for _, s := range []string{"94 c161 01 c26262 02", "94 61 01 e102 6262 02", "94 61 01 e20002 6262 02"} {
+		bs := hexStream(t, s)
+		forEachEngine(t, func(t *testing.T) {
+			var got map[string]int
+			if err := Unmarshal(bs, &got); err != nil || got["a"] != 1 || got["bb"] != 2 {
+				t.Fatalf("decode %s got %v, %v", s, got, err)
+			}
+			if err := NewDecoder(bytes.NewReader(bs), WithCanonical()).Decode(&got); !errors.Is(err, ErrNonCanonical) {
+				t.Errorf("canonical decode %s should fail with %v, but got: %v", s, ErrNonCanonical, err)
+			}
+			if err := NewDecoder(bytes.NewReader(canonical), WithCanonical()).Decode(&got); err != nil {
+				t.Errorf("canonical decode %x failed: %v", canonical, err)
+			}
+		})
+	}
+	forEachEngine(t, func(t *testing.T) {
+		var got map[uint]int
+		if err := NewDecoder(bytes.NewReader(hexStream(t, "92 a105 01")), WithCanonical()).Decode(&got); !errors.Is(err, ErrNonCanonical) {
+			t.Errorf("canonical decode non-minimal number key got %v, %v", got, err)
+		}
+	})
+}
+
+func TestOptionsEngine(t *testing.T) {
+	if DefaultEngine() != EngineV1 {
+		t.Fatalf("default engine is %s", DefaultEngine())
+	}
+
+	var deep interface{} = "bottom"
+	for i := 0; i < 100000; i++ {
+		deep = []interface{}{deep}
+	}
+	buf := new(bytes.Buffer)
+	if err := NewEncoder(buf, WithMaxNested(1<<20)).EncodeV2(deep); err != nil {
+		t.Fatal(err)
+	}
+	bs := buf.Bytes()
+	check := func(name string, got interface{}) {
+		for i := 0; i < 100000; i++ {
+			elems, ok := got.([]interface{})
+			if !ok || len(elems) != 1 {
+				t.Fatalf("%s: level %d of deep value got %T", name, i, got)
+			}
+			got = elems[0]
+		}
+		if got != "bottom" {
+			t.Fatalf("%s: bottom of deep value got %v", name, got)
+		}
+	}
+
+	var got interface{}
+	if err := NewDecoder(bytes.NewReader(bs), WithMaxNested(1<<20), WithEngine(EngineV2)).Decode(&got); err != nil {
+		t.Fatalf("decode deep value with EngineV2 failed: %v", err)
+	}
+	check("per-call", got)
+
+	defer SetDefaultEngine(EngineDefault)
+	SetDefaultEngine(EngineV2)
+	got = nil
+	if err := NewDecoder(bytes.NewReader(bs), WithMaxNested(1<<20)).Decode(&got); err != nil {
+		t.Fatalf("decode deep value with default EngineV2 failed: %v", err)
+	}
+	check("default", got)
+	if err := Unmarshal(bs, &got); !errors.Is(err, ErrNestingOverflow) {
+		t.Errorf("unmarshal deep value should fail with %v, but got: %v", ErrNestingOverflow, err)
+	}
+}
+
+type (
+	compactInner struct {
+		X uint   `rtlorder:"0"`
+		Y string `rtlorder:"4"`
+	}
+	compactStruct struct {
+		A uint          `rtlorder:"0"`
+		B string        `rtlorder:"5"`
+		C *compactInner `rtlorder:"6"`
+		D []int         `rtlorder:"7"`
+		E uint          `rtlorder:"10"`
+	}
+	// the old version of compactStruct, without C and D
+	compactOld struct {
+		A uint   `rtlorder:"0"`
+		B string `rtlorder:"5"`
+		E uint   `rtlorder:"10"`
+	}
+	// encodes compactStruct by itself with the options of the output
+	compactEncoder struct {
+		val *compactStruct
+	}
+)
+
+func (e compactEncoder) Serialization(w io.Writer) error {
+	return Encode(e.val, w)
+}
+
+func TestOptionsCompactZeros(t *testing.T) {
+	compact := func(v interface{}) []byte {
+		var bs [2][]byte
+		for i, encode := range []func(*ValueEncoder, interface{}) error{
+			(*ValueEncoder).Encode, (*ValueEncoder).EncodeV2} {
+			buf := new(bytes.Buffer)
+			if err := encode(NewEncoder(buf, WithCompactZeros()), v); err != nil {
+				t.Fatalf("compact encode %+v failed: %v", v, err)
+			}
+			bs[i] = buf.Bytes()
+		}
+		if !bytes.Equal(bs[0], bs[1]) {
+			t.Fatalf("compact encode %+v, V1: %x, V2: %x", v, bs[0], bs[1])
+		}
+		return bs[0]
+	}
+
+	tests := []struct {
+		val  *compactStruct
+		want string
+	}{
+		// [1, (4 zeros), "x"]
+		{&compactStruct{A: 1, B: "x"}, "93018504" + "78"},
+		// [1, (4 zeros), "x", [0, (3 zeros), "yes"], 0, 0, 0, 9]
+		{&compactStruct{A: 1, B: "x", C: &compactInner{Y: "yes"}, E: 9},
+			"98018504" + "78" + "93008503c3796573" + "80" + "8080" + "09"},
+		{&compactStruct{}, "80"},
+	}
+	forEachEngine(t, func(t *testing.T) {
+		for _, test := range tests {
+			bs := compact(test.val)
+			if hex.EncodeToString(bs) != test.want {
+				t.Errorf("compact encode %+v got %x, want %s", test.val, bs, test.want)
+			}
+			got := &compactStruct{A: 100, B: "dirty", C: &compactInner{X: 1}, D: []int{1}, E: 100}
+			if err := Unmarshal(bs, got); err != nil {
+				t.Fatalf("decode %x failed: %v", bs, err)
+			}
+			if !reflect.DeepEqual(got, test.val) {
+				t.Errorf("decode %x got %+v, want %+v", bs, got, test.val)
+			}
+			normal, err := Marshal(test.val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			old, want := new(compactOld), new(compactOld)
+			if err := Unmarshal(bs, old); err != nil {
+				t.Fatalf("decode %x to old version failed: %v", bs, err)
+			}
+			if err := Unmarshal(normal, want); err != nil {
+				t.Fatal(err)
+			}
+			if *old != *want {
+				t.Errorf("decode %x to old version got %+v, want %+v", bs, old, want)
+			}
+		}
+	})
+
+	// skipping, and locating by path
+	bs := compact(&compactStruct{A: 1, B: "x", C: &compactInner{Y: "yes"}, E: 9})
+	var raw RawValue
+	if err := Unmarshal(append(bs, 0x05), &raw); err != nil || !bytes.Equal(raw, bs) {
+		t.Errorf("raw value of %x got %x, %v", bs, []byte(raw), err)
+	}
+	var s string
+	if err := DecodePath(bytes.NewReader(bs), []int{6, 4}, &s); err != nil || s != "yes" {
+		t.Errorf("decode path [6 4] got %q, %v", s, err)
+	}
+	u := uint(100)
+	if err := DecodePath(bytes.NewReader(bs), []int{3}, &u); err != nil || u != 0 {
+		t.Errorf("decode path [3] got %d, %v", u, err)
+	}
+	if err := DecodePath(bytes.NewReader(bs), []int{10}, &u); err != nil || u != 9 {
+		t.Errorf("decode path [10] got %d, %v", u, err)
+	}
+	if err := DecodePath(bytes.NewReader(bs), []int{2, 0}, &u); !errors.Is(err, ErrPathNotFound) {
+		t.Errorf("decode path [2 0] should fail with %v, but got: %v", ErrPathNotFound, err)
+	}
+
+	// options are passed to the Encoder implementations
+	val := &compactStruct{A: 1, B: "x", C: &compactInner{Y: "yes"}, E: 9}
+	if got, want := compact([]compactEncoder{{val}}), compact([]*compactStruct{val}); !bytes.Equal(got, want) {
+		t.Errorf("compact encode by Encoder got %x, want %x", got, want)
+	}
+
+	// runs of zero values are elements of struct only
+	for _, engine := range []Engine{EngineV1, EngineV2} {
+		var ints []int
+		if err := NewDecoder(bytes.NewReader([]byte{0x92, 0x85, 0x03, 0x01}), WithEngine(engine)).Decode(&ints); err == nil {
+			t.Errorf("%s: decode run of zero values into slice should fail, but got %v", engine, ints)
+		}
+	}
+}
+
+func TestCompactZerosRoundTrip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	val := &compactStruct{A: 1, B: "x", C: &compactInner{Y: "yes"}, E: 9}
+	if err := NewEncoder(buf, WithCompactZeros()).Encode(val); err != nil {
+		t.Fatal(err)
+	}
+	bs := buf.Bytes()
+	// followed by another value, which should not be consumed by the value of zero runs
+	stream := append(append([]byte(nil), bs...), 0x05)
+
+	forEachEngine(t, func(t *testing.T) {
+		dec := NewDecoder(bytes.NewReader(stream))
+		var i interface{}
+		if err := dec.Decode(&i); err != nil {
+			t.Fatalf("decode %x into interface{} failed: %v", bs, err)
+		}
+		// runs of zero values are expanded
+		want := []interface{}{
+			uint64(1), nil, nil, nil, nil, uint64('x'),
+			[]interface{}{uint64(0), nil, nil, nil, "yes"},
+			nil, nil, nil, uint64(9),
+		}
+		if !reflect.DeepEqual(i, want) {
+			t.Errorf("decode %x into interface{} got %v, want %v", bs, i, want)
+		}
+		var u uint
+		if err := dec.Decode(&u); err != nil || u != 5 {
+			t.Errorf("decode the next value got %d, %v", u, err)
+		}
+
+		dec = NewDecoder(bytes.NewReader(stream))
+		node := new(Node)
+		if err := dec.Decode(node); err != nil {
+			t.Fatalf("decode %x into Node failed: %v", bs, err)
+		}
+		if out, err := Marshal(node); err != nil || !bytes.Equal(out, bs) {
+			t.Errorf("re-encoded node got %x, %v, want %x", out, err, bs)
+		}
+		if err := dec.Decode(&u); err != nil || u != 5 {
+			t.Errorf("decode the next value of Node got %d, %v", u, err)
+		}
+
+		dec = NewDecoder(bytes.NewReader(stream))
+		var raw RawValue
+		if err := dec.Decode(&raw); err != nil || !bytes.Equal(raw, bs) {
+			t.Errorf("raw value got %x, %v, want %x", []byte(raw), err, bs)
+		}
+		got := new(compactStruct)
+		if err := raw.Decode(got); err != nil || !reflect.DeepEqual(got, val) {
+			t.Errorf("decode raw value got %+v, %v", got, err)
+		}
+		if err := dec.Decode(&u); err != nil || u != 5 {
+			t.Errorf("decode the next value of RawValue got %d, %v", u, err)
+		}
+	})
+
+	vr := NewValueReader(bytes.NewReader(stream))
+	if n, err := vr.Skip(); err != nil || n != len(bs) {
+		t.Errorf("skip %x got %d, %v", bs, n, err)
+	}
+	out := new(bytes.Buffer)
+	if err := Walk(bytes.NewReader(bs), walkTranscoder{w: NewTokenWriter(out)}); err != nil || !bytes.Equal(out.Bytes(), bs) {
+		t.Errorf("walk %x got %x, %v", bs, out.Bytes(), err)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// pathStep is the position of an element in an array value (array, slice or struct)
+type pathStep struct {
+	index   int
+	version int // version of the struct field, or noVersion
+}
+
+// fieldsOnly returns true if all of the steps are struct fields, which are zero values in a zero
+// value, or when left out of the stream.
+func fieldsOnly(steps []pathStep) bool {
+	for _, step := range steps {
+		if step.version == noVersion {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodePath decodes the value located by path into v, without decoding the others. Each index
+// of the path is the position of an element in an array value of the stream, which is the index
+// of array/slice, or the rtlorder of a struct field. For example, path [3, 0, 7] locates the
+// 8th element of the 1st element of the 4th element of the value in r.
+// As the type is unknown, only an element of a versioned struct left out of the stream is decoded
+// as zero value, the other missing ones are ErrPathNotFound.
+func DecodePath(r io.Reader, path []int, v interface{}) error {
+	steps := make([]pathStep, len(path))
+	for i, index := range path {
+		steps[i] = pathStep{index: index, version: noVersion}
+	}
+	return decodePath(ValueReaderOf(r), steps, v)
+}
+
+// DecodeFieldPath decodes the value located by names into v, without decoding the others. The
+// names are resolved in the type of obj (usually a nil pointer, e.g. (*Block)(nil)): the name of
+// a field for struct, or the decimal index for array and slice. For example, names
+// ["Body", "Txs", "3", "Hash"] locates block.Body.Txs[3].Hash.
+// A field not in the stream (e.g. zero fields truncated from the end, fields of a higher version,
+// or fields of a zero struct) is decoded as zero value.
+func DecodeFieldPath(r io.Reader, obj interface{}, names []string, v interface{}) error {
+	if obj == nil {
+		return errors.New("rtl: nil type of path")
+	}
+	steps, err := resolvePath(reflect.TypeOf(obj), names)
+	if err != nil {
+		return err
+	}
+	return decodePath(ValueReaderOf(r), steps, v)
+}
+
+func resolvePath(typ reflect.Type, names []string) ([]pathStep, error) {
+	steps := make([]pathStep, 0, len(names))
+	for i, name := range names {
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ.Implements(TypeOfEncoder) || reflect.PtrTo(typ).Implements(TypeOfEncoder) ||
+			priorStructWriter(typ) != nil || priorStructWriter(reflect.PtrTo(typ)) != nil {
+			return nil, fmt.Errorf("rtl: could not resolve %s in %v, path: %v", name, typ, names[:i+1])
+		}
+		switch typ.Kind() {
+		case reflect.Struct:
+			_, fields := structFields(typ)
+			found := false
+			for _, f := range fields {
+				if f.name == name {
+					steps = append(steps, pathStep{index: f.order, version: f.version})
+					typ = typ.Field(f.index).Type
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("rtl: field %s not found in %v, path: %v", name, typ, names[:i+1])
+			}
+		case reflect.Array, reflect.Slice:
+			if typ.Elem().Kind() == reflect.Uint8 {
+				// byte array/slice is encoded as string
+				return nil, fmt.Errorf("rtl: could not resolve %s in %v, path: %v", name, typ, names[:i+1])
+			}
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("rtl: illegal index %s of %v, path: %v", name, typ, names[:i+1])
+			}
+			steps = append(steps, pathStep{index: index, version: noVersion})
+			typ = typ.Elem()
+		default:
+			return nil, fmt.Errorf("rtl: could not resolve %s in %v, path: %v", name, typ, names[:i+1])
+		}
+	}
+	return steps, nil
+}
+
+func decodePath(vr ValueReader, steps []pathStep, v interface{}) error {
+	zero := func() error {
+		return Decode(newValueReader(bytes.NewReader(zeroValues), optionsOf(vr)), v)
+	}
+	for i, step := range steps {
+		if step.index < 0 {
+			return fmt.Errorf("%w: illegal index %d at step %d", ErrPathNotFound, step.index, i)
+		}
+		th, length, err := vr.ReadHeader()
+		if err != nil {
+			return err
+		}
+		version, th, length, err := readVersion(th, length, vr)
+		if err != nil {
+			return err
+		}
+		if step.version > 0 && version != noVersion && step.version > version {
+			if fieldsOnly(steps[i+1:]) {
+				return zero()
+			}
+			return fmt.Errorf("%w: version %d of the field at step %d is higher than the version %d of the stream",
+				ErrPathNotFound, step.version, i, version)
+		}
+		switch th {
+		case THArraySingle:
+		case THArrayMulti:
+			l, err := vr.ReadMultiLength(length)
+			if err != nil {
+				return err
+			}
+			length = int(l)
+		default:
+			// fields of a zero struct
+			if th == THZeroValue && fieldsOnly(steps[i:]) {
+				return zero()
+			}
+			return fmt.Errorf("%w: %s found at step %d", ErrPathNotFound, th, i)
+		}
+		// a run of zero values (THZeros) is one element of the array, covers many indexes
+		for item, index := 0, 0; ; item++ {
+			if item >= length {
+				// trailing zero fields are not in the stream
+				if (step.version != noVersion || version != noVersion) && fieldsOnly(steps[i+1:]) {
+					return zero()
+				}
+				return fmt.Errorf("%w: index %d out of range %d at step %d", ErrPathNotFound, step.index, index, i)
+			}
+			run, err := ReadZeros(vr)
+			if err != nil {
+				return err
+			}
+			if run == 0 {
+				if index == step.index {
+					break
+				}
+				if _, err := vr.Skip(); err != nil {
+					return err
+				}
+				index++
+				continue
+			}
+			if index += run; index > step.index {
+				if !fieldsOnly(steps[i+1:]) {
+					return fmt.Errorf("%w: zero value found at step %d", ErrPathNotFound, i)
+				}
+				return zero()
+			}
+		}
+	}
+	return Decode(vr, v)
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+type (
+	pathTx struct {
+		Nonce uint64
+		Hash  [4]byte
+		Value *big.Int
+	}
+
+	pathBody struct {
+		Txs   []*pathTx
+		Extra string `rtlorder:"3"`
+	}
+
+	pathBlock struct {
+		Height uint64
+		Body   *pathBody
+		Sig    []byte `rtlversion:"1"`
+	}
+)
+
+func TestDecodePath(t *testing.T) {
+	block := &pathBlock{
+		Height: 100,
+		Body: &pathBody{
+			Txs: []*pathTx{
+				{Nonce: 1, Hash: [4]byte{1, 1, 1, 1}, Value: big.NewInt(-1)},
+				{Nonce: 2, Hash: [4]byte{2, 2, 2, 2}, Value: big.NewInt(2000)},
+				nil,
+			},
+			Extra: "extra",
+		},
+	}
+	bs, err := Marshal(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  []int
+		names []string
+		want  interface{}
+	}{
+		{[]int{0}, []string{"Height"}, uint64(100)},
+		{[]int{1, 0, 1, 1}, []string{"Body", "Txs", "1", "Hash"}, [4]byte{2, 2, 2, 2}},
+		{[]int{1, 0, 0, 2}, []string{"Body", "Txs", "0", "Value"}, big.NewInt(-1)},
+		{[]int{1, 0, 1}, []string{"Body", "Txs", "1"}, block.Body.Txs[1]},
+		{[]int{1, 3}, []string{"Body", "Extra"}, "extra"},
+		{[]int{1}, []string{"Body"}, block.Body},
+	}
+	for _, test := range tests {
+		got := reflect.New(reflect.TypeOf(test.want))
+		if err := DecodePath(bytes.NewReader(bs), test.path, got.Interface()); err != nil {
+			t.Errorf("decode path %v failed: %v", test.path, err)
+		} else if !reflect.DeepEqual(got.Elem().Interface(), test.want) {
+			t.Errorf("decode path %v got %v, want %v", test.path, got.Elem().Interface(), test.want)
+		}
+		got = reflect.New(reflect.TypeOf(test.want))
+		if err := DecodeFieldPath(bytes.NewReader(bs), (*pathBlock)(nil), test.names, got.Interface()); err != nil {
+			t.Errorf("decode field path %v failed: %v", test.names, err)
+		} else if !reflect.DeepEqual(got.Elem().Interface(), test.want) {
+			t.Errorf("decode field path %v got %v, want %v", test.names, got.Elem().Interface(), test.want)
+		}
+	}
+
+	var u uint64
+	for _, path := range [][]int{{5}, {1, 0, 3}, {1, 0, 2, 0}, {0, 0}, {-1}} {
+		if err := DecodePath(bytes.NewReader(bs), path, &u); !errors.Is(err, ErrPathNotFound) {
+			t.Errorf("decode path %v should fail with %v, but got: %v", path, ErrPathNotFound, err)
+		}
+	}
+	// Sig (version 1) is not in the stream of version 0
+	sig := []byte("dirty")
+	if err := DecodeFieldPath(bytes.NewReader(bs), (*pathBlock)(nil), []string{"Sig"}, &sig); err != nil || sig != nil {
+		t.Errorf("decode field of higher version got %x, %v", sig, err)
+	}
+	for _, names := range [][]string{{"Unknown"}, {"Body", "Txs", "x"}, {"Body", "Txs", "0", "Value", "abs"}, {"Sig", "0"}} {
+		if err := DecodeFieldPath(bytes.NewReader(bs), (*pathBlock)(nil), names, &u); err == nil {
+			t.Errorf("decode field path %v should fail", names)
+		}
+	}
+}
+
+func TestDecodePathZeros(t *testing.T) {
+	type versioned struct {
+		A uint
+		B string  `rtlversion:"1"`
+		C *pathTx `rtlversion:"2"`
+	}
+	type holder struct {
+		V   versioned
+		Tx  *pathTx
+		Txs []*pathTx
+	}
+
+	u := uint64(100)
+	s := "dirty"
+	check := func(t *testing.T, bs []byte, names []string, v interface{}, want interface{}) {
+		t.Helper()
+		if err := DecodeFieldPath(bytes.NewReader(bs), (*holder)(nil), names, v); err != nil {
+			t.Errorf("decode field path %v of %x failed: %v", names, bs, err)
+		} else if got := reflect.ValueOf(v).Elem().Interface(); !reflect.DeepEqual(got, want) {
+			t.Errorf("decode field path %v of %x got %v, want %v", names, bs, got, want)
+		}
+	}
+
+	forEachEngine(t, func(t *testing.T) {
+		// V of version 1, C is left out, and the Tx (nil) and Txs (nil) are zero values
+		bs, err := Marshal(&holder{V: versioned{A: 1, B: "b"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, bs, []string{"V", "B"}, &s, "b")
+		u = 100
+		check(t, bs, []string{"V", "C", "Nonce"}, &u, uint64(0))
+		u = 100
+		check(t, bs, []string{"Tx", "Nonce"}, &u, uint64(0))
+		u = 100
+		check(t, bs, []string{"Tx", "Value"}, new(*big.Int), (*big.Int)(nil))
+		if err := DecodeFieldPath(bytes.NewReader(bs), (*holder)(nil), []string{"Txs", "0", "Nonce"}, &u); !errors.Is(err, ErrPathNotFound) {
+			t.Errorf("item of nil slice should fail with %v, but got: %v", ErrPathNotFound, err)
+		}
+
+		// the trailing zero fields of V and holder are truncated
+		bs, err = Marshal(&holder{V: versioned{A: 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s = "dirty"
+		check(t, bs, []string{"V", "B"}, &s, "")
+		u = 100
+		check(t, bs, []string{"V", "A"}, &u, uint64(1))
+		u = 100
+		check(t, bs, []string{"Tx", "Nonce"}, &u, uint64(0))
+
+		// the compact zeros
+		buf := new(bytes.Buffer)
+		if err := NewEncoder(buf, WithCompactZeros()).Encode(&pathTx{Nonce: 1}); err != nil {
+			t.Fatal(err)
+		}
+		value := big.NewInt(100)
+		if err := DecodeFieldPath(bytes.NewReader(buf.Bytes()), (*pathTx)(nil), []string{"Value"}, &value); err != nil || value != nil {
+			t.Errorf("decode value of %x got %v, %v", buf.Bytes(), value, err)
+		}
+
+		// a zero struct
+		u = 100
+		if err := DecodeFieldPath(bytes.NewReader(zeroValues), (*holder)(nil), []string{"V", "C", "Nonce"}, &u); err != nil || u != 0 {
+			t.Errorf("decode path of zero value got %d, %v", u, err)
+		}
+	})
+}
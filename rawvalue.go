@@ -0,0 +1,78 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"io"
+	"reflect"
+)
+
+// RawValue is an encoded value. When decoding, the encoded bytes of the value (header and body,
+// including the struct version prefix) are kept in RawValue without being decoded, and they are
+// written unchanged when encoding. It can be used to delay decoding or to forward a value
+// without knowing its type, just like json.RawMessage.
+//
+// An empty RawValue is encoded as a zero value, which will be decoded as RawValue{0x80}.
+type RawValue []byte
+
+var typeOfRawValue = reflect.TypeOf(RawValue(nil))
+
+// Serialization implements Encoder
+func (v RawValue) Serialization(w io.Writer) error {
+	if len(v) == 0 {
+		_, err := w.Write(zeroValues)
+		return err
+	}
+	_, err := w.Write(v)
+	return err
+}
+
+// Deserialization implements Decoder, io.ErrUnexpectedEOF is returned if the value is truncated
+func (v *RawValue) Deserialization(r io.Reader) (bool, error) {
+	vr, ok := r.(*defaultVR)
+	if !ok {
+		// reads exactly the bytes of the next value from r
+		vr = newValueReader(r, nil)
+	}
+	start := vr.Offset()
+	raw, err := vr.readRaw((*v)[:0])
+	if err != nil {
+		return false, truncatedError(err, start, vr.Offset())
+	}
+	*v = raw
+	return false, nil
+}
+
+// Decode decodes the raw value into obj, which must be a pointer
+func (v RawValue) Decode(obj interface{}) error {
+	return Unmarshal(v, obj)
+}
+
+// rawValueDecoder returns the Decoder of value if it's an addressable RawValue or Node, because
+// Deserialization of them has a pointer receiver, RawValue and Node themselves are not Decoders.
+func rawValueDecoder(value reflect.Value) (Decoder, bool) {
+	if !value.CanAddr() {
+		return nil, false
+	}
+	switch value.Type() {
+	case typeOfRawValue:
+		return value.Addr().Interface().(*RawValue), true
+	case typeOfNode:
+		return value.Addr().Interface().(*Node), true
+	}
+	return nil, false
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type (
+	rawPayload struct {
+		Name string
+		Data []byte
+		V    uint `rtlversion:"2"`
+	}
+
+	rawEnvelope struct {
+		Kind    uint
+		Payload RawValue
+		Extra   *RawValue
+		Tail    string
+	}
+
+	typedEnvelope struct {
+		Kind    uint
+		Payload *rawPayload
+		Extra   *RawValue
+		Tail    string
+	}
+)
+
+func TestRawValue(t *testing.T) {
+	payload := &rawPayload{Name: "payload", Data: []byte(strings.Repeat("d", 1500)), V: 7}
+	src := &typedEnvelope{Kind: 1, Payload: payload, Tail: "tail"}
+	bs, err := Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadBytes, err := Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, decode := range decodeFuncs {
+		env := new(rawEnvelope)
+		if err := decode(NewDecoder(bytes.NewReader(bs)), env); err != nil {
+			t.Fatalf("%s: decode failed: %v", name, err)
+		}
+		if env.Kind != 1 || env.Tail != "tail" {
+			t.Errorf("%s: decode got %+v", name, env)
+		}
+		if !bytes.Equal(env.Payload, payloadBytes) {
+			t.Errorf("%s: raw payload got %x, want %x", name, []byte(env.Payload), payloadBytes)
+		}
+		if env.Extra == nil || !bytes.Equal(*env.Extra, zeroValues) {
+			t.Errorf("%s: raw nil pointer got %v", name, env.Extra)
+		}
+
+		// forward without knowing the type of payload
+		forwarded, err := Marshal(env)
+		if err != nil {
+			t.Fatalf("%s: marshal raw failed: %v", name, err)
+		}
+		if !bytes.Equal(forwarded, bs) {
+			t.Errorf("%s: forwarded %x, want %x", name, forwarded, bs)
+		}
+
+		// decode later
+		got := new(rawPayload)
+		if err := env.Payload.Decode(got); err != nil {
+			t.Errorf("%s: decode raw payload failed: %v", name, err)
+		} else if !reflect.DeepEqual(got, payload) {
+			t.Errorf("%s: decode raw payload got %+v, want %+v", name, got, payload)
+		}
+	}
+
+	// top level, and reading from a reader which is not a ValueReader
+	var raw RawValue
+	if _, err := raw.Deserialization(bytes.NewReader(bs)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, bs) {
+		t.Errorf("raw value got %x, want %x", []byte(raw), bs)
+	}
+	raw = nil
+	if err := Unmarshal(bs, &raw); err != nil || !bytes.Equal(raw, bs) {
+		t.Errorf("unmarshal raw value got %x, %v", []byte(raw), err)
+	}
+
+	// empty and nil
+	for _, v := range []interface{}{RawValue(nil), (*RawValue)(nil), rawEnvelope{}} {
+		if _, err := Marshal(v); err != nil {
+			t.Errorf("marshal %#v failed: %v", v, err)
+		}
+	}
+}
+
+func TestRawValueTruncated(t *testing.T) {
+	bs, err := Marshal(&typedEnvelope{Kind: 1, Payload: &rawPayload{Name: "payload", V: 7}, Tail: "tail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []int{1, 2, len(bs) / 2, len(bs) - 1} {
+		var raw RawValue
+		if _, err := raw.Deserialization(bytes.NewReader(bs[:l])); err != io.ErrUnexpectedEOF {
+			t.Errorf("deserialization of %x got: %v", bs[:l], err)
+		}
+		forEachEngine(t, func(t *testing.T) {
+			raw = nil
+			if err := Unmarshal(bs[:l], &raw); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("unmarshal %x got: %v", bs[:l], err)
+			}
+		})
+	}
+	var raw RawValue
+	if _, err := raw.Deserialization(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("deserialization of nothing got: %v", err)
+	}
+	if err := Unmarshal(nil, &raw); err != io.EOF {
+		t.Errorf("unmarshal nothing got: %v", err)
+	}
+}
@@ -110,6 +110,11 @@ func toInt(length int, vr ValueReader, isNegative bool, value reflect.Value) err
 		return err
 	}
 	i := Numeric.BytesToInt64(buf, isNegative)
+	if optionsOf(vr).Strict {
+		if _, overflow := Numeric.BytesToInt64B(buf, isNegative); overflow || len(buf) > 8 || value.OverflowInt(i) {
+			return overflowError(buf, isNegative, value.Type())
+		}
+	}
 	value.SetInt(i)
 	return nil
 }
@@ -121,10 +126,31 @@ func toUint(length int, vr ValueReader, value reflect.Value, _ int) error {
 		return err
 	}
 	i := Numeric.BytesToUint64(buf)
+	if optionsOf(vr).Strict && (len(buf) > 8 || value.OverflowUint(i)) {
+		return overflowError(buf, false, value.Type())
+	}
 	value.SetUint(i)
 	return nil
 }
 
+// overflowError returns the error of number in buf overflows the target type
+func overflowError(buf []byte, isNegative bool, target interface{}) error {
+	sign := ""
+	if isNegative {
+		sign = "-"
+	}
+	return fmt.Errorf("%w: %s0x%x overflows %v", ErrOverflow, sign, buf, target)
+}
+
+// lengthMismatch logs the message in lenient mode, and returns an error in strict mode
+func lengthMismatch(stream interface{}, format string, args ...interface{}) error {
+	if optionsOf(stream).Strict {
+		return fmt.Errorf("%w: %s", ErrLength, fmt.Sprintf(format, args...))
+	}
+	log.Printf(format, args...)
+	return nil
+}
+
 // toFloat decode single byte header bytes to float value
 func toFloat(length int, vr ValueReader, isNegative bool, value reflect.Value) error {
 	buf, err := vr.ReadBytes(length, nil)
@@ -137,6 +163,9 @@ func toFloat(length int, vr ValueReader, isNegative bool, value reflect.Value) e
 	} else {
 		f = Numeric.BytesToFloat64(buf, isNegative)
 	}
+	if optionsOf(vr).Strict && value.OverflowFloat(f) {
+		return overflowError(buf, isNegative, value.Type())
+	}
 	value.SetFloat(f)
 	return nil
 }
@@ -182,12 +211,12 @@ func stringToArray(buf []byte, vr ValueReader, value reflect.Value, nesting int)
 			evalue := value.Index(i)
 			err := valueReader1(THSingleByte, int(buf[i]), vr, evalue, nesting+1)
 			if err != nil {
-				return err
+				return prependPath(newDecodeError(vr, etyp, THSingleByte, err), indexSegment(i))
 			}
 		}
 	}
 	if i != vl || i != l {
-		log.Printf("rtl: string to array/slice length not match, "+
+		return lengthMismatch(vr, "rtl: string to array/slice length not match, "+
 			"len(string)=%d, len(array)=%d, %d elements writed", l, vl, i)
 	}
 	return nil
@@ -197,10 +226,10 @@ func singleByteToArray0(length int, vr ValueReader, value reflect.Value, nesting
 	vl := value.Len()
 	if vl >= 1 {
 		evalue := value.Index(0)
-		return valueReader1(THSingleByte, length, vr, evalue, nesting+1)
+		err := valueReader1(THSingleByte, length, vr, evalue, nesting+1)
+		return prependPath(newDecodeError(vr, evalue.Type(), THSingleByte, err), indexSegment(0))
 	}
-	log.Printf("rtl: restore nothing for an 0 length array/slice with %s(byte:%x)", THSingleByte, length)
-	return nil
+	return lengthMismatch(vr, "rtl: restore nothing for an 0 length array/slice with %s(byte:%x)", THSingleByte, length)
 }
 
 // element could be any type which could expressed by a byte
@@ -235,43 +264,68 @@ func arrayMultiToArray0(length int, vr ValueReader, value reflect.Value, nesting
 func toArray0(length int, vr ValueReader, value reflect.Value, nesting int) error {
 	vl := value.Len()
 	i := 0
+	ecodec := codecOf(value.Type().Elem())
 	nesting++
 	for ; i < length && i < vl; i++ {
 		evalue := value.Index(i)
-		if err := valueReader0(vr, evalue, nesting); err != nil {
-			return err
+		if err := ecodec.decodeValue(vr, evalue, nesting); err != nil {
+			return prependPath(err, indexSegment(i))
 		}
 	}
 	if i != vl || i != length {
-		log.Printf("rtl: string to array/slice length not match, "+
-			"len(string)=%d, len(array)=%d, %d elements writed", length, vl, i)
+		if err := lengthMismatch(vr, "rtl: string to array/slice length not match, "+
+			"len(string)=%d, len(array)=%d, %d elements writed", length, vl, i); err != nil {
+			return err
+		}
+		// skip the elements could not be put into the array
+		for ; i < length; i++ {
+			if _, err := vr.Skip(); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
 func singleByteToSlice0(length int, vr ValueReader, value reflect.Value, nesting int) error {
-	checkSlice0(1, value)
+	if err := checkSlice0(vr, 1, value); err != nil {
+		return err
+	}
 	return singleByteToArray0(length, vr, value, nesting)
 }
 
 func stringSingleToSlice0(length int, vr ValueReader, value reflect.Value, nesting int) error {
-	checkSlice0(length, value)
-	return stringSingleToArray0(length, vr, value, nesting)
+	buf, err := vr.ReadBytes(length, nil)
+	if err != nil {
+		return err
+	}
+	return bytesToSlice0(buf, vr, value, nesting)
 }
 
 func stringMultiToSlice0(length int, vr ValueReader, value reflect.Value, nesting int) error {
-	l, err := vr.ReadMultiLength(length)
+	buf, err := vr.ReadMultiLengthBytes(length, nil)
 	if err != nil {
 		return err
 	}
-	checkSlice0(int(l), value)
-	return stringSingleToArray0(int(l), vr, value, nesting)
+	return bytesToSlice0(buf, vr, value, nesting)
+}
+
+// bytesToSlice0 decodes the bytes of a string into the slice value, the slice is created after
+// the bytes have been read
+func bytesToSlice0(buf []byte, vr ValueReader, value reflect.Value, nesting int) error {
+	if optionsOf(vr).NoCopy && value.Type().Elem() == typeOfByte {
+		value.SetBytes(buf)
+		return nil
+	}
+	if err := checkSlice0(vr, len(buf), value); err != nil {
+		return err
+	}
+	return stringToArray(buf, vr, value, nesting)
 }
 
 func arraySingleToSlice0(length int, vr ValueReader, value reflect.Value, nesting int) error {
-	checkSlice0(length, value)
-	return arraySingleToArray0(length, vr, value, nesting)
+	return toSlice0(length, vr, value, nesting)
 }
 
 func arrayMultiToSlice0(length int, vr ValueReader, value reflect.Value, nesting int) error {
@@ -279,53 +333,140 @@ func arrayMultiToSlice0(length int, vr ValueReader, value reflect.Value, nesting
 	if err != nil {
 		return err
 	}
-	checkSlice0(int(l), value)
-	return arraySingleToArray0(int(l), vr, value, nesting)
+	return toSlice0(int(l), vr, value, nesting)
 }
 
-func checkSlice0(length int, value reflect.Value) {
+// toSlice0 decodes length elements into the slice value, which grows while decoding
+func toSlice0(length int, vr ValueReader, value reflect.Value, nesting int) error {
+	if err := allocSlice(vr, length, value); err != nil {
+		return err
+	}
+	ecodec := codecOf(value.Type().Elem())
+	nesting++
+	for i := 0; i < length; i++ {
+		if err := growSlice(vr, value, i, length); err != nil {
+			return err
+		}
+		if err := ecodec.decodeValue(vr, value.Index(i), nesting); err != nil {
+			return prependPath(err, indexSegment(i))
+		}
+	}
+	return nil
+}
+
+// toInterfaces0 decodes length items into a []interface{} value, and a run of zero values
+// (THZeros) is expanded to nil elements, as the values of interface{} could be a struct.
+func toInterfaces0(length int, vr ValueReader, value reflect.Value, nesting int) error {
+	if err := allocSlice(vr, length, value); err != nil {
+		return err
+	}
+	ecodec := codecOf(typeOfInterface)
+	nesting++
+	idx := 0
+	for i := 0; i < length; i++ {
+		// the length of the slice if there's no more run of zero values
+		total := idx + length - i
+		run, err := ReadZeros(vr)
+		if err != nil {
+			return prependPath(err, indexSegment(idx))
+		}
+		if run > 0 {
+			total += run - 1
+			if err := optionsOf(vr).checkLength(total); err != nil {
+				return err
+			}
+			if err := growSlice(vr, value, idx+run-1, total); err != nil {
+				return err
+			}
+			for ; run > 0; run-- {
+				value.Index(idx).Set(reflect.Zero(typeOfInterface))
+				idx++
+			}
+			continue
+		}
+		if err := growSlice(vr, value, idx, total); err != nil {
+			return err
+		}
+		if err := ecodec.decodeValue(vr, value.Index(idx), nesting); err != nil {
+			return prependPath(err, indexSegment(idx))
+		}
+		idx++
+	}
+	return nil
+}
+
+// checkSlice0 sets the length of the slice value, the bytes (length) of the elements have been
+// read from the stream.
+func checkSlice0(stream interface{}, length int, value reflect.Value) error {
+	if err := optionsOf(stream).checkLength(length); err != nil {
+		return err
+	}
 	if length > value.Cap() {
-		newv := reflect.MakeSlice(value.Type(), length, length)
-		value.Set(newv)
+		return makeSlice(stream, value, length, length)
 	}
 	if length != value.Len() {
 		value.SetLen(length)
 	}
+	return nil
 }
 
-func arraySingleToMap0(length int, vr ValueReader, value reflect.Value, nesting int) error {
-	return toMap0(length, vr, value, nesting)
+func arraySingleToMap0(c *typeCodec, length int, vr ValueReader, value reflect.Value, nesting int) error {
+	return toMap0(c, length, vr, value, nesting)
 }
 
-func arrayMultiToMap0(length int, vr ValueReader, value reflect.Value, nesting int) error {
+func arrayMultiToMap0(c *typeCodec, length int, vr ValueReader, value reflect.Value, nesting int) error {
 	l, err := vr.ReadMultiLength(length)
 	if err != nil {
 		return err
 	}
-	return toMap0(int(l), vr, value, nesting)
+	return toMap0(c, int(l), vr, value, nesting)
 }
 
-func toMap0(length int, vr ValueReader, value reflect.Value, nesting int) error {
+func toMap0(c *typeCodec, length int, vr ValueReader, value reflect.Value, nesting int) error {
 	if length%2 != 0 {
 		return fmt.Errorf("rtl: length of the array must be even when decode to a map, but length=%d", length)
 	}
-	typ := value.Type()
-
-	if value.IsNil() {
-		value.Set(reflect.MakeMapWithSize(typ, length/2))
+	if err := makeMap(vr, value, length/2); err != nil {
+		return err
 	}
+	typ := value.Type()
 
 	ktyp := typ.Key()
 	vtyp := typ.Elem()
+	kcodec, vcodec := c.child(0), c.child(1)
 
+	opts := optionsOf(vr)
+	// the raw bytes of the keys are checked in canonical mode
+	recorder, _ := vr.(*defaultVR)
+	if !opts.Canonical {
+		recorder = nil
+	}
+	var prevKey []byte
 	nesting++
 	for i := 0; i < length; i += 2 {
 		kvalue := reflect.New(ktyp).Elem()
 		vvalue := reflect.New(vtyp).Elem()
-		if err := valueReader0(vr, kvalue, nesting); err != nil {
-			return err
+		mark := 0
+		if recorder != nil {
+			mark = recorder.record(nil)
+		}
+		err := kcodec.decodeValue(vr, kvalue, nesting)
+		var raw []byte
+		if recorder != nil {
+			raw = recorder.recorded(mark)
+		}
+		if err != nil {
+			return prependPath(err, fmt.Sprintf("[key %d]", i/2))
 		}
-		if err := valueReader0(vr, vvalue, nesting); err != nil {
+		if opts.Canonical {
+			if prevKey, err = checkCanonicalKey(opts, prevKey, kvalue, raw); err != nil {
+				return err
+			}
+		}
+		if err := vcodec.decodeValue(vr, vvalue, nesting); err != nil {
+			return prependPath(err, keySegment(kvalue))
+		}
+		if err := allocEntry(vr, value); err != nil {
 			return err
 		}
 		value.SetMapIndex(kvalue, vvalue)
@@ -334,56 +475,102 @@ func toMap0(length int, vr ValueReader, value reflect.Value, nesting int) error
 	return nil
 }
 
-func arraySingleToStruct0(length int, vr ValueReader, value reflect.Value, nesting int) error {
-	return toStruct0(length, vr, value, nesting)
+func arraySingleToStruct0(c *typeCodec, version int, length int, vr ValueReader, value reflect.Value, nesting int) error {
+	return toStruct0(c, version, length, vr, value, nesting)
 }
 
-func arrayMultiToStruct0(length int, vr ValueReader, value reflect.Value, nesting int) error {
+func arrayMultiToStruct0(c *typeCodec, version int, length int, vr ValueReader, value reflect.Value, nesting int) error {
 	l, err := vr.ReadMultiLength(length)
 	if err != nil {
 		return err
 	}
-	return toStruct0(int(l), vr, value, nesting)
+	return toStruct0(c, version, int(l), vr, value, nesting)
 }
 
-func toStruct0(length int, vr ValueReader, value reflect.Value, nesting int) error {
-	typ := value.Type()
-	_, fnames := structFields(typ)
+// readVersion reads the struct version if th is a version header, and returns the version with the
+// header of the value following it. version is noVersion if th is not a version header.
+func readVersion(th TypeHeader, length int, vr ValueReader) (version int, nth TypeHeader, nlength int, err error) {
+	if !th.IsVersion() {
+		return noVersion, th, length, nil
+	}
+	if th == THVersion {
+		version = length
+	} else {
+		buf, err := vr.ReadBytes(length, nil)
+		if err != nil {
+			return noVersion, th, length, err
+		}
+		version = versionNumber(buf)
+	}
+	if th, length, err = vr.ReadHeader(); err != nil {
+		return noVersion, th, length, err
+	}
+	if err = checkVersionFollowed(th); err != nil {
+		return noVersion, th, length, err
+	}
+	return version, th, length, nil
+}
+
+// toStruct0 decode length elements in vr into the struct value. Only the fields existing in the
+// version of the stream will be decoded, others will be set to zero value.
+func toStruct0(c *typeCodec, version int, length int, vr ValueReader, value reflect.Value, nesting int) error {
+	_, fields := structFields(c.typ)
+	fnames := fieldsOfVersion(fields, version)
 	lth := len(fnames)
-	// if lth > length {
-	// 	lth = length
-	// }
 
 	nesting++
-	nextOrder := 0 // 下一个field对应的order
+	zeroField := func(idx int) error {
+		fvalue := value.Field(fields[idx].index)
+		if err := c.child(idx).decodeHeader(THZeroValue, 0, vr, fvalue, nesting); err != nil {
+			err = newDecodeError(vr, fvalue.Type(), THZeroValue, err)
+			return prependPath(err, "."+fields[idx].name)
+		}
+		return nil
+	}
+	i := 0         // 数据下标
+	order := 0     // 数据对应的order, 一串零值(THZeros)对应多个order
 	nextIndex := 0 // 下一个filed的对应下标
-	if nextIndex < lth {
-		nextOrder = fnames[nextIndex].order
-		for i := 0; i < length; i++ {
-			if i == nextOrder {
-				fvalue := value.Field(fnames[nextIndex].index)
-				if err := valueReader0(vr, fvalue, nesting); err != nil {
-					return err
-				}
-				nextIndex++
-				if nextIndex >= lth {
-					break
-				}
-				nextOrder = fnames[nextIndex].order
-			} else if i < nextOrder {
-				if _, err := vr.Skip(); err != nil {
+	for ; i < length && nextIndex < lth; i++ {
+		run, err := ReadZeros(vr)
+		if err != nil {
+			return err
+		}
+		if run > 0 {
+			// 一串零值所覆盖的字段置空
+			order += run
+			for ; nextIndex < lth && fnames[nextIndex].order < order; nextIndex++ {
+				if err := zeroField(nextIndex); err != nil {
 					return err
 				}
-			} else {
-				return fmt.Errorf("illegal status found: dataIndex:%d nextIndex:%d %s",
-					i, nextIndex, fnames[nextIndex])
 			}
+			continue
+		}
+		nextOrder := fnames[nextIndex].order // 下一个field对应的order
+		if order == nextOrder {
+			fvalue := value.Field(fnames[nextIndex].index)
+			if err := c.child(nextIndex).decodeValue(vr, fvalue, nesting); err != nil {
+				return prependPath(err, "."+fnames[nextIndex].name)
+			}
+			nextIndex++
+		} else if order < nextOrder {
+			if _, err := vr.Skip(); err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("illegal status found: dataIndex:%d nextIndex:%d %s",
+				order, nextIndex, fnames[nextIndex])
+		}
+		order++
+	}
+	// 跳过对象中不存在的字段数据
+	for ; i < length; i++ {
+		if _, err := vr.Skip(); err != nil {
+			return err
 		}
 	}
 	// 将后面未包含在对象中的字段置空
-	for ; nextIndex < lth; nextIndex++ {
-		fvalue := value.Field(fnames[nextIndex].index)
-		if err := valueReader1(THZeroValue, 0, vr, fvalue, nesting); err != nil {
+	for ; nextIndex < len(fields); nextIndex++ {
+		if err := zeroField(nextIndex); err != nil {
 			return err
 		}
 	}
@@ -537,130 +724,97 @@ func valueReader(r ValueReader, value reflect.Value) error {
 }
 
 func valueReader0(vr ValueReader, value reflect.Value, nesting int) error {
-	// decode itself if the value implements encoding.Decoder interface
-	isDecoder, err := checkTypeOfDecoder(vr, value)
-	if isDecoder || err != nil {
-		return err
-	}
-
-	// if not an encoding.Decoder implementation, use default decoder
-	th, length, err := vr.ReadHeader()
-	if err != nil {
-		// if EndOfFile(err) {
-		// 	return nil
-		// }
-		return err
-	}
-
-	return valueReader1(th, length, vr, value, nesting)
+	return codecOf(value.Type()).decodeValue(vr, value, nesting)
 }
 
 func valueReader1(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
-	if nesting > MaxNested {
-		return ErrNestingOverflow
-	}
-
-	typ := value.Type()
+	return codecOf(value.Type()).decodeHeader(th, length, vr, value, nesting)
+}
 
-	if matched, err := checkPriorStructsReader(th, length, vr, value, nesting); err != nil {
-		return err
-	} else if matched {
-		return err
+func toArrays(c *typeCodec, th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+	switch th {
+	case THSingleByte:
+		return singleByteToArray0(length, vr, value, nesting)
+	case THZeroValue:
+		value.Set(reflect.Zero(c.typ))
+		return nil
+	case THStringSingle:
+		return stringSingleToArray0(length, vr, value, nesting)
+	case THStringMulti:
+		return stringMultiToArray0(length, vr, value, nesting)
+	case THArraySingle:
+		return arraySingleToArray0(length, vr, value, nesting)
+	case THArrayMulti:
+		return arrayMultiToArray0(length, vr, value, nesting)
 	}
+	return fmt.Errorf("rtl: unsupported type2 %v (kind: %s, headerType: %s) for decoding", c.typ, c.typ.Kind(), th)
+}
 
-	kind := value.Kind()
-	switch kind {
-	case reflect.Array:
-		switch th {
-		case THSingleByte:
-			return singleByteToArray0(length, vr, value, nesting)
-		case THZeroValue:
-			value.Set(reflect.Zero(typ))
-			return nil
-		case THStringSingle:
-			return stringSingleToArray0(length, vr, value, nesting)
-		case THStringMulti:
-			return stringMultiToArray0(length, vr, value, nesting)
-		case THArraySingle:
-			return arraySingleToArray0(length, vr, value, nesting)
-		case THArrayMulti:
-			return arrayMultiToArray0(length, vr, value, nesting)
-		}
-	case reflect.Slice:
-		switch th {
-		case THSingleByte:
-			return singleByteToSlice0(length, vr, value, nesting)
-		case THZeroValue:
-			value.Set(reflect.Zero(typ))
-			return nil
-		case THEmpty:
-			if !value.CanSet() {
-				return fmt.Errorf("rtl: slice can not set to empty")
-			}
-			nslice := reflect.MakeSlice(typ, 0, 0)
-			value.Set(nslice)
-			return nil
-		case THStringSingle:
-			return stringSingleToSlice0(length, vr, value, nesting)
-		case THStringMulti:
-			return stringMultiToSlice0(length, vr, value, nesting)
-		case THArraySingle:
-			return arraySingleToSlice0(length, vr, value, nesting)
-		case THArrayMulti:
-			return arrayMultiToSlice0(length, vr, value, nesting)
-		}
-	case reflect.Map:
-		switch th {
-		case THZeroValue:
-			value.Set(reflect.Zero(typ))
-			return nil
-		case THEmpty:
-			if !value.CanSet() {
-				return fmt.Errorf("rtl: map can not set")
-			}
-			nmap := reflect.MakeMapWithSize(typ, 0)
-			value.Set(nmap)
-			return nil
-		case THArraySingle:
-			return arraySingleToMap0(length, vr, value, nesting)
-		case THArrayMulti:
-			return arrayMultiToMap0(length, vr, value, nesting)
+func toSlices(c *typeCodec, th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+	switch th {
+	case THSingleByte:
+		return singleByteToSlice0(length, vr, value, nesting)
+	case THZeroValue:
+		value.Set(reflect.Zero(c.typ))
+		return nil
+	case THEmpty:
+		if !value.CanSet() {
+			return fmt.Errorf("rtl: slice can not set to empty")
 		}
-	case reflect.Struct:
-		return toStructs(typ, kind, th, length, vr, value, nesting)
-	case reflect.Ptr:
-		// pointer
-		return toPointers(typ, kind, th, length, vr, value, nesting)
-	case reflect.Interface:
-		// as interface{} type
-		return toInterfaces(typ, kind, th, length, vr, value, nesting)
-	default:
-		funcMap, ok := primKindTypeHeaderMap[kind]
-		if ok {
-			return typedReader0(th, length, vr, value, nesting, funcMap)
-		} else {
-			return fmt.Errorf("rtl: unsupported type1 %v (kind: %s, headerType: %s) for decoding", typ, kind, th)
+		nslice := reflect.MakeSlice(c.typ, 0, 0)
+		value.Set(nslice)
+		return nil
+	case THStringSingle:
+		return stringSingleToSlice0(length, vr, value, nesting)
+	case THStringMulti:
+		return stringMultiToSlice0(length, vr, value, nesting)
+	case THArraySingle:
+		return arraySingleToSlice0(length, vr, value, nesting)
+	case THArrayMulti:
+		return arrayMultiToSlice0(length, vr, value, nesting)
+	}
+	return fmt.Errorf("rtl: unsupported type2 %v (kind: %s, headerType: %s) for decoding", c.typ, c.typ.Kind(), th)
+}
+
+func toMaps(c *typeCodec, th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+	switch th {
+	case THZeroValue:
+		value.Set(reflect.Zero(c.typ))
+		return nil
+	case THEmpty:
+		if !value.CanSet() {
+			return fmt.Errorf("rtl: map can not set")
 		}
+		nmap := reflect.MakeMapWithSize(c.typ, 0)
+		value.Set(nmap)
+		return nil
+	case THArraySingle:
+		return arraySingleToMap0(c, length, vr, value, nesting)
+	case THArrayMulti:
+		return arrayMultiToMap0(c, length, vr, value, nesting)
 	}
-	return fmt.Errorf("rtl: unsupported type2 %v (kind: %s, headerType: %s) for decoding", typ, kind, th)
+	return fmt.Errorf("rtl: unsupported type2 %v (kind: %s, headerType: %s) for decoding", c.typ, c.typ.Kind(), th)
 }
 
-func toStructs(typ reflect.Type, kind reflect.Kind, th TypeHeader, length int, vr ValueReader,
-	value reflect.Value, nesting int) error {
+func toStructs(c *typeCodec, th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+	version, th, length, err := readVersion(th, length, vr)
+	if err != nil {
+		return err
+	}
 	switch th {
 	case THZeroValue:
-		value.Set(reflect.Zero(typ))
+		value.Set(reflect.Zero(c.typ))
 		return nil
 	case THArraySingle:
-		return arraySingleToStruct0(length, vr, value, nesting)
+		return arraySingleToStruct0(c, version, length, vr, value, nesting)
 	case THArrayMulti:
-		return arrayMultiToStruct0(length, vr, value, nesting)
+		return arrayMultiToStruct0(c, version, length, vr, value, nesting)
 	}
-	return fmt.Errorf("rtl: unsupported type3 %v (kind: %s, headerType: %s) for decoding", typ, kind, th)
+	return fmt.Errorf("rtl: unsupported type3 %v (kind: %s, headerType: %s) for decoding", c.typ, c.typ.Kind(), th)
 }
 
-func toPointers(typ reflect.Type, _ reflect.Kind, th TypeHeader, length int, vr ValueReader,
-	value reflect.Value, nesting int) error {
+func toPointers(c *typeCodec, th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+	typ := c.typ
 	etyp := typ.Elem()
 	if th == THZeroValue {
 		// nil pointer
@@ -684,18 +838,27 @@ func toPointers(typ reflect.Type, _ reflect.Kind, th TypeHeader, length int, vr
 			}
 			evalue = reflect.New(etyp)
 		}
-		err := valueReader1(th, length, vr, evalue.Elem(), nesting)
+		err := c.child(0).decodeHeader(th, length, vr, evalue.Elem(), nesting)
 		if err == nil && value.IsNil() {
 			value.Set(evalue)
 		}
-		return err
+		return newDecodeError(vr, etyp, th, err)
 	}
 }
 
 func toInterfaces(typ reflect.Type, kind reflect.Kind, th TypeHeader, length int, vr ValueReader,
 	value reflect.Value, nesting int) error {
+	if optionsOf(vr).TypedInterfaces {
+		return toTypedInterface(th, length, vr, value, nesting)
+	}
 	if value.Type().NumMethod() != 0 {
-		return fmt.Errorf("rtl: unsupported type5 %v (kind: %s, headerType: %s) for decoding", typ, kind, th)
+		return fmt.Errorf("rtl: unsupported type5 %v (kind: %s, headerType: %s) for decoding, "+
+			"decode it in typed interfaces mode", typ, kind, th)
+	}
+	// struct version is meaningless to interface{}
+	_, th, length, err := readVersion(th, length, vr)
+	if err != nil {
+		return err
 	}
 	switch th {
 	case THSingleByte:
@@ -712,7 +875,7 @@ func toInterfaces(typ reflect.Type, kind reflect.Kind, th TypeHeader, length int
 	case THPosNumSingle:
 		b, err := vr.ReadBytes(length, nil)
 		if err != nil {
-			return nil
+			return err
 		}
 		nv := reflect.New(typeOfUint64).Elem()
 		nv.SetUint(Numeric.BytesToUint64(b))
@@ -721,7 +884,7 @@ func toInterfaces(typ reflect.Type, kind reflect.Kind, th TypeHeader, length int
 	case THNegNumSingle:
 		b, err := vr.ReadBytes(length, nil)
 		if err != nil {
-			return nil
+			return err
 		}
 		nv := reflect.New(typeOfInt64).Elem()
 		nv.SetInt(Numeric.BytesToInt64(b, true))
@@ -730,7 +893,7 @@ func toInterfaces(typ reflect.Type, kind reflect.Kind, th TypeHeader, length int
 	case THStringSingle:
 		b, err := vr.ReadBytes(length, nil)
 		if err != nil {
-			return nil
+			return err
 		}
 		nv := reflect.New(typeOfString).Elem()
 		nv.SetString(string(b))
@@ -739,24 +902,24 @@ func toInterfaces(typ reflect.Type, kind reflect.Kind, th TypeHeader, length int
 	case THStringMulti:
 		b, err := vr.ReadMultiLengthBytes(length, nil)
 		if err != nil {
-			return nil
+			return err
 		}
 		nv := reflect.New(typeOfString).Elem()
 		nv.SetString(string(b))
 		value.Set(nv)
 		return nil
-	case THArraySingle:
-		slice := reflect.MakeSlice(typeOfInterfaceSlice, length, length)
-		value.Set(slice)
-		return arraySingleToArray0(length, vr, slice, nesting)
-	case THArrayMulti:
-		l, err := vr.ReadMultiLength(length)
-		if err != nil {
-			return err
+	case THArraySingle, THArrayMulti:
+		if th == THArrayMulti {
+			l, err := vr.ReadMultiLength(length)
+			if err != nil {
+				return err
+			}
+			length = int(l)
 		}
-		slice := reflect.MakeSlice(typeOfInterfaceSlice, int(l), int(l))
+		slice := reflect.New(typeOfInterfaceSlice).Elem()
+		err := toInterfaces0(length, vr, slice, nesting)
 		value.Set(slice)
-		return arrayMultiToArray0(int(l), vr, slice, nesting)
+		return err
 	}
 	return fmt.Errorf("rtl: unsupported type6 %v (kind: %s, headerType: %s) for decoding", typ, kind, th)
 }
@@ -69,45 +69,44 @@ var (
 	}
 )
 
-func checkPriorStructsReader(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) (matched bool, err error) {
-	typ := value.Type()
+// priorStructReader returns the reader of the prior type which typ (or the pointer of typ) is
+// assignable or convertible to, or nil if there's no such prior type.
+func priorStructReader(typ reflect.Type) headerValueReader {
 	for _, prior := range _readerPriorStructOrder {
-		if typ.AssignableTo(prior) || typ.AssignableTo(reflect.PtrTo(prior)) {
-			readers, exist := _priorStructReaders[prior]
-			if exist {
-				fn := getFunc(typ, readers, th)
-				if typ.AssignableTo(prior) {
-					err = fn(length, vr, value.Addr(), nesting)
-				} else {
-					err = fn(length, vr, value, nesting)
-				}
-				return true, err
+		readers, exist := _priorStructReaders[prior]
+		if !exist {
+			continue
+		}
+		priorPtr := reflect.PtrTo(prior)
+		switch {
+		case typ.AssignableTo(prior):
+			return func(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+				return getFunc(typ, readers, th)(length, vr, value.Addr(), nesting)
+			}
+		case typ.AssignableTo(priorPtr):
+			return func(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+				return getFunc(typ, readers, th)(length, vr, value, nesting)
 			}
-		} else if Convertible(typ, prior) {
-			readers, exist := _priorStructReaders[prior]
-			if exist {
+		case Convertible(typ, prior):
+			return func(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
 				fn := getFunc(prior, readers, th)
-				vptr := value.Addr()
-				priorVal := vptr.Convert(reflect.PtrTo(prior))
-				return true, fn(length, vr, priorVal, nesting)
+				return fn(length, vr, value.Addr().Convert(priorPtr), nesting)
 			}
-		} else if ConvertiblePtr(typ, reflect.PtrTo(prior)) {
-			readers, exist := _priorStructReaders[prior]
-			if exist {
+		case ConvertiblePtr(typ, priorPtr):
+			return func(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
 				if th == THZeroValue {
 					value.Set(reflect.Zero(typ))
-					return true, nil
+					return nil
 				}
 				fn := getFunc(prior, readers, th)
 				if value.IsNil() {
 					value.Set(reflect.New(typ.Elem()))
 				}
-				priorVal := value.Convert(reflect.PtrTo(prior))
-				return true, fn(length, vr, priorVal, nesting)
+				return fn(length, vr, value.Convert(priorPtr), nesting)
 			}
 		}
 	}
-	return false, nil
+	return nil
 }
 
 func bigIntReader0(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
@@ -24,6 +24,10 @@ import (
 )
 
 func TestPrimary(t *testing.T) {
+	forEachEngine(t, testPrimary)
+}
+
+func testPrimary(t *testing.T) {
 	var a, b int
 	a = 142857
 	if bs, err := Marshal(a); err == nil {
@@ -59,6 +63,10 @@ func TestPrimary(t *testing.T) {
 }
 
 func TestBasic(t *testing.T) {
+	forEachEngine(t, testBasic)
+}
+
+func testBasic(t *testing.T) {
 	type (
 		embeded struct {
 			A uint
@@ -127,6 +135,10 @@ func TestBasic(t *testing.T) {
 }
 
 func TestTypes(t *testing.T) {
+	forEachEngine(t, testTypes)
+}
+
+func testTypes(t *testing.T) {
 	type (
 		source struct {
 			A []byte
@@ -151,6 +163,10 @@ func TestTypes(t *testing.T) {
 }
 
 func TestOrder(t *testing.T) {
+	forEachEngine(t, testOrder)
+}
+
+func testOrder(t *testing.T) {
 	type (
 		source struct {
 			A uint   // `rtlorder:"0"`
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// typeRegistry maps the ids to the concrete types of interface values, used in the typed
+// interfaces mode (see Options.TypedInterfaces)
+type typeRegistry struct {
+	lock  sync.RWMutex
+	types map[uint64]reflect.Type
+	ids   map[reflect.Type]uint64
+}
+
+var registry = &typeRegistry{
+	types: make(map[uint64]reflect.Type),
+	ids:   make(map[reflect.Type]uint64),
+}
+
+// RegisterType registers the type of sample with id, so that the values of the type held by
+// interfaces could be encoded and decoded in the typed interfaces mode. Register a pointer
+// (e.g. &Transfer{}) if the pointer type is the one implementing the interface. It panics if
+// the id or the type has already been registered with another type or id, as same as
+// gob.Register, it should be called in init functions.
+func RegisterType(id uint64, sample interface{}) {
+	if sample == nil {
+		panic("rtl: register nil type")
+	}
+	typ := reflect.TypeOf(sample)
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if t, ok := registry.types[id]; ok && t != typ {
+		panic(fmt.Errorf("rtl: registering duplicate types for id %d: %v != %v", id, t, typ))
+	}
+	if i, ok := registry.ids[typ]; ok && i != id {
+		panic(fmt.Errorf("rtl: registering duplicate ids for type %v: %d != %d", typ, i, id))
+	}
+	registry.types[id] = typ
+	registry.ids[typ] = id
+}
+
+// RegisteredType returns the type registered with id by RegisterType
+func RegisteredType(id uint64) (reflect.Type, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	typ, ok := registry.types[id]
+	return typ, ok
+}
+
+func registeredID(typ reflect.Type) (uint64, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	id, ok := registry.ids[typ]
+	return id, ok
+}
+
+// typedValue returns a new value of the type registered with id, which is assignable to the
+// interface type ityp
+func typedValue(id uint64, ityp reflect.Type) (reflect.Value, error) {
+	typ, ok := RegisteredType(id)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%w: id %d", ErrUnregisteredType, id)
+	}
+	if !typ.AssignableTo(ityp) {
+		return reflect.Value{}, fmt.Errorf("rtl: registered type %v (id: %d) does not implement %v", typ, id, ityp)
+	}
+	return reflect.New(typ).Elem(), nil
+}
+
+// typedInterfaceWriter writes the dynamic value v of an interface as [id, value]
+func typedInterfaceWriter(w io.Writer, v reflect.Value, nesting int) (int, error) {
+	id, ok := registeredID(v.Type())
+	if !ok {
+		return 0, fmt.Errorf("%w: %v", ErrUnregisteredType, v.Type())
+	}
+	if err := optionsOf(w).checkNesting(nesting + 1); err != nil {
+		return 0, err
+	}
+	h, err := HeadMaker.array(2)
+	if err != nil {
+		return 0, err
+	}
+	l, err := w.Write(h)
+	if err != nil {
+		return l, err
+	}
+	n, err := smallNumberWriter(w, false, id)
+	l += n
+	if err != nil {
+		return l, err
+	}
+	n, err = codecOf(v.Type()).encodeValue(w, v, nesting+1)
+	return l + n, err
+}
+
+// toTypedInterface reads [id, value] of the header th into the interface value
+func toTypedInterface(th TypeHeader, length int, vr ValueReader, value reflect.Value, nesting int) error {
+	switch th {
+	case THZeroValue:
+		value.Set(reflect.Zero(value.Type()))
+		return nil
+	case THArraySingle:
+		if length == 2 {
+			break
+		}
+		fallthrough
+	default:
+		return fmt.Errorf("rtl: typed interface value expected, but %s (length:%d) found", th, length)
+	}
+	id, err := DecodeUint(vr, 64)
+	if err != nil {
+		return err
+	}
+	nv, err := typedValue(id, value.Type())
+	if err != nil {
+		return err
+	}
+	if err := codecOf(nv.Type()).decodeValue(vr, nv, nesting+1); err != nil {
+		return err
+	}
+	value.Set(nv)
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type (
+	registryTx interface {
+		Hash() string
+	}
+
+	registryTransfer struct {
+		From, To string
+		Amount   uint64
+	}
+
+	registryCall struct {
+		Contract string
+		Input    []byte
+		Inner    registryTx
+	}
+
+	registryBlock struct {
+		Tx  registryTx
+		Txs []registryTx
+		Any interface{}
+		Nil registryTx
+	}
+
+	registryUnknown struct{}
+)
+
+func (t registryTransfer) Hash() string { return "transfer:" + t.From + t.To }
+func (c *registryCall) Hash() string    { return "call:" + c.Contract }
+func (registryUnknown) Hash() string    { return "unknown" }
+
+func init() {
+	RegisterType(0x7001, registryTransfer{})
+	RegisterType(0x7002, &registryCall{})
+	RegisterType(0x7003, "")
+}
+
+func TestRegistryTypedInterfaces(t *testing.T) {
+	block := &registryBlock{
+		Tx: &registryCall{Contract: "c", Input: []byte{1, 2}, Inner: registryTransfer{From: "a", To: "b", Amount: 3}},
+		Txs: []registryTx{
+			registryTransfer{From: "x", To: "y", Amount: 1000},
+			&registryCall{Contract: "d"},
+			nil,
+		},
+		Any: "any string",
+	}
+	buf := new(bytes.Buffer)
+	if err := NewEncoder(buf, WithTypedInterfaces()).Encode(block); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	bs := buf.Bytes()
+
+	for name, decode := range decodeFuncs {
+		got := new(registryBlock)
+		if err := decode(NewDecoder(bytes.NewReader(bs), WithTypedInterfaces()), got); err != nil {
+			t.Errorf("%s: decode failed: %v", name, err)
+		} else if !reflect.DeepEqual(got, block) {
+			t.Errorf("%s: decode got %+v, want %+v", name, got, block)
+		}
+
+		// interfaces with methods could only be decoded in typed interfaces mode
+		got = new(registryBlock)
+		if err := decode(NewDecoder(bytes.NewReader(bs)), got); err == nil {
+			t.Errorf("%s: decode without typed interfaces mode should fail", name)
+		}
+	}
+}
+
+func TestRegistryErrors(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := NewEncoder(buf, WithTypedInterfaces()).Encode(&registryBlock{Tx: registryUnknown{}}); !errors.Is(err, ErrUnregisteredType) {
+		t.Errorf("encode unregistered type should fail with %v, but got: %v", ErrUnregisteredType, err)
+	}
+
+	// [id, value] with unknown id, or with a type not implementing the interface
+	unknown, _ := Marshal([]interface{}{[]interface{}{uint64(0x7fff), "value"}})
+	notImpl, _ := Marshal([]interface{}{[]interface{}{uint64(0x7003), "value"}})
+	for name, decode := range decodeFuncs {
+		var tx struct{ Tx registryTx }
+		if err := decode(NewDecoder(bytes.NewReader(unknown), WithTypedInterfaces()), &tx); !errors.Is(err, ErrUnregisteredType) {
+			t.Errorf("%s: decode unknown id should fail with %v, but got: %v", name, ErrUnregisteredType, err)
+		}
+		if err := decode(NewDecoder(bytes.NewReader(notImpl), WithTypedInterfaces()), &tx); err == nil {
+			t.Errorf("%s: decode type not implementing the interface should fail", name)
+		}
+		var any struct{ Any interface{} }
+		if err := decode(NewDecoder(bytes.NewReader(notImpl), WithTypedInterfaces()), &any); err != nil || any.Any != "value" {
+			t.Errorf("%s: decode to interface{} got %v, %v", name, any.Any, err)
+		}
+	}
+
+	for _, register := range []func(){
+		func() { RegisterType(0x7001, registryCall{}) },
+		func() { RegisterType(0x7fff, registryTransfer{}) },
+		func() { RegisterType(0x7fff, nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("duplicated registering should panic")
+				}
+			}()
+			register()
+		}()
+	}
+	RegisterType(0x7001, registryTransfer{})
+}
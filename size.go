@@ -0,0 +1,344 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"reflect"
+)
+
+// sizeFunc returns the number of bytes of value encoded at the nesting level with opts, by the
+// same rules as the encodeFunc of the type, without writing anything.
+type sizeFunc func(opts *Options, value reflect.Value, nesting int) (int, error)
+
+// Size returns the number of bytes of the encoding of v with the options, as same as Encode
+// writes. Only the values of Encoder types (and some prior types, such as big.Rat and
+// time.Time) are encoded to be counted.
+func Size(v interface{}, opts ...Option) (int, error) {
+	value := reflect.ValueOf(v)
+	if !value.IsValid() {
+		return 0, nil
+	}
+	return codecOf(value.Type()).sizeValue(newOptions(opts...), value, 0)
+}
+
+// sizeValue returns the encoded size of value which type is c.typ
+func (c *typeCodec) sizeValue(opts *Options, value reflect.Value, nesting int) (int, error) {
+	if err := opts.checkNesting(nesting); err != nil {
+		return 0, err
+	}
+	return c.size(opts, value, nesting)
+}
+
+// encodedSize encodes value to count the bytes, for the types encoded by themselves
+func (c *typeCodec) encodedSize(opts *Options, value reflect.Value, nesting int) (int, error) {
+	counter := new(sizeWriter)
+	_, err := c.encode(&optionsWriter{Writer: counter, opts: opts}, value, nesting)
+	return counter.n, err
+}
+
+// sizeWriter counts the bytes written to it and discards them
+type sizeWriter struct {
+	n int
+}
+
+func (w *sizeWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
+
+func (c *typeCodec) compileSizer() sizeFunc {
+	typ := c.typ
+	if typ.Implements(TypeOfEncoder) {
+		return c.encodedSize
+	}
+
+	if priorStructWriter(typ) != nil {
+		switch {
+		case typ.AssignableTo(typeOfBigIntPtr) || ConvertibleTo(typ, typeOfBigIntPtr):
+			return func(_ *Options, value reflect.Value, _ int) (int, error) {
+				if value.IsNil() {
+					return 1, nil
+				}
+				return bigIntSize(value.Convert(typeOfBigIntPtr).Interface().(*big.Int)), nil
+			}
+		case typ.AssignableTo(typeOfBigInt) || ConvertibleTo(typ, typeOfBigInt):
+			return func(_ *Options, value reflect.Value, _ int) (int, error) {
+				bi := value.Convert(typeOfBigInt).Interface().(big.Int)
+				return bigIntSize(&bi), nil
+			}
+		}
+		return c.encodedSize
+	}
+
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(_ *Options, value reflect.Value, _ int) (int, error) {
+			i := value.Int()
+			if i < 0 {
+				return numberSize(true, uint64(-i)), nil
+			}
+			return numberSize(false, uint64(i)), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(_ *Options, value reflect.Value, _ int) (int, error) {
+			return numberSize(false, value.Uint()), nil
+		}
+	case reflect.Float32:
+		return func(_ *Options, value reflect.Value, _ int) (int, error) {
+			f := float32(value.Float())
+			neg := f < 0
+			if neg {
+				f = -f
+			}
+			return numberSize(neg, uint64(math.Float32bits(f))), nil
+		}
+	case reflect.Float64:
+		return func(_ *Options, value reflect.Value, _ int) (int, error) {
+			f := value.Float()
+			neg := f < 0
+			if neg {
+				f = -f
+			}
+			return numberSize(neg, math.Float64bits(f)), nil
+		}
+	case reflect.Bool:
+		return func(_ *Options, _ reflect.Value, _ int) (int, error) {
+			return 1, nil
+		}
+	case reflect.String:
+		return func(_ *Options, value reflect.Value, _ int) (int, error) {
+			s := value.String()
+			if len(s) == 1 {
+				return bytesSize(1, s[0]), nil
+			}
+			return bytesSize(len(s), 0), nil
+		}
+	case reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			// byte array, empty one is encoded as an empty value
+			return func(_ *Options, value reflect.Value, _ int) (int, error) {
+				switch value.Len() {
+				case 0:
+					return 1, nil
+				case 1:
+					return bytesSize(1, byte(value.Index(0).Uint())), nil
+				}
+				return bytesSize(value.Len(), 0), nil
+			}
+		}
+		return func(opts *Options, value reflect.Value, nesting int) (int, error) {
+			return arraySize(opts, value, c.child(0), nesting)
+		}
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return func(_ *Options, value reflect.Value, _ int) (int, error) {
+				bs := value.Bytes()
+				if len(bs) == 1 {
+					return bytesSize(1, bs[0]), nil
+				}
+				return bytesSize(len(bs), 0), nil
+			}
+		}
+		return func(opts *Options, value reflect.Value, nesting int) (int, error) {
+			if value.IsNil() {
+				return 1, nil
+			}
+			return arraySize(opts, value, c.child(0), nesting)
+		}
+	case reflect.Map:
+		return func(opts *Options, value reflect.Value, nesting int) (int, error) {
+			return mapSize(opts, value, c.child(0), c.child(1), nesting)
+		}
+	case reflect.Struct:
+		return func(opts *Options, value reflect.Value, nesting int) (int, error) {
+			return structSize(opts, value, c, nesting)
+		}
+	case reflect.Ptr:
+		return func(opts *Options, value reflect.Value, nesting int) (int, error) {
+			if value.IsNil() {
+				return 1, nil
+			}
+			return c.child(0).sizeValue(opts, value.Elem(), nesting)
+		}
+	case reflect.Interface:
+		return interfaceSize
+	default:
+		return func(_ *Options, _ reflect.Value, _ int) (int, error) {
+			return 0, fmt.Errorf("unsupported type %v for encoding", typ)
+		}
+	}
+}
+
+// uintSize returns the number of bytes of u written by Numeric.writeUint
+func uintSize(u uint64) int {
+	if u == 0 {
+		return 1
+	}
+	return (bits.Len64(u) + 7) / 8
+}
+
+// headerSize returns the number of bytes of a header with length, which is a single byte header
+// if length <= max (see headMaker)
+func headerSize(length int, max int) int {
+	if length <= max {
+		return 1
+	}
+	return 1 + uintSize(uint64(length))
+}
+
+// numberSize returns the encoded size of the number, see smallNumberWriter
+func numberSize(isNegative bool, u uint64) int {
+	if !isNegative && u <= 127 {
+		return 1
+	}
+	return 1 + uintSize(u)
+}
+
+func bigIntSize(bi *big.Int) int {
+	if bi.Sign() >= 0 && bi.Cmp(bigint128) < 0 {
+		return 1
+	}
+	l := (bi.BitLen() + 7) / 8
+	return headerSize(l, 8) + l
+}
+
+// bytesSize returns the encoded size of a string (or byte slice) of length bytes, first is the
+// first byte if length is 1. See bytesWriter, a nil or empty one is encoded as one byte.
+func bytesSize(length int, first byte) int {
+	if length == 0 || (length == 1 && first <= 127) {
+		return 1
+	}
+	return headerSize(length, 32) + length
+}
+
+func arraySize(opts *Options, value reflect.Value, ecodec *typeCodec, nesting int) (int, error) {
+	length := value.Len()
+	if length <= 0 {
+		return 1, nil
+	}
+	// array would +1 to nesting, so equals to MaxNested is overflowed
+	if err := opts.checkNesting(nesting + 1); err != nil {
+		return 0, err
+	}
+	ret := headerSize(length, 16)
+	for i := 0; i < length; i++ {
+		n, err := ecodec.sizeValue(opts, value.Index(i), nesting+1)
+		if err != nil {
+			return 0, err
+		}
+		ret += n
+	}
+	return ret, nil
+}
+
+func mapSize(opts *Options, value reflect.Value, kcodec, vcodec *typeCodec, nesting int) (int, error) {
+	if value.IsNil() || value.Len() == 0 {
+		return 1, nil
+	}
+	// map would +1 to nesting, so equals to MaxNested is overflowed
+	if err := opts.checkNesting(nesting + 1); err != nil {
+		return 0, err
+	}
+	// the order of the entries in canonical mode does not change the size
+	ret := headerSize(value.Len()<<1, 16)
+	iter := value.MapRange()
+	for iter.Next() {
+		n, err := kcodec.sizeValue(opts, iter.Key(), nesting+1)
+		if err != nil {
+			return 0, err
+		}
+		ret += n
+		if n, err = vcodec.sizeValue(opts, iter.Value(), nesting+1); err != nil {
+			return 0, err
+		}
+		ret += n
+	}
+	return ret, nil
+}
+
+// structSize returns the encoded size of the struct, see structWriter0
+func structSize(opts *Options, value reflect.Value, c *typeCodec, nesting int) (int, error) {
+	_, fnames := structFields(c.typ)
+	if len(fnames) <= 0 {
+		return 1, nil
+	}
+	// struct would +1 to nesting, so equals to MaxNested is overflowed
+	if err := opts.checkNesting(nesting + 1); err != nil {
+		return 0, err
+	}
+
+	fnum, fnames := versionedFields(value, fnames)
+	version := structVersion(fnames)
+	if opts.CompactZeros {
+		fnum, fnames = compactFields(value, fnames)
+	}
+
+	ret := 0
+	if version > 0 {
+		ret += headerSize(version, 15)
+	}
+	if fnum == 0 {
+		return ret + 1, nil
+	}
+	ret += headerSize(fnum, 16)
+
+	order := -1
+	for i, fname := range fnames {
+		if gap := fname.order - order - 1; gap > 0 {
+			// placeholders of the skipped orders, see zerosPlacehold
+			if opts.CompactZeros && gap >= minZeroRun {
+				ret += 1 + uintSize(uint64(gap))
+			} else {
+				ret += gap
+			}
+		}
+		order = fname.order
+		n, err := c.child(i).sizeValue(opts, value.Field(fname.index), nesting+1)
+		if err != nil {
+			return 0, err
+		}
+		ret += n
+	}
+	return ret, nil
+}
+
+func interfaceSize(opts *Options, value reflect.Value, nesting int) (int, error) {
+	if value.IsNil() {
+		return 1, nil
+	}
+	elem := value.Elem()
+	if !opts.TypedInterfaces {
+		return codecOf(elem.Type()).sizeValue(opts, elem, nesting)
+	}
+	// [id, value], see typedInterfaceWriter
+	id, ok := registeredID(elem.Type())
+	if !ok {
+		return 0, fmt.Errorf("%w: %v", ErrUnregisteredType, elem.Type())
+	}
+	if err := opts.checkNesting(nesting + 1); err != nil {
+		return 0, err
+	}
+	n, err := codecOf(elem.Type()).sizeValue(opts, elem, nesting+1)
+	if err != nil {
+		return 0, err
+	}
+	return 1 + numberSize(false, id) + n, nil
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// StreamEncoder writes values one after another to a stream, which could be read by a
+// StreamDecoder with the same Options.
+//
+// In framed mode (WithFramed), each value is written as a byte string of its encoding, that is,
+// the length of the encoding is prefixed. So that a corrupt value could be skipped when decoding.
+type StreamEncoder struct {
+	w   *optionsWriter
+	buf encodeBuffer // encoding of the value in framed mode
+}
+
+func NewStreamEncoder(w io.Writer, opts ...Option) *StreamEncoder {
+	options := newOptions(opts...)
+	return &StreamEncoder{
+		w:   &optionsWriter{Writer: w, opts: options},
+		buf: encodeBuffer{opts: options},
+	}
+}
+
+// Encode writes v as the next value of the stream
+func (e *StreamEncoder) Encode(v interface{}) error {
+	if !e.w.opts.Framed {
+		return Encode(v, e.w)
+	}
+	e.buf.buf = e.buf.buf[:0]
+	if _, err := valueWriter(&e.buf, reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	if len(e.buf.buf) == 0 {
+		return fmt.Errorf("%w: nothing to write for %v", ErrUnsupported, v)
+	}
+	_, err := bytesWriter(e.w, e.buf.buf)
+	return err
+}
+
+// StreamDecoder reads the values written by StreamEncoder one by one:
+//
+//	dec := rtl.NewStreamDecoder(r)
+//	for dec.More() {
+//		rec := new(Record)
+//		if err := dec.Decode(rec); err != nil {
+//			...
+//		}
+//	}
+//
+// Decode returns io.EOF at the end of the stream, and io.ErrUnexpectedEOF (could be checked by
+// errors.Is) if the stream is truncated. In framed mode, the value in a frame which could not be
+// decoded is skipped with a *FrameError, and Decode could be called again for the next value.
+type StreamDecoder struct {
+	vr    *defaultVR
+	frame []byte // buffer of the frame in framed mode
+}
+
+func NewStreamDecoder(r io.Reader, opts ...Option) *StreamDecoder {
+	return &StreamDecoder{vr: newValueReader(r, newOptions(opts...))}
+}
+
+// More reports whether there's another value in the stream. It returns true if reading failed
+// with an error other than io.EOF, which would be returned by the next Decode.
+func (d *StreamDecoder) More() bool {
+	d.vr.resetLimit()
+	if _, err := d.vr.ReadByte(); err != nil {
+		return err != io.EOF
+	}
+	return d.vr.UnreadByte() == nil
+}
+
+// Offset returns the number of bytes read from the stream
+func (d *StreamDecoder) Offset() int {
+	return d.vr.Offset()
+}
+
+// Decode decodes the next value in the stream to v, Options.MaxBytes is applied to each value
+// (including the frame header in framed mode).
+func (d *StreamDecoder) Decode(v interface{}) error {
+	d.vr.resetLimit()
+	start := d.vr.Offset()
+	if !d.vr.opts.Framed {
+		return truncatedError(Decode(d.vr, v), start, d.vr.Offset())
+	}
+
+	frame, err := d.readFrame()
+	if err != nil {
+		return truncatedError(err, start, d.vr.Offset())
+	}
+	fr := newValueReader(bytes.NewReader(frame), d.vr.opts)
+	if err := Decode(fr, v); err != nil {
+		return &FrameError{Offset: start, Err: truncatedError(err, -1, fr.Offset())}
+	}
+	if left := len(frame) - fr.Offset(); left > 0 {
+		return &FrameError{Offset: start, Err: fmt.Errorf("%w: %d bytes left in the frame", ErrLength, left)}
+	}
+	return nil
+}
+
+// readFrame reads the bytes of the next frame
+func (d *StreamDecoder) readFrame() ([]byte, error) {
+	th, length, err := d.vr.ReadFullHeader()
+	if err != nil {
+		return nil, err
+	}
+	switch th {
+	case THSingleByte:
+		d.frame = append(d.frame[:0], byte(length))
+		return d.frame, nil
+	case THStringSingle, THStringMulti:
+		frame, err := d.vr.ReadBytes(length, d.frame)
+		if err != nil {
+			return nil, err
+		}
+		d.frame = frame
+		return frame, nil
+	}
+	return nil, fmt.Errorf("rtl: illegal frame header %s at offset %d", th, d.vr.Offset()-1)
+}
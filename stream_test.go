@@ -0,0 +1,175 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type streamRecord struct {
+	Seq  uint
+	Name string
+	Tags []string
+}
+
+func streamRecords(n int) []*streamRecord {
+	recs := make([]*streamRecord, n)
+	for i := range recs {
+		recs[i] = &streamRecord{Seq: uint(i * 100), Name: "record", Tags: []string{"a", "bc"}}
+	}
+	recs[1].Tags = nil
+	return recs
+}
+
+func encodeStream(t *testing.T, recs []*streamRecord, opts ...Option) []byte {
+	buf := new(bytes.Buffer)
+	enc := NewStreamEncoder(buf, opts...)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			t.Fatalf("encode %+v failed: %v", rec, err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// decodeStream decodes all the records in bs, failed records are nil
+func decodeStream(bs []byte, opts ...Option) ([]*streamRecord, []error) {
+	var recs []*streamRecord
+	var errs []error
+	dec := NewStreamDecoder(bytes.NewReader(bs), opts...)
+	for dec.More() {
+		rec := new(streamRecord)
+		if err := dec.Decode(rec); err != nil {
+			errs = append(errs, err)
+			var fe *FrameError
+			if !errors.As(err, &fe) {
+				break
+			}
+			rec = nil
+		}
+		recs = append(recs, rec)
+	}
+	return recs, errs
+}
+
+func TestStream(t *testing.T) {
+	forEachEngine(t, func(t *testing.T) {
+		for _, opts := range [][]Option{nil, {WithFramed()}} {
+			recs := streamRecords(5)
+			bs := encodeStream(t, recs, opts...)
+			got, errs := decodeStream(bs, opts...)
+			if len(errs) > 0 || !reflect.DeepEqual(got, recs) {
+				t.Fatalf("decode stream got %+v, errors: %v", got, errs)
+			}
+
+			// end of the stream
+			dec := NewStreamDecoder(bytes.NewReader(bs), opts...)
+			for range recs {
+				if err := dec.Decode(new(streamRecord)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if dec.Offset() != len(bs) {
+				t.Errorf("offset got %d, want %d", dec.Offset(), len(bs))
+			}
+			if err := dec.Decode(new(streamRecord)); err != io.EOF {
+				t.Errorf("decode at the end of stream should return io.EOF, but got: %v", err)
+			}
+
+			// truncated stream
+			got, errs = decodeStream(bs[:len(bs)-2], opts...)
+			if len(errs) != 1 || !errors.Is(errs[0], io.ErrUnexpectedEOF) || len(got) != len(recs)-1 {
+				t.Errorf("decode truncated stream got %d records, errors: %v", len(got), errs)
+			}
+		}
+	})
+}
+
+func TestStreamFramed(t *testing.T) {
+	recs := streamRecords(4)
+	var frames [][]byte
+	for _, rec := range recs {
+		frames = append(frames, encodeStream(t, []*streamRecord{rec}, WithFramed()))
+	}
+	// the Name of the 2nd record is replaced by a reserved header
+	frames[1][3] = 0x83
+	// 2 bytes more in the 3rd frame
+	bs, err := Marshal(recs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames[2], err = Marshal(append(bs, 0x01, 0x02)); err != nil {
+		t.Fatal(err)
+	}
+
+	forEachEngine(t, func(t *testing.T) {
+		got, errs := decodeStream(bytes.Join(frames, nil), WithFramed())
+		want := []*streamRecord{recs[0], nil, nil, recs[3]}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("decode framed stream got %+v, want %+v", got, want)
+		}
+		if len(errs) != 2 || !errors.Is(errs[1], ErrLength) {
+			t.Fatalf("decode framed stream errors: %v", errs)
+		}
+		var fe *FrameError
+		if !errors.As(errs[0], &fe) || fe.Offset != len(frames[0]) {
+			t.Errorf("frame error got %v", errs[0])
+		}
+
+		// unframed stream is not decoded in framed mode
+		if _, errs := decodeStream(encodeStream(t, recs), WithFramed()); len(errs) == 0 {
+			t.Errorf("decode unframed stream in framed mode should fail")
+		}
+	})
+}
+
+// repeatedReader returns a reader of n copies of bs, which has no Len()
+func repeatedReader(head, bs []byte, n int) io.Reader {
+	readers := []io.Reader{bytes.NewReader(head)}
+	for i := 0; i < n; i++ {
+		readers = append(readers, bytes.NewReader(bs))
+	}
+	return io.MultiReader(readers...)
+}
+
+func TestStreamLong(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams more than MaxSliceSize bytes")
+	}
+	bs, err := Marshal(&streamRecord{Seq: 1, Name: string(bytes.Repeat([]byte{'n'}, 1<<20))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := MaxSliceSize/len(bs) + 10
+	dec := NewStreamDecoder(repeatedReader(nil, bs, count))
+	rec := new(streamRecord)
+	for i := 0; i < count; i++ {
+		if err := dec.Decode(rec); err != nil {
+			t.Fatalf("record %d at %d: %v", i, dec.Offset(), err)
+		}
+	}
+	if dec.Offset() <= MaxSliceSize {
+		t.Fatalf("only %d bytes read", dec.Offset())
+	}
+	if err := dec.Decode(rec); err != io.EOF {
+		t.Errorf("end of stream got %v", err)
+	}
+}
@@ -17,6 +17,8 @@
 package rtl
 
 import (
+	"bytes"
+	"errors"
 	"math/big"
 	"reflect"
 	"testing"
@@ -148,3 +150,151 @@ func TestVersionedFields(t *testing.T) {
 		}
 	}
 }
+
+type (
+	structV1 struct {
+		A uint   `rtlversion:"0"`
+		B string `rtlversion:"1"`
+	}
+	structV3 struct {
+		A uint   `rtlversion:"0"`
+		B string `rtlversion:"1"`
+		C int    `rtlversion:"2"`
+		D []byte `rtlversion:"3"`
+	}
+	structV20 struct {
+		A uint   `rtlversion:"0"`
+		B string `rtlversion:"20"`
+	}
+	versionHolder struct {
+		V  *structV3
+		Vs []structV3
+		N  uint
+	}
+	versionHolderV1 struct {
+		V  *structV1
+		Vs []structV1
+		N  uint
+	}
+)
+
+func TestStructVersionHeader(t *testing.T) {
+	tests := []struct {
+		val    interface{}
+		expect []byte
+	}{
+		{structV3{A: 1}, []byte{0x91, 0x01}},
+		{structV3{A: 1, B: "b"}, []byte{Version1, 0x92, 0x01, 'b'}},
+		{structV3{A: 1, C: 2}, []byte{Version2, 0x93, 0x01, 0x80, 0x02}},
+		{structV3{A: 1, D: []byte{3}}, []byte{Version3, 0x94, 0x01, 0x80, 0x00, 0x03}},
+		{structV20{A: 1, B: "b"}, []byte{0xE9, 0x14, 0x92, 0x01, 'b'}},
+	}
+	for _, test := range tests {
+		bs, err := Marshal(test.val)
+		if err != nil {
+			t.Fatalf("marshal %+v failed: %v", test.val, err)
+		}
+		if !bytes.Equal(bs, test.expect) {
+			t.Fatalf("marshal %+v expecting %x but %x", test.val, test.expect, bs)
+		}
+		forEachEngine(t, func(t *testing.T) {
+			nv := reflect.New(reflect.TypeOf(test.val))
+			if err := Decode(bytes.NewReader(bs), nv.Interface()); err != nil {
+				t.Fatalf("decode %x failed: %v", bs, err)
+			}
+			if !reflect.DeepEqual(nv.Elem().Interface(), test.val) {
+				t.Fatalf("decode %x expecting %+v but %+v", bs, test.val, nv.Elem().Interface())
+			}
+		})
+		t.Logf("%+v -> %x check", test.val, bs)
+	}
+}
+
+func TestStructVersionLayout(t *testing.T) {
+	// a version 1 stream with more elements than the fields existing in version 1
+	bs := []byte{Version1, 0x94, 0x01, 'b', 0x02, 0x03}
+	forEachEngine(t, func(t *testing.T) {
+		v3 := &structV3{A: 9, B: "x", C: 9, D: []byte{9}}
+		if err := Decode(bytes.NewReader(bs), v3); err != nil {
+			t.Fatalf("decode %x failed: %v", bs, err)
+		}
+		if v3.A != 1 || v3.B != "b" || v3.C != 0 || v3.D != nil {
+			t.Fatalf("decode %x with version 1 layout failed: %+v", bs, v3)
+		}
+	})
+}
+
+func TestStructVersionFollowed(t *testing.T) {
+	// a value has one version prefix at most
+	bs := []byte{Version1, Version2, 0x92, 0x01, 'b'}
+	forEachEngine(t, func(t *testing.T) {
+		if err := Decode(bytes.NewReader(bs), new(structV3)); !errors.Is(err, ErrDecode) {
+			t.Errorf("decode %x should fail with %v, but got: %v", bs, ErrDecode, err)
+		}
+	})
+	if _, err := NewBytesValueReader(bs).Skip(); !errors.Is(err, ErrDecode) {
+		t.Errorf("skip %x should fail with %v, but got: %v", bs, ErrDecode, err)
+	}
+	var raw RawValue
+	if err := Unmarshal(bs, &raw); !errors.Is(err, ErrDecode) {
+		t.Errorf("raw value of %x should fail with %v, but got: %v", bs, ErrDecode, err)
+	}
+	var a int
+	if err := DecodePath(bytes.NewReader(bs), []int{0}, &a); !errors.Is(err, ErrDecode) {
+		t.Errorf("decode path of %x should fail with %v, but got: %v", bs, ErrDecode, err)
+	}
+	if err := Walk(bytes.NewReader(bs), new(walkRecorder)); !errors.Is(err, ErrDecode) {
+		t.Errorf("walk %x should fail with %v, but got: %v", bs, ErrDecode, err)
+	}
+	if err := Unmarshal(bs, new(Node)); !errors.Is(err, ErrDecode) {
+		t.Errorf("node of %x should fail with %v, but got: %v", bs, ErrDecode, err)
+	}
+	if err := ToJSON(bytes.NewReader(bs), new(bytes.Buffer)); !errors.Is(err, ErrDecode) {
+		t.Errorf("json of %x should fail with %v, but got: %v", bs, ErrDecode, err)
+	}
+}
+
+func TestStructVersionCompatible(t *testing.T) {
+	holder := &versionHolder{
+		V: &structV3{A: 1, B: "b", C: -3, D: []byte("d")},
+		Vs: []structV3{
+			{A: 2},
+			{A: 3, B: "bb"},
+			{A: 4, B: "bbb", C: 4},
+		},
+		N: 1000,
+	}
+	bs, err := Marshal(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forEachEngine(t, func(t *testing.T) {
+		h3 := new(versionHolder)
+		if err := Decode(bytes.NewReader(bs), h3); err != nil {
+			t.Fatalf("decode to v3 failed: %v", err)
+		}
+		if !reflect.DeepEqual(holder, h3) {
+			t.Fatalf("v3 -> v3 failed: %+v -> %+v", holder, h3)
+		}
+
+		h1 := new(versionHolderV1)
+		if err := Decode(bytes.NewReader(bs), h1); err != nil {
+			t.Fatalf("decode to v1 failed: %v", err)
+		}
+		if h1.V == nil || h1.V.A != 1 || h1.V.B != "b" || len(h1.Vs) != 3 || h1.Vs[2].B != "bbb" || h1.N != 1000 {
+			t.Fatalf("v3 -> v1 failed: %+v -> %+v", holder, h1)
+		}
+	})
+
+	forEachEngine(t, func(t *testing.T) {
+		var i interface{}
+		if err := Decode(bytes.NewReader(bs), &i); err != nil {
+			t.Fatalf("decode to interface{} failed: %v", err)
+		}
+	})
+
+	vr := NewValueReader(bytes.NewReader(bs))
+	if n, err := vr.Skip(); err != nil || n != len(bs) {
+		t.Fatalf("skip %d bytes expecting %d, error: %v", n, len(bs), err)
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+)
+
+// TokenWriter writes the headers and values of an RTL stream one by one, it's the counterpart of
+// ValueReader for writing. It could be used in Encoder.Serialization to produce valid encodings
+// without knowing the rules of the headers, e.g. a struct of 2 fields:
+//
+//	func (p *Pair) Serialization(w io.Writer) error {
+//		tw := rtl.NewTokenWriter(w)
+//		if err := tw.WriteArrayHeader(2); err != nil {
+//			return err
+//		}
+//		if err := tw.WriteString(p.Key); err != nil {
+//			return err
+//		}
+//		return tw.WriteUint(p.Value)
+//	}
+//
+// The number of the values following an array header is not checked. TokenWriter is also an
+// io.Writer with the Options of w, so that values could be written by Encode with it. The
+// Serialization methods generated by cmd/rtlgen are written by TokenWriter too.
+type TokenWriter struct {
+	w    io.Writer
+	hbuf [9]byte
+}
+
+// NewTokenWriter returns a TokenWriter writing to w, or w itself if it's a TokenWriter
+func NewTokenWriter(w io.Writer) *TokenWriter {
+	if tw, ok := w.(*TokenWriter); ok {
+		return tw
+	}
+	return &TokenWriter{w: w}
+}
+
+// Write writes the encoded bytes p to the underlying writer directly
+func (t *TokenWriter) Write(p []byte) (int, error) {
+	return t.w.Write(p)
+}
+
+func writeErr(_ int, err error) error {
+	return err
+}
+
+func (t *TokenWriter) scratch() []byte {
+	return t.hbuf[:]
+}
+
+func (t *TokenWriter) options() *Options {
+	return optionsOf(t.w)
+}
+
+// WriteArrayHeader writes the header of an array with n (>0) elements, which should be followed
+// by n values. An array without elements is written by WriteEmpty.
+func (t *TokenWriter) WriteArrayHeader(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("%w: array length %d", ErrLength, n)
+	}
+	return writeErr(writeArrayHeader(t, n))
+}
+
+// WriteString writes a string, "" is written as zero value
+func (t *TokenWriter) WriteString(s string) error {
+	return writeErr(writeString(t, s))
+}
+
+// WriteBytes writes a byte slice, nil is written as zero value, and an empty slice as empty value
+func (t *TokenWriter) WriteBytes(bs []byte) error {
+	return writeErr(bytesWriter(t, bs))
+}
+
+// WriteUint writes an unsigned integer
+func (t *TokenWriter) WriteUint(u uint64) error {
+	return writeErr(smallNumberWriter(t, false, u))
+}
+
+// WriteInt writes a signed integer
+func (t *TokenWriter) WriteInt(i int64) error {
+	isNegative := false
+	if i < 0 {
+		isNegative = true
+		i = -i
+	}
+	return writeErr(smallNumberWriter(t, isNegative, uint64(i)))
+}
+
+// WriteFloat32 writes a float32
+func (t *TokenWriter) WriteFloat32(f float32) error {
+	neg := f < 0
+	if neg {
+		f = -f
+	}
+	return writeErr(smallNumberWriter(t, neg, uint64(math.Float32bits(f))))
+}
+
+// WriteFloat64 writes a float64
+func (t *TokenWriter) WriteFloat64(f float64) error {
+	neg := f < 0
+	if neg {
+		f = -f
+	}
+	return writeErr(smallNumberWriter(t, neg, math.Float64bits(f)))
+}
+
+// WriteBigInt writes a big.Int, nil is written as zero value
+func (t *TokenWriter) WriteBigInt(i *big.Int) error {
+	return writeErr(writeBigInt(t, i))
+}
+
+// WriteBool writes a bool
+func (t *TokenWriter) WriteBool(b bool) error {
+	if b {
+		return writeErr(t.Write(trueBools))
+	}
+	return writeErr(t.Write(zeroValues))
+}
+
+// WriteZero writes a zero value (nil, false, 0, "")
+func (t *TokenWriter) WriteZero() error {
+	return writeErr(t.Write(zeroValues))
+}
+
+// WriteZeros writes count zero values one by one, e.g. as the placeholders of the orders skipped
+// by the fields of a struct
+func (t *TokenWriter) WriteZeros(count int) error {
+	return writeErr(zerosPlacehold(t, count, false))
+}
+
+// WriteEmpty writes an empty value, such as an empty slice or map
+func (t *TokenWriter) WriteEmpty() error {
+	return writeErr(t.Write(emptyValues))
+}
+
+// WriteVersion writes the struct version prefix, which should be followed by the array header of
+// the fields
+func (t *TokenWriter) WriteVersion(version uint64) error {
+	return writeErr(writeVersionHeader(t, version))
+}
+
+// WriteStructHeader writes the version prefix (if version > 0) and the array header of a struct
+// with fieldNum elements
+func (t *TokenWriter) WriteStructHeader(version int, fieldNum int) error {
+	if version > 0 {
+		if err := t.WriteVersion(uint64(version)); err != nil {
+			return err
+		}
+	}
+	return t.WriteArrayHeader(fieldNum)
+}
+
+// WriteRaw writes an encoded value (e.g. a RawValue) unchanged, empty raw is written as zero value
+func (t *TokenWriter) WriteRaw(raw []byte) error {
+	return RawValue(raw).Serialization(t)
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+type tokenPair struct {
+	Key   string
+	Value uint64
+}
+
+// tokenPairs is encoded by TokenWriter as an array of [Key, Value]
+type tokenPairs []tokenPair
+
+func (p tokenPairs) Serialization(w io.Writer) error {
+	tw := NewTokenWriter(w)
+	if len(p) == 0 {
+		return tw.WriteEmpty()
+	}
+	if err := tw.WriteArrayHeader(len(p)); err != nil {
+		return err
+	}
+	for _, pair := range p {
+		if err := tw.WriteArrayHeader(2); err != nil {
+			return err
+		}
+		if err := tw.WriteString(pair.Key); err != nil {
+			return err
+		}
+		if err := tw.WriteUint(pair.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestTokenWriter(t *testing.T) {
+	type tokens struct {
+		A uint
+		B int
+		C string
+		D []byte
+		E *big.Int
+		F bool
+		G []uint
+		H []uint
+		I RawValue
+		J []byte
+	}
+	src := &tokens{
+		A: 300, B: -5, C: "token writer", D: bytes.Repeat([]byte{1}, 40),
+		E: new(big.Int).Lsh(big.NewInt(1), 100), F: true, G: []uint{1, 2, 3}, H: []uint{},
+		I: RawValue{0xc2, 'h', 'i'},
+	}
+	want, err := Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	tw := NewTokenWriter(buf)
+	for _, err := range []error{
+		tw.WriteArrayHeader(10),
+		tw.WriteUint(uint64(src.A)),
+		tw.WriteInt(int64(src.B)),
+		tw.WriteString(src.C),
+		tw.WriteBytes(src.D),
+		tw.WriteBigInt(src.E),
+		tw.WriteBool(src.F),
+		tw.WriteArrayHeader(3),
+		tw.WriteUint(1), tw.WriteUint(2), tw.WriteUint(3),
+		tw.WriteEmpty(),
+		tw.WriteRaw(src.I),
+		tw.WriteZero(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("token writer got %x, want %x", buf.Bytes(), want)
+	}
+
+	// struct with version
+	want, _ = Marshal(structV20{A: 1, B: "b"})
+	buf.Reset()
+	for _, err := range []error{tw.WriteVersion(20), tw.WriteArrayHeader(2), tw.WriteUint(1), tw.WriteString("b")} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("version got %x, want %x", buf.Bytes(), want)
+	}
+
+	if NewTokenWriter(tw) != tw {
+		t.Errorf("token writer should not be wrapped again")
+	}
+	if err := tw.WriteArrayHeader(0); !errors.Is(err, ErrLength) {
+		t.Errorf("empty array header got %v", err)
+	}
+}
+
+func TestTokenWriterEncoder(t *testing.T) {
+	type withPairs struct {
+		Name  string
+		Pairs tokenPairs
+	}
+	src := &withPairs{Name: "pairs", Pairs: make(tokenPairs, 20)}
+	for i := range src.Pairs {
+		src.Pairs[i] = tokenPair{Key: "key", Value: uint64(i * 1000)}
+	}
+	bs, err := Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// decoded as the slice of structs
+	type decoded struct {
+		Name  string
+		Pairs []tokenPair
+	}
+	forEachEngine(t, func(t *testing.T) {
+		got := new(decoded)
+		if err := Unmarshal(bs, got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != src.Name || !reflect.DeepEqual(tokenPairs(got.Pairs), src.Pairs) {
+			t.Fatalf("got %+v", got)
+		}
+	})
+
+	// the stream is valid, and could be skipped
+	vr := NewBytesValueReader(bs)
+	if n, err := vr.Skip(); err != nil || n != len(bs) {
+		t.Fatalf("skip %d of %d bytes, err %v", n, len(bs), err)
+	}
+}
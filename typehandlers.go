@@ -16,7 +16,10 @@
 
 package rtl
 
-import "reflect"
+import (
+	"reflect"
+	"sync"
+)
 
 type (
 	addressHandler struct{}
@@ -48,8 +51,16 @@ type (
 	binaryUnmarshalerPtrHandler struct {
 		DefaultEventHandler
 	}
+	// convertedHandler decodes the value (or the pointer) of a type convertible to a prior type,
+	// by the handler of the pointer to the prior type
+	convertedHandler struct {
+		priorPtr reflect.Type
+	}
 )
 
+// cache of priorTypeHandler, type -> EventHandler (nil if not convertible to any prior type)
+var _priorTypeHandlers sync.Map
+
 func init() {
 	_systemTypeHandler(bigintHandler{}, typeOfBigInt)
 	_systemTypeHandler(bigintPtrhandler{}, reflect.PtrTo(typeOfBigInt))
@@ -179,3 +190,65 @@ func (binaryUnmarshalerPtrHandler) Bytes(ctx *HandleContext, value reflect.Value
 	}
 	return ctx.PopState()
 }
+
+// priorTypeHandler returns the handler of the types (or the pointers) convertible to a prior type,
+// e.g. type BigInt big.Int, or nil if there's no such prior type.
+func priorTypeHandler(typ reflect.Type) EventHandler {
+	if h, ok := _priorTypeHandlers.Load(typ); ok {
+		handler, _ := h.(EventHandler)
+		return handler
+	}
+	var handler EventHandler
+	for _, prior := range _readerPriorStructOrder {
+		priorPtr := reflect.PtrTo(prior)
+		if Convertible(typ, prior) || ConvertiblePtr(typ, priorPtr) {
+			handler = convertedHandler{priorPtr: priorPtr}
+			break
+		}
+	}
+	_priorTypeHandlers.Store(typ, handler)
+	return handler
+}
+
+func (c convertedHandler) _replace(ctx *HandleContext, value reflect.Value) error {
+	if value.Kind() != reflect.Ptr {
+		return ctx.ReplaceStack(value.Addr().Convert(c.priorPtr))
+	}
+	if value.IsNil() {
+		value.Set(reflect.New(value.Type().Elem()))
+	}
+	return ctx.ReplaceStack(value.Convert(c.priorPtr))
+}
+
+func (c convertedHandler) Byte(ctx *HandleContext, value reflect.Value, _ byte) error {
+	return c._replace(ctx, value)
+}
+
+func (convertedHandler) Zero(ctx *HandleContext, value reflect.Value) error {
+	value.Set(reflect.Zero(value.Type()))
+	return ctx.PopState()
+}
+
+func (c convertedHandler) True(ctx *HandleContext, value reflect.Value) error {
+	return c._replace(ctx, value)
+}
+
+func (c convertedHandler) Empty(ctx *HandleContext, value reflect.Value) error {
+	return c._replace(ctx, value)
+}
+
+func (c convertedHandler) Array(ctx *HandleContext, value reflect.Value, _ int) error {
+	return c._replace(ctx, value)
+}
+
+func (c convertedHandler) Number(ctx *HandleContext, value reflect.Value, _ bool, _ []byte) error {
+	return c._replace(ctx, value)
+}
+
+func (c convertedHandler) Bytes(ctx *HandleContext, value reflect.Value, _ []byte) error {
+	return c._replace(ctx, value)
+}
+
+func (c convertedHandler) Version(ctx *HandleContext, value reflect.Value, _ ...byte) error {
+	return c._replace(ctx, value)
+}
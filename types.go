@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 	"reflect"
 	"sort"
@@ -46,6 +47,37 @@ func (thvalue THValue) Match(b byte) bool {
 	return (b & thvalue.M) == thvalue.C
 }
 
+// headerInfo is the parsed header of a byte, as an entry of headerTable
+type headerInfo struct {
+	th TypeHeader // THInvalid for the reserved bytes
+	// the number in the header: value of THVTByte, length of THVTSingleHeader, or the number of
+	// bytes of the length of THVTMultiHeader
+	length int
+}
+
+// buildHeaderTable parses all the bytes with headerTypeMap, a byte could match at most one
+// TypeHeader.
+func buildHeaderTable() (table [256]headerInfo) {
+	for i := range table {
+		b := byte(i)
+		table[i] = headerInfo{th: THInvalid}
+		for th, thv := range headerTypeMap {
+			if !thv.Match(b) {
+				continue
+			}
+			if table[i].th != THInvalid {
+				panic(fmt.Sprintf("rtl: header 0x%02x matches both %s and %s", b, table[i].th, TypeHeader(th)))
+			}
+			l := int(b & thv.W)
+			if l == 0 && thv.T != THVTByte {
+				l = int(thv.W) + 1
+			}
+			table[i] = headerInfo{th: TypeHeader(th), length: l}
+		}
+	}
+	return table
+}
+
 func (thvalue THValue) WithNumber(b byte) byte {
 	return thvalue.C | (b & thvalue.W)
 }
@@ -56,9 +88,8 @@ func (th TypeHeader) Name() string {
 	if th == THInvalid {
 		return "N/A"
 	}
-	thv, ok := headerTypeMap[th]
-	if ok {
-		return thv.N
+	if th.IsValid() {
+		return headerTypeMap[th].N
 	}
 	return "TypeHeader" + strconv.Itoa(int(th))
 }
@@ -72,33 +103,47 @@ func (th TypeHeader) IsValid() bool {
 }
 
 func (th TypeHeader) Nested() bool {
-	thv, ok := headerTypeMap[th]
-	if ok {
-		return thv.X
+	if th.IsValid() {
+		return headerTypeMap[th].X
 	}
 	return false
 }
 
 func (th TypeHeader) ValueType() (THValueType, bool) {
-	thv, ok := headerTypeMap[th]
-	if ok {
-		return thv.T, true
+	if th.IsValid() {
+		return headerTypeMap[th].T, true
 	}
 	return THVTInvalid, false
 }
 
+// IsVersion returns true if th is a struct version header, which is a prefix of the struct value
+func (th TypeHeader) IsVersion() bool {
+	return th == THVersion || th == THVersionSingle
+}
+
+// errVersionFollowed is the error of a version header following another one, a value has one
+// version prefix at most.
+var errVersionFollowed = fmt.Errorf("%w: version header following a version", ErrDecode)
+
+// checkVersionFollowed checks next, the header following a version header, which could not be a
+// version header again.
+func checkVersionFollowed(next TypeHeader) error {
+	if next.IsVersion() {
+		return errVersionFollowed
+	}
+	return nil
+}
+
 func (th TypeHeader) FollowedByHeader() bool {
-	thv, ok := headerTypeMap[th]
-	if ok {
-		return !thv.B
+	if th.IsValid() {
+		return !headerTypeMap[th].B
 	}
 	return false
 }
 
 func (th TypeHeader) FollowedByBytes() bool {
-	thv, ok := headerTypeMap[th]
-	if ok {
-		return thv.B
+	if th.IsValid() {
+		return headerTypeMap[th].B
 	}
 	return false
 }
@@ -118,6 +163,7 @@ const (
 	THStringMulti                     // string with length more than 32
 	THVersion                         // 0 <= (version number) <= 15
 	THVersionSingle                   // 15 < (version number) < 2^64
+	THZeros                           // a run of zero values, as the skipped elements of a struct
 	THInvalid
 )
 
@@ -131,6 +177,8 @@ const (
 const (
 	MaxSliceSize = 100 * 1024 * 1024 // max size of creating slice: 100MB
 	MaxNested    = 100               // max nested times when encoding. pointer, slice, array, map, struct
+
+	noVersion = -1 // no struct version header found in the stream
 )
 
 type (
@@ -203,8 +251,8 @@ var (
 	typeOfString = reflect.TypeOf("")
 	typeOfByte   = reflect.TypeOf((*byte)(nil)).Elem()
 
-	// header constants
-	headerTypeMap = map[TypeHeader]THValue{
+	// header constants, indexed by TypeHeader
+	headerTypeMap = [THInvalid]THValue{
 		THSingleByte:    {"Byte", 0x00, 0x80, ^byte(0x80), THVTByte, false, false},
 		THZeroValue:     {"Zero", 0x80, 0xFF, 0x00, THVTByte, false, false},
 		THTrue:          {"True", 0x81, 0xFF, 0x00, THVTByte, false, false},
@@ -219,8 +267,12 @@ var (
 		THStringMulti:   {"String+", 0xE0, 0xF8, ^byte(0xF8), THVTMultiHeader, true, false},
 		THVersion:       {"Ver", 0xF0, 0xF0, ^byte(0xF0), THVTByte, false, false},
 		THVersionSingle: {"Ver+", 0xE8, 0xF8, ^byte(0xF8), THVTSingleHeader, false, false},
+		THZeros:         {"Zeros", 0x84, 0xFC, ^byte(0xFC), THVTSingleHeader, true, false},
 	}
 
+	// parsed headers of all the bytes, see ParseRTLHeader
+	headerTable = buildHeaderTable()
+
 	// primitive kind to valid TypeHeaders
 	primKindTypeHeaderMap = map[reflect.Kind]map[TypeHeader]typeReaderFunc{
 		reflect.Int:     intReaders,
@@ -239,7 +291,7 @@ var (
 		reflect.String:  stringReaders,
 	}
 
-	// cache for structFields
+	// cache for typeInfo
 	typeInfoMap = new(sync.Map)
 
 	// serialize/deserialize self
@@ -250,11 +302,17 @@ var (
 
 	// errors
 	ErrUnsupported        = errors.New("unsupported")
-	ErrNestingOverflow    = fmt.Errorf("nesting overflow: %d times", MaxNested)
+	ErrNestingOverflow    = errors.New("nesting overflow")
+	ErrExceedMaxBytes     = errors.New("rtl: exceeds the max number of bytes to read")
+	ErrExceedMaxAlloc     = errors.New("rtl: exceeds the max number of bytes to allocate")
 	ErrInsufficientLength = errors.New("insufficient length of the slice")
 	ErrDecode             = errors.New("decode error")
 	ErrLength             = errors.New("length error")
+	ErrOverflow           = errors.New("number overflow")
 	ErrTooLarge           = errors.New("too large to create")
+	ErrNonCanonical       = errors.New("rtl: non-canonical map")
+	ErrUnregisteredType   = errors.New("rtl: type not registered")
+	ErrPathNotFound       = errors.New("rtl: path not found")
 	ErrDecodeIntoNil      = errors.New("rtl: decode pointer MUST NOT be nil")
 	ErrDecodeNoPtr        = errors.New("rtl: value being decode MUST be a pointer")
 
@@ -387,6 +445,22 @@ func (headMaker) versionBuf(version uint64, buf []byte) (int, error) {
 	return l + 1, nil
 }
 
+// zerosBuf put a run of count zero values into buf, len(buf) must bigger or equal to 5
+func (headMaker) zerosBuf(count int, buf []byte) (int, error) {
+	if count <= 0 {
+		return 0, nil
+	}
+	if uint64(count) > math.MaxUint32 {
+		return 0, fmt.Errorf("%w: %d zero values in a run", ErrLength, count)
+	}
+	l, err := Numeric.writeUint(buf[1:], uint64(count))
+	if err != nil {
+		return 0, err
+	}
+	buf[0] = headerTypeMap[THZeros].WithNumber(byte(l))
+	return l + 1, nil
+}
+
 func (h headMaker) version(version uint64) ([]byte, error) {
 	r := make([]byte, 9)
 	l, err := h.versionBuf(version, r)
@@ -409,16 +483,44 @@ func (f fieldName) String() string {
 	return fmt.Sprintf("field{%d-%s, order:%d, version:%d}", f.index, f.name, f.order, f.version)
 }
 
+// typeInfo is the information cached for a type
+type typeInfo struct {
+	fieldsOnce  sync.Once
+	fields      []fieldName
+	fieldsPanic interface{} // the panic of parsing fields, should be repeated on every call
+
+	codecOnce sync.Once
+	codec     *typeCodec
+}
+
+func typeInfoOf(typ reflect.Type) *typeInfo {
+	if info, ok := typeInfoMap.Load(typ); ok {
+		return info.(*typeInfo)
+	}
+	info, _ := typeInfoMap.LoadOrStore(typ, new(typeInfo))
+	return info.(*typeInfo)
+}
+
 func structFields(typ reflect.Type) (fieldNum int, fields []fieldName) {
-	rv, ok := typeInfoMap.Load(typ)
-	if ok {
-		fields, _ = rv.([]fieldName)
-		fieldNum = 0
-		if len(fields) > 0 {
-			fieldNum = fields[len(fields)-1].order + 1
-		}
-		return
+	info := typeInfoOf(typ)
+	info.fieldsOnce.Do(func() {
+		defer func() {
+			info.fieldsPanic = recover()
+		}()
+		info.fields = parseStructFields(typ)
+	})
+	if info.fieldsPanic != nil {
+		panic(info.fieldsPanic)
 	}
+	fields = info.fields
+	fieldNum = 0
+	if len(fields) > 0 {
+		fieldNum = fields[len(fields)-1].order + 1
+	}
+	return
+}
+
+func parseStructFields(typ reflect.Type) (fields []fieldName) {
 	for i := 0; i < typ.NumField(); i++ {
 		// exported field
 		if f := typ.Field(i); f.PkgPath == "" {
@@ -505,12 +607,7 @@ func structFields(typ reflect.Type) (fieldNum int, fields []fieldName) {
 		}
 	}
 	// fmt.Printf("%s -> %s\n", typ.Name(), fields)
-	typeInfoMap.Store(typ, fields)
-	fieldNum = 0
-	if len(fields) > 0 {
-		fieldNum = fields[len(fields)-1].order + 1
-	}
-	return
+	return fields
 }
 
 // When any field under a certain version is not a zero value, all fields not greater than this version are reserved.
@@ -540,6 +637,35 @@ func versionedFields(val reflect.Value, fields []fieldName) (int, []fieldName) {
 	return fields[maxIndex].order + 1, fields[:maxIndex+1]
 }
 
+// structVersion returns the version of the struct which reserved the fields
+func structVersion(fields []fieldName) int {
+	if len(fields) == 0 {
+		return 0
+	}
+	return fields[len(fields)-1].version
+}
+
+// fieldsOfVersion returns the fields existing in the specified version of the struct. All fields
+// will be returned if version is noVersion.
+func fieldsOfVersion(fields []fieldName, version int) []fieldName {
+	if version < 0 {
+		return fields
+	}
+	i := sort.Search(len(fields), func(i int) bool {
+		return fields[i].version > version
+	})
+	return fields[:i]
+}
+
+// versionNumber converts the version number bytes in stream to a struct version
+func versionNumber(bs []byte) int {
+	v := Numeric.BytesToUint64(bs)
+	if v > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(v)
+}
+
 type StructCodec struct {
 	structType reflect.Type
 	isPtr      bool
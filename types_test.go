@@ -0,0 +1,65 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRTLHeader(t *testing.T) {
+	counts := make(map[TypeHeader]int)
+	for i := 0; i < 256; i++ {
+		b := byte(i)
+		th, length, err := ParseRTLHeader(b)
+		if b == 0x83 {
+			// reserved
+			if !errors.Is(err, ErrUnsupported) {
+				t.Errorf("0x83 got %s %d, %v", th, length, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("0x%02x: %v", b, err)
+		}
+		thv := headerTypeMap[th]
+		if !thv.Match(b) || thv.WithNumber(byte(length)) != b {
+			t.Errorf("0x%02x parsed as %s %d", b, th, length)
+		}
+		if vt, _ := th.ValueType(); vt != thv.T || th.Nested() != thv.X || th.FollowedByBytes() != thv.B {
+			t.Errorf("0x%02x: flags of %s mismatch", b, th)
+		}
+		counts[th]++
+	}
+	// number of bytes of each header
+	for th, want := range map[TypeHeader]int{
+		THSingleByte: 128, THZeroValue: 1, THTrue: 1, THEmpty: 1, THZeros: 4, THArrayMulti: 8,
+		THArraySingle: 16, THPosNumSingle: 8, THNegNumSingle: 8, THPosBigInt: 8, THNegBigInt: 8,
+		THStringSingle: 32, THStringMulti: 8, THVersionSingle: 8, THVersion: 16,
+	} {
+		if counts[th] != want {
+			t.Errorf("%s: %d bytes, want %d", th, counts[th], want)
+		}
+	}
+
+	if THInvalid.Name() != "N/A" || THInvalid.Nested() || THInvalid.FollowedByBytes() {
+		t.Errorf("invalid header")
+	}
+	if _, ok := (THInvalid + 1).ValueType(); ok {
+		t.Errorf("value type of an unknown header")
+	}
+}
@@ -41,6 +41,14 @@ type defaultVR struct {
 	readCount  int
 	header     [1]byte
 	readerSize int
+	sized      bool // readerSize is the length of reader, otherwise it's MaxSliceSize from limitBase
+	opts       *Options
+	limitBase  int          // readCount when starting to decode a value, for Options.MaxBytes
+	recording  int          // depth of the recordings in progress
+	raw        []byte       // bytes read when recording, for RawValue and canonical map keys
+	unread     bool         // header[0] is unread by UnreadByte, and would be read again
+	allocated  int          // bytes allocated when decoding a value, for Options.MaxAlloc
+	bytes      *bytesReader // in-memory input, whose bytes are read without copying
 }
 
 func EndOfFile(err error) bool {
@@ -56,14 +64,45 @@ func (r *defaultVR) filterErr(err error) error {
 }
 
 func (r *defaultVR) HasMore() bool {
-	if r.eof {
+	if r.eof && !r.unread {
 		return false
 	}
 	return true
 }
 
+func (r *defaultVR) options() *Options {
+	return r.opts
+}
+
+// resetLimit starts a new counting for Options.MaxBytes and Options.MaxAlloc. The bytes left in
+// a reader of unknown length are counted from here too, so that a long stream could be decoded
+// value by value.
+func (r *defaultVR) resetLimit() {
+	r.limitBase = r.readCount
+	r.allocated = 0
+	// left by a failed decoding
+	r.recording, r.raw = 0, nil
+	if !r.sized {
+		r.readerSize = r.readCount + MaxSliceSize
+	}
+}
+
+// checkLimit checks whether n more bytes could be read
+func (r *defaultVR) checkLimit(n int) error {
+	if r.opts != nil && r.opts.MaxBytes > 0 && r.readCount-r.limitBase+n > r.opts.MaxBytes {
+		return ErrExceedMaxBytes
+	}
+	return nil
+}
+
 func (r *defaultVR) left() int {
-	return r.readerSize - r.readCount
+	left := r.readerSize - r.readCount
+	if r.opts != nil && r.opts.MaxBytes > 0 {
+		if l := r.opts.MaxBytes - (r.readCount - r.limitBase); l < left {
+			left = l
+		}
+	}
+	return left
 }
 
 func (r *defaultVR) ReadHeader() (TypeHeader, int, error) {
@@ -77,6 +116,40 @@ func (r *defaultVR) ReadHeader() (TypeHeader, int, error) {
 	return ParseRTLHeader(b)
 }
 
+// ReadZeros reads the next header if it's a run of zero values (THZeros), and returns the number
+// of zero values in the run. Otherwise the header is unread and 0 is returned. vr must be an
+// io.ByteScanner (as the ValueReader created by this package is) to peek the header.
+func ReadZeros(vr ValueReader) (int, error) {
+	bs, ok := vr.(io.ByteScanner)
+	if !ok {
+		return 0, fmt.Errorf("rtl: ReadZeros needs an io.ByteScanner to peek the header, but %T found", vr)
+	}
+	if !vr.HasMore() {
+		return 0, nil
+	}
+	b, err := bs.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			// reported by the next reading
+			return 0, nil
+		}
+		return 0, err
+	}
+	th, length, err := ParseRTLHeader(b)
+	if err != nil || th != THZeros {
+		return 0, bs.UnreadByte()
+	}
+	buf, err := vr.ReadBytes(length, nil)
+	if err != nil {
+		return 0, err
+	}
+	count := Numeric.BytesToUint64(buf)
+	if count == 0 || count > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: %d zero values in a run", ErrLength, count)
+	}
+	return int(count), nil
+}
+
 func (r *defaultVR) ReadFullHeader() (TypeHeader, int, error) {
 	th, length, err := r.ReadHeader()
 	if err != nil {
@@ -100,6 +173,17 @@ func (r *defaultVR) ReadByte() (byte, error) {
 	if !r.HasMore() {
 		return 0, io.EOF
 	}
+	if err := r.checkLimit(1); err != nil {
+		return 0, err
+	}
+	if r.unread {
+		r.unread = false
+		r.readCount++
+		if r.recording > 0 {
+			r.raw = append(r.raw, r.header[0])
+		}
+		return r.header[0], nil
+	}
 	n, err := io.ReadFull(r.reader, r.header[:])
 	r.readCount += n
 	if err != nil {
@@ -109,20 +193,68 @@ func (r *defaultVR) ReadByte() (byte, error) {
 		r.eof = true
 		return 0, io.EOF
 	}
+	if r.recording > 0 {
+		r.raw = append(r.raw, r.header[0])
+	}
 	return r.header[0], nil
 }
 
+// UnreadByte unreads the last byte read by ReadByte, so that a header could be peeked.
+// Only one byte could be unread.
+func (r *defaultVR) UnreadByte() error {
+	if r.unread || r.readCount <= 0 {
+		return errors.New("rtl: UnreadByte failed")
+	}
+	r.unread = true
+	r.readCount--
+	if r.recording > 0 && len(r.raw) > 0 {
+		r.raw = r.raw[:len(r.raw)-1]
+	}
+	return nil
+}
+
 func (r *defaultVR) Read(p []byte) (int, error) {
 	if !r.HasMore() {
 		return 0, io.EOF
 	}
-
-	n, err := io.ReadFull(r.reader, p)
-	r.readCount += n
+	if err := r.checkLimit(len(p)); err != nil {
+		return 0, err
+	}
+	n, err := r._read(p)
 	return n, r.filterErr(err)
 }
 
 func (r *defaultVR) ReadBytes(length int, buf []byte) ([]byte, error) {
+	if err := optionsOf(r).checkLength(length); err != nil {
+		return buf, err
+	}
+	if r.bytes != nil && length > 0 {
+		bs, err := r.readSlice(length)
+		if r.opts != nil && r.opts.NoCopy {
+			return bs, err
+		}
+		// copied, the decoded values should not refer to the input. The length of bs has been
+		// limited by the input, so the length in the header is not trusted.
+		if len(bs) > cap(buf) {
+			if aerr := r.alloc(len(bs)); aerr != nil {
+				return buf, aerr
+			}
+			buf = make([]byte, len(bs))
+		}
+		buf = buf[:len(bs)]
+		copy(buf, bs)
+		return buf, err
+	}
+	if length > cap(buf) {
+		if length > allocChunk {
+			// the length in the header is not trusted
+			return readGrowing(r, r, length)
+		}
+		if err := r.alloc(length); err != nil {
+			return buf, err
+		}
+		buf = nil
+	}
 	return ReadBytesFromReader(r, length, buf)
 }
 
@@ -143,31 +275,126 @@ func (r *defaultVR) ReadMultiLengthBytes(length int, buf []byte) ([]byte, error)
 }
 
 func (r *defaultVR) _skip(length int) (int, error) {
+	if r.bytes != nil {
+		bs, err := r.readSlice(length)
+		return len(bs), err
+	}
+	if err := r.checkLimit(length); err != nil {
+		return 0, err
+	}
 	if length > 512 {
 		buf := make([]byte, 512)
 		for i := 0; i < length/512; i++ {
-			n, err := io.ReadFull(r.reader, buf)
-			r.readCount += n
+			n, err := r._read(buf)
 			if err != nil {
 				return i*512 + n, r.filterErr(err)
 			}
 		}
 		if m := length % 512; m > 0 {
 			buf = buf[:m]
-			n, err := io.ReadFull(r.reader, buf)
-			r.readCount += n
+			n, err := r._read(buf)
 			return length - m + n, r.filterErr(err)
 		} else {
 			return length, nil
 		}
 	} else {
 		buf := make([]byte, length)
-		n, err := io.ReadFull(r.reader, buf)
-		r.readCount += n
+		n, err := r._read(buf)
 		return n, r.filterErr(err)
 	}
 }
 
+// _read reads len(buf) bytes without limit checking, and records them if recording
+func (r *defaultVR) _read(buf []byte) (int, error) {
+	n := 0
+	if r.unread && len(buf) > 0 {
+		r.unread = false
+		buf[0] = r.header[0]
+		n = 1
+	}
+	m, err := io.ReadFull(r.reader, buf[n:])
+	if n > 0 && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	n += m
+	r.readCount += n
+	if r.recording > 0 {
+		r.raw = append(r.raw, buf[:n]...)
+	}
+	return n, err
+}
+
+// position returns the position of the next byte in the in-memory input
+func (r *defaultVR) position() int {
+	if r.unread {
+		return r.bytes.pos - 1
+	}
+	return r.bytes.pos
+}
+
+// readSlice returns the next length bytes of the in-memory input without copying, the capacity of
+// the returned slice is limited, so that appending to it could not overwrite the input.
+func (r *defaultVR) readSlice(length int) ([]byte, error) {
+	if err := r.checkLimit(length); err != nil {
+		return nil, err
+	}
+	start := r.position()
+	end := start + length
+	if end > len(r.bytes.data) {
+		end = len(r.bytes.data)
+	}
+	bs := r.bytes.data[start:end:end]
+	r.bytes.pos, r.unread = end, false
+	r.readCount += len(bs)
+	if r.recording > 0 {
+		r.raw = append(r.raw, bs...)
+	}
+	if len(bs) < length {
+		r.eof = true
+		return bs, io.EOF
+	}
+	return bs, nil
+}
+
+// readRaw reads the encoded bytes of the next value (including the struct version prefix) and
+// appends them to buf[:0]. In no-copy mode, the bytes of the in-memory input are returned.
+func (r *defaultVR) readRaw(buf []byte) ([]byte, error) {
+	if r.bytes != nil && r.opts != nil && r.opts.NoCopy {
+		start := r.position()
+		_, err := r.Skip()
+		end := r.position()
+		return r.bytes.data[start:end:end], err
+	}
+	outer := r.recording > 0
+	mark := r.record(buf)
+	_, err := r.Skip()
+	raw := r.recorded(mark)
+	if outer {
+		raw = append(buf[:0], raw...)
+	}
+	return raw, err
+}
+
+// record starts recording the bytes read into buf[:0], or goes on with the recording in progress
+// (buf is ignored). The returned mark should be passed to recorded.
+func (r *defaultVR) record(buf []byte) int {
+	if r.recording == 0 {
+		r.raw = buf[:0]
+	}
+	r.recording++
+	return len(r.raw)
+}
+
+// recorded stops the recording started at mark, and returns the bytes read since then, which
+// are shared with the outer recording if there is.
+func (r *defaultVR) recorded(mark int) []byte {
+	raw := r.raw[mark:]
+	if r.recording--; r.recording == 0 {
+		r.raw = nil
+	}
+	return raw
+}
+
 type headerStack struct {
 	th    TypeHeader
 	vt    THValueType
@@ -180,7 +407,7 @@ func (r *defaultVR) Skip() (int, error) {
 		return 0, io.EOF
 	}
 
-	var stack []*headerStack
+	var stack []headerStack
 	skiped := 0
 
 	readAndPush := func() error {
@@ -189,6 +416,24 @@ func (r *defaultVR) Skip() (int, error) {
 		if err != nil {
 			return err
 		}
+		// struct version is a prefix of the value
+		if th.IsVersion() {
+			if th == THVersionSingle {
+				n, err := r._skip(length)
+				skiped += n
+				if err != nil {
+					return err
+				}
+			}
+			th, length, err = r.ReadHeader()
+			skiped++
+			if err != nil {
+				return err
+			}
+			if err = checkVersionFollowed(th); err != nil {
+				return err
+			}
+		}
 
 		vt, exist := th.ValueType()
 		if !exist {
@@ -204,7 +449,7 @@ func (r *defaultVR) Skip() (int, error) {
 			size = int(ml)
 		}
 
-		stack = append(stack, &headerStack{
+		stack = append(stack, headerStack{
 			th:    th,
 			vt:    vt,
 			size:  size,
@@ -218,11 +463,13 @@ func (r *defaultVR) Skip() (int, error) {
 	}
 
 	for len(stack) > 0 {
-		last := stack[len(stack)-1]
+		last := &stack[len(stack)-1]
 		if last.th.Nested() {
 			last.index++
 			if last.index >= last.size {
-				break
+				// all elements of the array skipped
+				stack = stack[:len(stack)-1]
+				continue
 			}
 			if err := readAndPush(); err != nil {
 				return skiped, err
@@ -244,25 +491,11 @@ func (r *defaultVR) Skip() (int, error) {
 }
 
 func ParseRTLHeader(b byte) (TypeHeader, int, error) {
-	for th, thv := range headerTypeMap {
-		if thv.Match(b) {
-			switch thv.T {
-			case THVTByte:
-				return th, int(b & thv.W), nil
-			case THVTSingleHeader, THVTMultiHeader:
-				l := int(b & thv.W)
-				if l == 0 {
-					l = int(thv.W + 1)
-				}
-				return th, l, nil
-			default:
-				// should not be here
-				// panic("unknown type")
-				return THInvalid, 0, errors.New("unknown type")
-			}
-		}
+	h := &headerTable[b]
+	if h.th == THInvalid {
+		return 0, 0, ErrUnsupported
 	}
-	return 0, 0, ErrUnsupported
+	return h.th, h.length, nil
 }
 
 func ReadBytesFromReader(r io.Reader, length int, buf []byte) ([]byte, error) {
@@ -316,15 +549,65 @@ func ReadMultiLengthBytesFromReader(vr ValueReader, length int, buf []byte) ([]b
 }
 
 func NewValueReader(r io.Reader, _ ...int) ValueReader {
+	return newValueReader(r, nil)
+}
+
+// bytesReader is the in-memory input of a ValueReader
+type bytesReader struct {
+	data []byte
+	pos  int
+}
+
+func (b *bytesReader) Read(p []byte) (int, error) {
+	if b.pos >= len(b.data) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[b.pos:])
+	b.pos += n
+	return n, nil
+}
+
+func (b *bytesReader) Len() int {
+	return len(b.data) - b.pos
+}
+
+// NewBytesValueReader creates a ValueReader reading the values in data directly, instead of
+// copying them through an io.Reader. The byte slices returned by ReadBytes are copies, unless
+// WithNoCopy is set: then they (and the byte slices passed to the handlers of EventDecoder, the
+// decoded []byte values and RawValues) are sub-slices of data, which must not be modified while
+// they are in use.
+func NewBytesValueReader(data []byte, opts ...Option) ValueReader {
+	var options *Options
+	if len(opts) > 0 {
+		options = newOptions(opts...)
+	}
+	return newValueReader(&bytesReader{data: data}, options)
+}
+
+// newValueReader creates a ValueReader with options, if opts is nil, the options attached to r
+// will be used.
+func newValueReader(r io.Reader, opts *Options) *defaultVR {
+	if opts == nil {
+		if holder, ok := r.(optionsHolder); ok {
+			opts = holder.options()
+		}
+	}
 	l := MaxSliceSize
 	lenner, ok := r.(Lenner)
 	if ok {
 		l = lenner.Len()
 	}
+	br, _ := r.(*bytesReader)
 	return &defaultVR{
 		reader:     r,
 		eof:        false,
 		readCount:  0,
 		readerSize: l,
+		sized:      ok,
+		opts:       opts,
+		bytes:      br,
 	}
 }
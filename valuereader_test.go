@@ -0,0 +1,222 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// aliases returns whether sub is a sub-slice of buf
+func aliases(sub, buf []byte) bool {
+	if len(sub) == 0 || len(buf) == 0 {
+		return false
+	}
+	for i := range buf {
+		if &buf[i] == &sub[0] {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBytesValueReader(t *testing.T) {
+	// "abc", 0x05, "defgh"
+	data := []byte{0xc3, 'a', 'b', 'c', 0x05, 0xc5, 'd', 'e', 'f', 'g', 'h'}
+	vr := NewBytesValueReader(data)
+	if _, _, err := vr.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := vr.ReadBytes(3, nil); err != nil || string(bs) != "abc" || aliases(bs, data) {
+		t.Fatalf("read bytes should be copied, got %q, err %v", bs, err)
+	}
+
+	vr = NewBytesValueReader(data, WithNoCopy())
+	th, length, err := vr.ReadHeader()
+	if err != nil || th != THStringSingle {
+		t.Fatalf("header %s, %v", th, err)
+	}
+	bs, err := vr.ReadBytes(length, nil)
+	if err != nil || string(bs) != "abc" || !aliases(bs, data) || cap(bs) != 3 {
+		t.Fatalf("read bytes got %q (cap %d), err %v", bs, cap(bs), err)
+	}
+	// appending could not overwrite the input
+	_ = append(bs, 'x')
+	if data[4] != 0x05 {
+		t.Fatalf("input overwritten")
+	}
+
+	// unread byte is the first one of ReadBytes
+	if _, err := vr.ReadByte(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vr.(io.ByteScanner).UnreadByte(); err != nil {
+		t.Fatal(err)
+	}
+	if bs, err = vr.ReadBytes(1, nil); err != nil || !bytes.Equal(bs, []byte{0x05}) || !aliases(bs, data) {
+		t.Fatalf("read bytes after unread got %x, err %v", bs, err)
+	}
+	if n, err := vr.Skip(); err != nil || n != 6 {
+		t.Fatalf("skip %d, err %v", n, err)
+	}
+	if offsetOf(vr) != len(data) {
+		t.Fatalf("offset %d, want %d", offsetOf(vr), len(data))
+	}
+	if _, err := vr.ReadByte(); err != io.EOF {
+		t.Fatalf("read at the end got %v", err)
+	}
+
+	// truncated
+	vr = NewBytesValueReader(data[:3])
+	if _, _, err := vr.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vr.ReadBytes(3, nil); err != io.EOF {
+		t.Fatalf("truncated got %v", err)
+	}
+
+	// limits are applied as same as other readers
+	var s string
+	if err := NewDecoder(NewBytesValueReader(data), WithMaxBytes(3)).Decode(&s); !errors.Is(err, ErrExceedMaxBytes) {
+		t.Fatalf("max bytes got %v", err)
+	}
+}
+
+// keptBytes is a Decoder keeping the bytes returned by ReadBytes
+type keptBytes struct {
+	b []byte
+}
+
+func (k *keptBytes) Deserialization(r io.Reader) (bool, error) {
+	vr := ValueReaderOf(r)
+	_, length, err := vr.ReadHeader()
+	if err != nil {
+		return false, err
+	}
+	k.b, err = vr.ReadBytes(length, nil)
+	return false, err
+}
+
+func TestUnmarshalCopies(t *testing.T) {
+	buf, err := Marshal("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := new(keptBytes)
+	if err := Unmarshal(buf, k); err != nil {
+		t.Fatal(err)
+	}
+	for i := range buf {
+		buf[i] = 'X'
+	}
+	if string(k.b) != "hello" {
+		t.Fatalf("decoded bytes changed with the input: %q", k.b)
+	}
+
+	// handlers of EventDecoder
+	buf, _ = Marshal(&simplestruct{A: 1, B: "hello"})
+	got := new(simplestruct)
+	if err := DecodeV2(NewBytesValueReader(buf), got); err != nil {
+		t.Fatal(err)
+	}
+	for i := range buf {
+		buf[i] = 'X'
+	}
+	if got.B != "hello" {
+		t.Fatalf("decoded string changed with the input: %q", got.B)
+	}
+}
+
+type noCopyBlock struct {
+	Number uint64
+	Body   []byte
+	Raw    RawValue
+	Hash   [4]byte
+	Name   string
+}
+
+func TestUnmarshalNoCopy(t *testing.T) {
+	src := &noCopyBlock{
+		Number: 7,
+		Body:   bytes.Repeat([]byte("body"), 1<<18),
+		Raw:    RawValue{0xc3, 'r', 'a', 'w'},
+		Hash:   [4]byte{1, 2, 3, 4},
+		Name:   "block",
+	}
+	buf, err := Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forEachEngine(t, func(t *testing.T) {
+		got := new(noCopyBlock)
+		n := allocated(func() {
+			if err := UnmarshalNoCopy(buf, got); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if !bytes.Equal(got.Body, src.Body) || !bytes.Equal(got.Raw, src.Raw) || got.Hash != src.Hash ||
+			got.Name != src.Name || got.Number != src.Number {
+			t.Fatalf("no copy got %+v", got)
+		}
+		if !aliases(got.Body, buf) || !aliases(got.Raw, buf) {
+			t.Fatalf("no copy should alias the input")
+		}
+		if n > uint64(len(src.Body)/2) {
+			t.Errorf("%d bytes allocated for a %d bytes body", n, len(src.Body))
+		}
+
+		copied := new(noCopyBlock)
+		if err := Unmarshal(buf, copied); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(copied.Body, src.Body) || aliases(copied.Body, buf) || aliases(copied.Raw, buf) {
+			t.Fatalf("unmarshal should copy")
+		}
+	})
+
+	// rtlgen style decoding
+	bs, _ := Marshal([]byte("abcdef"))
+	got, err := DecodeBytes(NewBytesValueReader(bs, WithNoCopy()), nil)
+	if err != nil || string(got) != "abcdef" || !aliases(got, bs) {
+		t.Fatalf("decode bytes got %q, err %v", got, err)
+	}
+}
+
+func TestReadZeros(t *testing.T) {
+	// run of 3 zero values, 0x01
+	vr := NewBytesValueReader([]byte{0x85, 0x03, 0x01})
+	if n, err := ReadZeros(vr); err != nil || n != 3 {
+		t.Fatalf("read zeros got %d, %v", n, err)
+	}
+	if n, err := ReadZeros(vr); err != nil || n != 0 {
+		t.Fatalf("read zeros of a value got %d, %v", n, err)
+	}
+	if b, err := vr.ReadByte(); err != nil || b != 0x01 {
+		t.Fatalf("header should be unread, got %x, %v", b, err)
+	}
+	if n, err := ReadZeros(vr); err != nil || n != 0 {
+		t.Fatalf("read zeros at the end got %d, %v", n, err)
+	}
+
+	// the header could not be peeked without UnreadByte
+	noPeek := struct{ ValueReader }{NewBytesValueReader([]byte{0x85, 0x03})}
+	if _, err := ReadZeros(noPeek); err == nil {
+		t.Fatal("read zeros without io.ByteScanner should fail")
+	}
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Visitor receives the values of an RTL stream walked by Walk, without any Go type. The byte
+// slices passed to the callbacks are only valid during the call (they may alias the buffer of
+// the reader), copy them if they are needed later. An error returned by a callback stops the
+// walking, and is returned by Walk as it is.
+type Visitor interface {
+	// EnterArray is called on the header of an array of n items, followed by the callbacks of
+	// the items and LeaveArray. Returns SkipArray to skip the items, LeaveArray will not be
+	// called then.
+	EnterArray(ctx *WalkContext, n int) error
+	LeaveArray(ctx *WalkContext) error
+	// Number is called with the big-endian bytes of the absolute value of an integer
+	Number(ctx *WalkContext, negative bool, bs []byte) error
+	String(ctx *WalkContext, bs []byte) error
+	Zero(ctx *WalkContext) error
+	True(ctx *WalkContext) error
+	Empty(ctx *WalkContext) error
+	// Byte is called with a single byte value (0-127)
+	Byte(ctx *WalkContext, b byte) error
+	// Version is called on the version prefix of the next value (a struct)
+	Version(ctx *WalkContext, version int) error
+	// Zeros is called on a run of n zero values in an array, which is one item of the array
+	// but covers n indexes
+	Zeros(ctx *WalkContext, n int) error
+}
+
+// SkipArray could be returned by Visitor.EnterArray to skip the items of the array
+var SkipArray = errors.New("rtl: skip this array")
+
+// walkFrame is an array being walked
+type walkFrame struct {
+	offset int // offset of the array header
+	size   int // number of items
+	item   int // index of the current item
+	index  int // index of the current element, a run of zero values covers many elements
+}
+
+// WalkContext is the state of Walk passed to the Visitor callbacks
+type WalkContext struct {
+	vr     ValueReader
+	opts   *Options
+	offset int
+	stack  []walkFrame
+}
+
+// Offset returns the offset of the header of the current value, -1 if the reader could not tell
+func (c *WalkContext) Offset() int {
+	return c.offset
+}
+
+// Depth returns the number of arrays enclosing the current value
+func (c *WalkContext) Depth() int {
+	return len(c.stack)
+}
+
+// Index returns the index of the current value in the innermost array, -1 at the top level
+func (c *WalkContext) Index() int {
+	if len(c.stack) == 0 {
+		return -1
+	}
+	return c.stack[len(c.stack)-1].index
+}
+
+// Path returns the indexes of the current value in the enclosing arrays, e.g. [3][0]
+func (c *WalkContext) Path() string {
+	buf := new(strings.Builder)
+	for _, f := range c.stack {
+		buf.WriteByte('[')
+		buf.WriteString(strconv.Itoa(f.index))
+		buf.WriteByte(']')
+	}
+	return buf.String()
+}
+
+// Walk reads the next value from r and calls the callbacks of v for it and all the values nested
+// in it, in the order of the stream. io.EOF is returned if there's no more value in r. Reuse the
+// ValueReader as r to walk more values in the same stream.
+func Walk(r io.Reader, v Visitor) error {
+	if v == nil {
+		return errors.New("rtl: nil Visitor")
+	}
+	vr := ValueReaderOf(r)
+	ctx := &WalkContext{vr: vr, opts: optionsOf(vr)}
+	start := offsetOf(vr)
+	for {
+		th, err := ctx.value(v)
+		if err != nil {
+			if _, ok := err.(*DecodeError); !ok {
+				// error of the Visitor
+				return err
+			}
+			if len(ctx.stack) == 0 && th == THInvalid && errors.Is(err, io.EOF) && offsetOf(vr) == start {
+				return io.EOF
+			}
+			return truncatedError(err, start, offsetOf(vr))
+		}
+		for len(ctx.stack) > 0 {
+			top := &ctx.stack[len(ctx.stack)-1]
+			top.item++
+			top.index++
+			if top.item < top.size {
+				break
+			}
+			ctx.offset = top.offset
+			ctx.stack = ctx.stack[:len(ctx.stack)-1]
+			if err := v.LeaveArray(ctx); err != nil {
+				return err
+			}
+		}
+		if len(ctx.stack) == 0 {
+			return nil
+		}
+	}
+}
+
+func (c *WalkContext) decodeError(th TypeHeader, err error) error {
+	return &DecodeError{Offset: c.offset, Path: c.Path(), Header: th, Err: err}
+}
+
+// header reads the header and the length of a multi-bytes header, which is not checked with the
+// bytes left in the reader (that's done when reading the bytes of the value).
+func (c *WalkContext) header() (TypeHeader, int, error) {
+	c.offset = offsetOf(c.vr)
+	th, length, err := c.vr.ReadHeader()
+	if err != nil {
+		return THInvalid, 0, err
+	}
+	if vt, _ := th.ValueType(); vt == THVTMultiHeader {
+		l, err := ReadMultiLengthFromReader(c.vr, length)
+		if err != nil {
+			return THInvalid, 0, err
+		}
+		if l > math.MaxInt32 {
+			return THInvalid, 0, fmt.Errorf("%w: length %d", ErrTooLarge, l)
+		}
+		length = int(l)
+	}
+	return th, length, nil
+}
+
+// value reads the next value, and returns the header of it. The items of an array are read by
+// the following calls.
+func (c *WalkContext) value(v Visitor) (TypeHeader, error) {
+	th, length, err := c.header()
+	if err != nil {
+		return THInvalid, c.decodeError(THInvalid, err)
+	}
+	if th.IsVersion() {
+		version := length
+		if th == THVersionSingle {
+			buf, err := c.vr.ReadBytes(length, nil)
+			if err != nil {
+				return th, c.decodeError(th, err)
+			}
+			version = versionNumber(buf)
+		}
+		if err := v.Version(c, version); err != nil {
+			return th, err
+		}
+		next, n, err := c.header()
+		if err != nil {
+			return th, c.decodeError(THInvalid, err)
+		}
+		th, length = next, n
+		if err := checkVersionFollowed(th); err != nil {
+			return th, c.decodeError(th, err)
+		}
+	}
+
+	switch th {
+	case THSingleByte:
+		return th, v.Byte(c, byte(length))
+	case THZeroValue:
+		return th, v.Zero(c)
+	case THTrue:
+		return th, v.True(c)
+	case THEmpty:
+		return th, v.Empty(c)
+	case THPosNumSingle, THNegNumSingle, THPosBigInt, THNegBigInt:
+		buf, err := c.vr.ReadBytes(length, nil)
+		if err != nil {
+			return th, c.decodeError(th, err)
+		}
+		return th, v.Number(c, th == THNegNumSingle || th == THNegBigInt, buf)
+	case THStringSingle, THStringMulti:
+		buf, err := c.vr.ReadBytes(length, nil)
+		if err != nil {
+			return th, c.decodeError(th, err)
+		}
+		return th, v.String(c, buf)
+	case THZeros:
+		buf, err := c.vr.ReadBytes(length, nil)
+		if err != nil {
+			return th, c.decodeError(th, err)
+		}
+		count := Numeric.BytesToUint64(buf)
+		if count == 0 || count > math.MaxInt32 {
+			return th, c.decodeError(th, fmt.Errorf("%w: %d zero values in a run", ErrLength, count))
+		}
+		if err := v.Zeros(c, int(count)); err != nil {
+			return th, err
+		}
+		if len(c.stack) > 0 {
+			// the run covers count indexes, the last one is counted by the caller
+			c.stack[len(c.stack)-1].index += int(count) - 1
+		}
+		return th, nil
+	case THArraySingle, THArrayMulti:
+		if err := c.opts.checkLength(length); err != nil {
+			return th, c.decodeError(th, err)
+		}
+		if err := v.EnterArray(c, length); err != nil {
+			if err != SkipArray {
+				return th, err
+			}
+			for i := 0; i < length; i++ {
+				if _, err := c.vr.Skip(); err != nil {
+					return th, c.decodeError(th, err)
+				}
+			}
+			return th, nil
+		}
+		if length == 0 {
+			return th, v.LeaveArray(c)
+		}
+		if err := c.opts.checkNesting(len(c.stack) + 1); err != nil {
+			return th, c.decodeError(th, err)
+		}
+		// item and index are increased by the caller before reading the first item
+		c.stack = append(c.stack, walkFrame{offset: c.offset, size: length, item: -1, index: -1})
+		return th, nil
+	}
+	return th, c.decodeError(th, ErrUnsupported)
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright 2024 Stephen Guo (stephen.fire@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rtl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// walkRecorder records the callbacks as lines of "path@offset event"
+type walkRecorder struct {
+	buf  strings.Builder
+	skip string // path of the array to be skipped, with the prefix "$"
+}
+
+func (r *walkRecorder) line(ctx *WalkContext, format string, args ...interface{}) error {
+	fmt.Fprintf(&r.buf, "%s@%d %s\n", ctx.Path(), ctx.Offset(), fmt.Sprintf(format, args...))
+	return nil
+}
+
+func (r *walkRecorder) EnterArray(ctx *WalkContext, n int) error {
+	r.line(ctx, "enter %d", n)
+	if "$"+ctx.Path() == r.skip {
+		return SkipArray
+	}
+	return nil
+}
+
+func (r *walkRecorder) LeaveArray(ctx *WalkContext) error { return r.line(ctx, "leave") }
+func (r *walkRecorder) Number(ctx *WalkContext, negative bool, bs []byte) error {
+	return r.line(ctx, "number %t %x", negative, bs)
+}
+func (r *walkRecorder) String(ctx *WalkContext, bs []byte) error { return r.line(ctx, "string %q", bs) }
+func (r *walkRecorder) Zero(ctx *WalkContext) error              { return r.line(ctx, "zero") }
+func (r *walkRecorder) True(ctx *WalkContext) error              { return r.line(ctx, "true") }
+func (r *walkRecorder) Empty(ctx *WalkContext) error             { return r.line(ctx, "empty") }
+func (r *walkRecorder) Byte(ctx *WalkContext, b byte) error      { return r.line(ctx, "byte %d", b) }
+func (r *walkRecorder) Version(ctx *WalkContext, version int) error {
+	return r.line(ctx, "version %d", version)
+}
+func (r *walkRecorder) Zeros(ctx *WalkContext, n int) error { return r.line(ctx, "zeros %d", n) }
+
+func hexStream(t *testing.T, s string) []byte {
+	bs, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bs
+}
+
+func TestWalk(t *testing.T) {
+	tests := []struct {
+		stream string
+		skip   string
+		want   string
+	}{
+		{"05", "", "@0 byte 5\n"},
+		{"f3 92 01 02", "", "@0 version 3\n@1 enter 2\n[0]@2 byte 1\n[1]@3 byte 2\n@1 leave\n"},
+		{"89 00", "", "@0 enter 0\n@0 leave\n"},
+		{"93 81 c3 616263 92 a2 0100 ac 80000000", "",
+			"@0 enter 3\n[0]@1 true\n[1]@2 string \"abc\"\n[2]@6 enter 2\n[2][0]@7 number false 0100\n" +
+				"[2][1]@10 number true 80000000\n[2]@6 leave\n@0 leave\n"},
+		{"93 01 85 04 41", "", "@0 enter 3\n[0]@1 byte 1\n[1]@2 zeros 4\n[5]@4 byte 65\n@0 leave\n"},
+		{"92 92 01 02 82", "$[0]", "@0 enter 2\n[0]@1 enter 2\n[1]@4 empty\n@0 leave\n"},
+		{"92 01 02", "$", "@0 enter 2\n"},
+	}
+	for _, test := range tests {
+		r := &walkRecorder{skip: test.skip}
+		bs := hexStream(t, test.stream)
+		if err := Walk(bytes.NewReader(bs), r); err != nil {
+			t.Errorf("walk %s failed: %v", test.stream, err)
+			continue
+		}
+		if r.buf.String() != test.want {
+			t.Errorf("walk %s got:\n%s\nwant:\n%s", test.stream, r.buf.String(), test.want)
+		}
+	}
+}
+
+// walkTranscoder re-encodes the walked values by a TokenWriter
+type walkTranscoder struct {
+	w *TokenWriter
+}
+
+func (c walkTranscoder) EnterArray(_ *WalkContext, n int) error {
+	if n == 0 {
+		return c.w.WriteEmpty()
+	}
+	return c.w.WriteArrayHeader(n)
+}
+func (c walkTranscoder) LeaveArray(_ *WalkContext) error { return nil }
+func (c walkTranscoder) Number(_ *WalkContext, negative bool, bs []byte) error {
+	i := new(big.Int).SetBytes(bs)
+	if negative {
+		i.Neg(i)
+	}
+	return c.w.WriteBigInt(i)
+}
+func (c walkTranscoder) String(_ *WalkContext, bs []byte) error { return c.w.WriteBytes(bs) }
+func (c walkTranscoder) Zero(_ *WalkContext) error              { return c.w.WriteZero() }
+func (c walkTranscoder) True(_ *WalkContext) error              { return c.w.WriteBool(true) }
+func (c walkTranscoder) Empty(_ *WalkContext) error             { return c.w.WriteEmpty() }
+func (c walkTranscoder) Byte(_ *WalkContext, b byte) error      { return c.w.WriteUint(uint64(b)) }
+func (c walkTranscoder) Version(_ *WalkContext, version int) error {
+	return c.w.WriteVersion(uint64(version))
+}
+func (c walkTranscoder) Zeros(_ *WalkContext, n int) error {
+	return writeErr(zerosPlacehold(c.w, n, true))
+}
+
+func TestWalkTranscode(t *testing.T) {
+	for _, test := range encTests {
+		if _, ok := test.val.(Encoder); ok {
+			continue
+		}
+		bs, err := Marshal(test.val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := new(bytes.Buffer)
+		if err := Walk(bytes.NewReader(bs), walkTranscoder{w: NewTokenWriter(out)}); err != nil {
+			t.Errorf("walk %x failed: %v", bs, err)
+			continue
+		}
+		if !bytes.Equal(out.Bytes(), bs) {
+			t.Errorf("transcode %x got %x", bs, out.Bytes())
+		}
+	}
+}
+
+func TestWalkStream(t *testing.T) {
+	vr := NewValueReader(bytes.NewReader(hexStream(t, "05 92 01 02 81")))
+	r := new(walkRecorder)
+	for {
+		if err := Walk(vr, r); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	want := "@0 byte 5\n@1 enter 2\n[0]@2 byte 1\n[1]@3 byte 2\n@1 leave\n@4 true\n"
+	if r.buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", r.buf.String(), want)
+	}
+}
+
+// stopVisitor fails on the first string
+type stopVisitor struct {
+	walkRecorder
+}
+
+var errStopWalking = errors.New("stop")
+
+func (s *stopVisitor) String(_ *WalkContext, _ []byte) error { return errStopWalking }
+
+func TestWalkErrors(t *testing.T) {
+	tests := []struct {
+		stream string
+		opts   []Option
+		want   error
+		path   string
+	}{
+		{"92 01", nil, io.ErrUnexpectedEOF, "[1]"},
+		{"92 01 83", nil, ErrUnsupported, "[1]"},
+		{"f1 f1 80", nil, nil, ""},
+		{"91 91 91 01", []Option{WithMaxNested(2)}, ErrNestingOverflow, "[0][0]"},
+		{"89 ff", []Option{WithMaxSliceSize(100)}, ErrTooLarge, ""},
+		{"91 85 00", nil, ErrLength, "[0]"},
+	}
+	for _, test := range tests {
+		vr := NewBytesValueReader(hexStream(t, test.stream), test.opts...)
+		err := Walk(vr, new(walkRecorder))
+		if err == nil {
+			t.Errorf("walk %s should fail", test.stream)
+			continue
+		}
+		if test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("walk %s got %v, want %v", test.stream, err, test.want)
+		}
+		var de *DecodeError
+		if !errors.As(err, &de) || de.Path != test.path {
+			t.Errorf("walk %s got %v, want path %q", test.stream, err, test.path)
+		}
+	}
+
+	if err := Walk(bytes.NewReader(nil), new(walkRecorder)); err != io.EOF {
+		t.Errorf("walk empty stream got %v", err)
+	}
+	err := Walk(bytes.NewReader(hexStream(t, "92 01 c3 616263")), new(stopVisitor))
+	if err != errStopWalking {
+		t.Errorf("error of visitor got %v", err)
+	}
+	vr := NewValueReader(bytes.NewReader(hexStream(t, "05")))
+	if err := Walk(vr, nil); err == nil {
+		t.Errorf("walk with nil visitor should fail")
+	}
+	if offsetOf(vr) != 0 {
+		t.Errorf("walk with nil visitor read %d bytes", offsetOf(vr))
+	}
+}
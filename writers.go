@@ -17,10 +17,12 @@
 package rtl
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"math"
 	"reflect"
+	"sort"
 )
 
 // writerFunc encode v as bytes write to w, returns the length of bytes it write
@@ -31,71 +33,63 @@ func valueWriter(w io.Writer, value reflect.Value) (int, error) {
 }
 
 func valueWriter0(w io.Writer, value reflect.Value, nesting int) (int, error) {
-	if nesting > MaxNested {
-		return 0, ErrNestingOverflow
+	if !value.IsValid() {
+		// zero Value, do nothing
+		return 0, nil
 	}
+	return codecOf(value.Type()).encodeValue(w, value, nesting)
+}
 
-	typ := value.Type()
+// scratchHolder is a writer with a scratch buffer for headers, see headerBuf
+type scratchHolder interface {
+	scratch() []byte
+}
 
-	if typ.Implements(TypeOfEncoder) {
-		// if the object can be serialized by itself
-		encoder, _ := value.Interface().(Encoder)
-		return 0, encoder.Serialization(w)
+// headerBuf returns the scratch buffer (at least 9 bytes) of w for the header being written, or a
+// new one if w has no scratch. The scratch could be reused once written, because io.Writer must
+// not retain the bytes.
+func headerBuf(w io.Writer) []byte {
+	if holder, ok := w.(scratchHolder); ok {
+		return holder.scratch()
 	}
+	return make([]byte, 9)
+}
+
+// writeByte writes one byte, such as a single byte value
+func writeByte(w io.Writer, b byte) (int, error) {
+	buf := headerBuf(w)
+	buf[0] = b
+	return w.Write(buf[:1])
+}
 
-	if matched, n, err := checkPriorStructsWriter(w, value); err != nil {
-		return n, err
-	} else if matched {
-		return n, err
+// writeArrayHeader writes the header of an array with length (>0) elements
+func writeArrayHeader(w io.Writer, length int) (int, error) {
+	buf := headerBuf(w)
+	l, err := HeadMaker.arrayBuf(length, buf)
+	if err != nil {
+		return 0, err
 	}
+	return w.Write(buf[:l])
+}
 
-	kind := value.Kind()
-	switch kind {
-	case reflect.Invalid:
-		// zero Value, do nothing
-		return 0, nil
-		// return w.Write(zeroValues)
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return intWriter(w, value)
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return uintWriter(w, value)
-	case reflect.Float32:
-		return float32Writer(w, value)
-	case reflect.Float64:
-		return float64Writer(w, value)
-	case reflect.Bool:
-		return boolWriter(w, value)
-	case reflect.String:
-		return stringWriter(w, value)
-	case reflect.Array:
-		if typ.Elem().Kind() == reflect.Uint8 {
-			// byte array
-			return byteArrayWriter(w, value)
-		} else {
-			// other type of array, nesting++
-			return arrayWriter0(w, value, nesting)
-		}
-	case reflect.Slice:
-		if typ.Elem().Kind() == reflect.Uint8 {
-			// byte slice(array)
-			return byteSliceWriter(w, value)
-		} else {
-			// other type of slice(array), nesting++
-			return sliceWriter0(w, value, nesting)
-		}
-	case reflect.Map:
-		// nesting++
-		return mapWriter0(w, value, nesting)
-	case reflect.Struct:
-		// nesting++
-		return structWriter0(w, value, nesting)
-	case reflect.Ptr:
-		return pointerWriter0(w, value, nesting)
-	case reflect.Interface:
-		return interfaceWriter0(w, value, nesting)
-	default:
-		return 0, fmt.Errorf("unsupported type %v for encoding", value.Type())
+// writeVersionHeader writes the struct version prefix
+func writeVersionHeader(w io.Writer, version uint64) (int, error) {
+	buf := headerBuf(w)
+	l, err := HeadMaker.versionBuf(version, buf)
+	if err != nil {
+		return 0, err
 	}
+	return w.Write(buf[:l])
+}
+
+// writeStringHeader writes the header of a string with length (>0) bytes
+func writeStringHeader(w io.Writer, length int) (int, error) {
+	buf := headerBuf(w)
+	l, err := HeadMaker.stringBuf(length, buf)
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(buf[:l])
 }
 
 func bytesWriter(w io.Writer, bs []byte) (int, error) {
@@ -105,38 +99,41 @@ func bytesWriter(w io.Writer, bs []byte) (int, error) {
 	if len(bs) == 0 {
 		return w.Write(emptyValues)
 	}
-	var ret int = 0
 	if len(bs) == 1 && bs[0] <= 127 {
 		// single byte
 		return w.Write(bs)
 	}
 	// multi bytes
-	h, err := HeadMaker.string(len(bs))
-	if err != nil {
-		return ret, err
-	}
-	if h == nil {
-		// should not be here
-		return w.Write(zeroValues)
-	}
-	n, err := w.Write(h)
-	ret += n
+	ret, err := writeStringHeader(w, len(bs))
 	if err != nil {
 		return ret, err
 	}
-	n, err = w.Write(bs)
+	n, err := w.Write(bs)
 	ret += n
-	return ret, nil
+	return ret, err
 }
 
 func stringWriter(w io.Writer, v reflect.Value) (int, error) {
-	s := v.String()
+	return writeString(w, v.String())
+}
+
+func writeString(w io.Writer, s string) (int, error) {
 	if s == "" {
 		// empty string
 		return w.Write(zeroValues)
 	}
-	str := []byte(s)
-	return bytesWriter(w, str)
+	if len(s) == 1 && s[0] <= 127 {
+		// single byte
+		return writeByte(w, s[0])
+	}
+	// multi bytes, written without converting to []byte if w is an io.StringWriter
+	ret, err := writeStringHeader(w, len(s))
+	if err != nil {
+		return ret, err
+	}
+	n, err := io.WriteString(w, s)
+	ret += n
+	return ret, err
 }
 
 func byteSliceWriter(w io.Writer, v reflect.Value) (int, error) {
@@ -172,11 +169,11 @@ func smallNumberWriter(w io.Writer, isNegative bool, i uint64) (int, error) {
 
 	// single byte value
 	if isNegative == false && i <= 127 {
-		return w.Write([]byte{byte(i)})
+		return writeByte(w, byte(i))
 	}
 
 	// header(1byte) + value(max 8bytes) = max 9bytes
-	buf := make([]byte, 9)
+	buf := headerBuf(w)
 
 	// value
 	l, err := Numeric.writeUint(buf[1:], i)
@@ -228,7 +225,7 @@ func float64Writer(w io.Writer, v reflect.Value) (int, error) {
 	return smallNumberWriter(w, neg, u64)
 }
 
-func arrayWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
+func arrayWriter0(w io.Writer, v reflect.Value, ecodec *typeCodec, nesting int) (int, error) {
 	length := v.Len()
 	if length <= 0 {
 		if v.Kind() == reflect.Slice {
@@ -238,24 +235,20 @@ func arrayWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
 	}
 
 	// array would +1 to nesting, so equals to MaxNested is overflowed
-	if nesting >= MaxNested {
-		return 0, ErrNestingOverflow
-	}
-
-	h, err := HeadMaker.array(length)
-	if err != nil {
+	if err := optionsOf(w).checkNesting(nesting + 1); err != nil {
 		return 0, err
 	}
-	ret, err := w.Write(h)
+
+	ret, err := writeArrayHeader(w, length)
 	if err != nil {
-		return ret, nil
+		return ret, err
 	}
 
 	// nesting elements
 	nesting++
 	for i := 0; i < length; i++ {
 		vv := v.Index(i)
-		n, err := valueWriter0(w, vv, nesting)
+		n, err := ecodec.encodeValue(w, vv, nesting)
 		ret += n
 		if err != nil {
 			return ret, err
@@ -266,21 +259,21 @@ func arrayWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
 }
 
 func arrayWriter(w io.Writer, v reflect.Value) (int, error) {
-	return arrayWriter0(w, v, 0)
+	return arrayWriter0(w, v, codecOf(v.Type().Elem()), 0)
 }
 
-func sliceWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
+func sliceWriter0(w io.Writer, v reflect.Value, ecodec *typeCodec, nesting int) (int, error) {
 	if v.IsNil() {
 		return w.Write(zeroValues)
 	}
-	return arrayWriter0(w, v, nesting)
+	return arrayWriter0(w, v, ecodec, nesting)
 }
 
 func sliceWriter(w io.Writer, v reflect.Value) (int, error) {
-	return sliceWriter0(w, v, 0)
+	return sliceWriter0(w, v, codecOf(v.Type().Elem()), 0)
 }
 
-func mapWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
+func mapWriter0(w io.Writer, v reflect.Value, kcodec, vcodec *typeCodec, nesting int) (int, error) {
 	if v.IsNil() {
 		return w.Write(zeroValues)
 	}
@@ -291,31 +284,31 @@ func mapWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
 	}
 
 	// map would +1 to nesting, so equals to MaxNested is overflowed
-	if nesting >= MaxNested {
-		return 0, ErrNestingOverflow
+	if err := optionsOf(w).checkNesting(nesting + 1); err != nil {
+		return 0, err
 	}
 
 	length := len(keys)
 	length <<= 1
-	h, err := HeadMaker.array(length)
-	if err != nil {
-		return 0, err
-	}
-	ret, err := w.Write(h)
+	ret, err := writeArrayHeader(w, length)
 	if err != nil {
 		return ret, err
 	}
 
 	// nesting elements
 	nesting++
+	if opts := optionsOf(w); opts.Canonical {
+		n, err := canonicalMapEntriesWriter(w, opts, v, keys, vcodec, nesting)
+		return ret + n, err
+	}
 	for _, key := range keys {
 		value := v.MapIndex(key)
-		n, err := valueWriter0(w, key, nesting)
+		n, err := kcodec.encodeValue(w, key, nesting)
 		ret += n
 		if err != nil {
 			return ret, err
 		}
-		n, err = valueWriter0(w, value, nesting)
+		n, err = vcodec.encodeValue(w, value, nesting)
 		ret += n
 		if err != nil {
 			return ret, err
@@ -324,12 +317,100 @@ func mapWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
 	return ret, nil
 }
 
+// canonicalMapEntriesWriter writes map entries in the order of the encoded bytes of their keys
+func canonicalMapEntriesWriter(w io.Writer, opts *Options, v reflect.Value, keys []reflect.Value,
+	vcodec *typeCodec, nesting int) (int, error) {
+	type entry struct {
+		key   []byte
+		value reflect.Value
+	}
+	entries := make([]entry, len(keys))
+	for i, key := range keys {
+		kbytes, err := encodeMapKey(opts, key, nesting)
+		if err != nil {
+			return 0, err
+		}
+		entries[i] = entry{key: kbytes, value: v.MapIndex(key)}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	ret := 0
+	for _, e := range entries {
+		n, err := w.Write(e.key)
+		ret += n
+		if err != nil {
+			return ret, err
+		}
+		n, err = vcodec.encodeValue(w, e.value, nesting)
+		ret += n
+		if err != nil {
+			return ret, err
+		}
+	}
+	return ret, nil
+}
+
+// encodeMapKey returns the encoded bytes of the map key, which decide the order of entries
+// in canonical mode
+func encodeMapKey(opts *Options, key reflect.Value, nesting int) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if _, err := valueWriter0(&optionsWriter{Writer: buf, opts: opts}, key, nesting); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkCanonicalKey checks whether the encoded bytes of the key are greater than the previous
+// key's in the same map, returns the encoded bytes of the key if it is. raw is the bytes of the
+// key read from the stream (nil if not recorded), which must be the canonical encoding of it.
+func checkCanonicalKey(opts *Options, prev []byte, key reflect.Value, raw []byte) ([]byte, error) {
+	kbytes, err := encodeMapKey(opts, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	if raw != nil && !bytes.Equal(raw, kbytes) {
+		return nil, fmt.Errorf("%w: key %x should be encoded as %x", ErrNonCanonical, raw, kbytes)
+	}
+	if prev != nil {
+		switch c := bytes.Compare(prev, kbytes); {
+		case c == 0:
+			return nil, fmt.Errorf("%w: duplicated key %x", ErrNonCanonical, kbytes)
+		case c > 0:
+			return nil, fmt.Errorf("%w: key %x should not be after key %x", ErrNonCanonical, kbytes, prev)
+		}
+	}
+	return kbytes, nil
+}
+
 func mapWriter(w io.Writer, v reflect.Value) (int, error) {
-	return mapWriter0(w, v, 0)
+	c := codecOf(v.Type())
+	return mapWriter0(w, v, c.child(0), c.child(1), 0)
+}
+
+// selfCoded returns true if typ (or *typ) is an Encoder or Decoder
+func selfCoded(typ reflect.Type) bool {
+	ptr := reflect.PtrTo(typ)
+	return typ.Implements(TypeOfEncoder) || ptr.Implements(TypeOfEncoder) ||
+		typ.Implements(TypeOfDecoder) || ptr.Implements(TypeOfDecoder)
 }
 
-// TODO: 当count很大时，可以考虑用特殊字节代表多个zero
-func zerosPlacehold(w io.Writer, count int) (int, error) {
+// minZeroRun is the min number of skipped orders written as a run of zero values, which is shorter
+// than writing the zero values one by one
+const minZeroRun = 3
+
+// zerosPlacehold writes count zero values as the placeholders of the orders skipped by the fields
+// of a struct, as a run of zero values if compact and count >= minZeroRun.
+func zerosPlacehold(w io.Writer, count int, compact bool) (int, error) {
+	if compact && count >= minZeroRun {
+		buf := headerBuf(w)
+		l, err := HeadMaker.zerosBuf(count, buf)
+		if err != nil {
+			return 0, err
+		}
+		return w.Write(buf[:l])
+	}
 	for i := 0; i < count; i++ {
 		_, err := w.Write(zeroValues)
 		if err != nil {
@@ -339,9 +420,33 @@ func zerosPlacehold(w io.Writer, count int) (int, error) {
 	return count, nil
 }
 
-func structWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
-	typ := v.Type()
-	fnum, fnames := structFields(typ)
+// compactFields omits the trailing fields of zero values, which would be set to zero values when
+// decoding. The fields of Encoder/Decoder types are kept, because their zero values may not be
+// encoded as zero values. It returns the number of elements and the fields left in compact zeros mode.
+func compactFields(val reflect.Value, fields []fieldName) (int, []fieldName) {
+	i := len(fields)
+	for ; i > 0; i-- {
+		fv := val.Field(fields[i-1].index)
+		if !fv.IsZero() || selfCoded(fv.Type()) {
+			break
+		}
+	}
+	fields = fields[:i]
+	items, order := 0, -1
+	for _, f := range fields {
+		if gap := f.order - order - 1; gap >= minZeroRun {
+			items++
+		} else {
+			items += gap
+		}
+		items++
+		order = f.order
+	}
+	return items, fields
+}
+
+func structWriter0(w io.Writer, v reflect.Value, c *typeCodec, nesting int) (int, error) {
+	fnum, fnames := structFields(c.typ)
 
 	if len(fnames) <= 0 {
 		// no available fields in the struct
@@ -349,17 +454,34 @@ func structWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
 	}
 
 	// struct would +1 to nesting, so equals to MaxNested is overflowed
-	if nesting >= MaxNested {
-		return 0, ErrNestingOverflow
+	if err := optionsOf(w).checkNesting(nesting + 1); err != nil {
+		return 0, err
 	}
 
 	fnum, fnames = versionedFields(v, fnames)
+	version := structVersion(fnames)
+	compact := optionsOf(w).CompactZeros
+	if compact {
+		fnum, fnames = compactFields(v, fnames)
+	}
 
-	h, err := HeadMaker.array(fnum)
-	if err != nil {
-		return 0, err
+	ret := 0
+	if version > 0 {
+		// struct version prefix, absent means version 0
+		n, err := writeVersionHeader(w, uint64(version))
+		ret += n
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	if fnum == 0 {
+		// all fields omitted
+		n, err := w.Write(zeroValues)
+		return ret + n, err
 	}
-	ret, err := w.Write(h)
+	n, err := writeArrayHeader(w, fnum)
+	ret += n
 	if err != nil {
 		return ret, err
 	}
@@ -368,10 +490,10 @@ func structWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
 	nesting++
 	order := -1
 	// write all exported fields
-	for _, fname := range fnames {
+	for i, fname := range fnames {
 		if fname.order > order+1 {
 			// 用ZeroValue补足order跳过的字段
-			n, err := zerosPlacehold(w, fname.order-order-1)
+			n, err := zerosPlacehold(w, fname.order-order-1, compact)
 			if err != nil {
 				return 0, err
 			}
@@ -379,7 +501,7 @@ func structWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
 		}
 		order = fname.order
 		vv := v.Field(fname.index)
-		n, err := valueWriter0(w, vv, nesting)
+		n, err := c.child(i).encodeValue(w, vv, nesting)
 		ret += n
 		if err != nil {
 			return ret, err
@@ -390,25 +512,28 @@ func structWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
 }
 
 func structWriter(w io.Writer, v reflect.Value) (int, error) {
-	return structWriter0(w, v, 0)
+	return structWriter0(w, v, codecOf(v.Type()), 0)
 }
 
-func pointerWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
+func pointerWriter0(w io.Writer, v reflect.Value, ecodec *typeCodec, nesting int) (int, error) {
 	if v.IsNil() {
 		return w.Write(zeroValues)
 	}
 
-	return valueWriter0(w, v.Elem(), nesting)
+	return ecodec.encodeValue(w, v.Elem(), nesting)
 }
 
 func pointerWriter(w io.Writer, v reflect.Value) (int, error) {
-	return pointerWriter0(w, v, 0)
+	return pointerWriter0(w, v, codecOf(v.Type().Elem()), 0)
 }
 
 func interfaceWriter0(w io.Writer, v reflect.Value, nesting int) (int, error) {
 	if v.IsNil() {
 		return w.Write(zeroValues)
 	}
+	if optionsOf(w).TypedInterfaces {
+		return typedInterfaceWriter(w, v.Elem(), nesting)
+	}
 	return valueWriter0(w, v.Elem(), nesting)
 }
 
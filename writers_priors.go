@@ -61,33 +61,33 @@ func ConvertiblePtr(src, dest reflect.Type) bool {
 		src.Elem().Kind() == dest.Elem().Kind() && src.ConvertibleTo(dest)
 }
 
-func checkPriorStructsWriter(w io.Writer, v reflect.Value) (matched bool, n int, err error) {
-	typ := v.Type()
+// priorStructWriter returns the writer of the prior type which typ is assignable or convertible to,
+// or nil if there's no such prior type.
+func priorStructWriter(typ reflect.Type) writerFunc {
 	for _, prior := range _writerPriorStructOrder {
+		fn, exist := _priorStructWriters[prior]
+		if !exist {
+			continue
+		}
 		if typ.AssignableTo(prior) {
-			fn, exist := _priorStructWriters[prior]
-			if exist {
-				n, err = fn(w, v)
-				return true, n, err
-			}
+			return fn
 		} else if ConvertibleTo(typ, prior) {
-			fn, exist := _priorStructWriters[prior]
-			if exist {
-				priorVal := v.Convert(prior)
-				n, err = fn(w, priorVal)
-				return true, n, err
+			priorTyp := prior
+			return func(w io.Writer, v reflect.Value) (int, error) {
+				return fn(w, v.Convert(priorTyp))
 			}
 		}
 	}
-	return false, 0, nil
+	return nil
 }
 
 func _writeNumberBytes(w io.Writer, isNegative bool, bs []byte) (int, error) {
-	h, err := HeadMaker.numeric(isNegative, len(bs))
+	buf := headerBuf(w)
+	l, err := HeadMaker.numericBuf(isNegative, len(bs), buf)
 	if err != nil {
 		return 0, err
 	}
-	n, err := w.Write(h)
+	n, err := w.Write(buf[:l])
 	if err != nil {
 		return n, err
 	}
@@ -101,7 +101,7 @@ func writeBigInt(w io.Writer, bi *big.Int) (int, error) {
 	}
 	if !(bi.Sign() < 0) && bi.Cmp(bigint128) < 0 {
 		// 0 < bi <128, use one single byte value
-		return w.Write([]byte{byte(bi.Uint64())})
+		return writeByte(w, byte(bi.Uint64()))
 	}
 
 	// big int
@@ -19,6 +19,7 @@ package rtl
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -95,8 +96,6 @@ func (t *encodeTest) Deserialization(r io.Reader) (shouldBeNil bool, err error)
 
 type namedByteType byte
 
-type RawValue []byte
-
 type simplestruct struct {
 	A uint
 	B string
@@ -323,7 +322,7 @@ var encTests = []param{
 
 	// RawValue
 	{val: RawValue(Unhex("01"))},
-	{val: RawValue(Unhex("82FFFF"))},
+	{val: RawValue(Unhex("9201C26869"))},
 	{val: []RawValue{Unhex("01"), Unhex("02")}},
 
 	// structs
@@ -404,6 +403,10 @@ var encTests = []param{
 }
 
 func TestEncode(t *testing.T) {
+	forEachEngine(t, testEncode)
+}
+
+func testEncode(t *testing.T) {
 	// decoder := new(EventDecoder)
 	for _, test := range encTests {
 		val := reflect.ValueOf(test.val)
@@ -439,6 +442,94 @@ func TestEncode(t *testing.T) {
 	}
 }
 
+func TestSize(t *testing.T) {
+	vals := []interface{}{
+		&compactStruct{A: 1, B: "b", E: 10},
+		&compactStruct{A: 1, C: &compactInner{Y: "y"}},
+		structV20{A: 1, B: "b"},
+		structV20{A: 1},
+		map[string][]uint{"a": {1, 300}, "b": nil, "c": {}},
+		[]*big.Int{nil, big.NewInt(0), big.NewInt(127), big.NewInt(-128), new(big.Int).Lsh(big.NewInt(1), 100)},
+		[]interface{}{nil, "s", uint8(200), -1.5, []byte{0x80}, [1]byte{5}, [0]byte{}, strings.Repeat("x", 300)},
+		_flat,
+	}
+	for _, test := range encTests {
+		vals = append(vals, test.val)
+	}
+	for _, obj := range _objects[:10] {
+		vals = append(vals, obj)
+	}
+	check := func(val interface{}, opts ...Option) {
+		buf := new(bytes.Buffer)
+		if err := NewEncoder(buf, opts...).Encode(val); err != nil {
+			t.Fatal(err)
+		}
+		size, err := Size(val, opts...)
+		if err != nil {
+			t.Fatalf("size of %#v failed: %v", val, err)
+		}
+		if size != buf.Len() {
+			t.Errorf("size of %#v got %d, want %d", val, size, buf.Len())
+		}
+	}
+	for _, val := range vals {
+		check(val)
+		check(val, WithCompactZeros())
+		check(val, WithCanonical())
+	}
+	check(&registryBlock{Tx: &registryCall{Contract: "c"}, Txs: []registryTx{registryTransfer{Amount: 1000}, nil},
+		Any: "any"}, WithTypedInterfaces())
+
+	if _, err := Size(make(chan int)); err == nil {
+		t.Errorf("size of chan should fail")
+	}
+	if _, err := Size([][]int{{1}}, WithMaxNested(1)); !errors.Is(err, ErrNestingOverflow) {
+		t.Errorf("size of nested slice should fail with %v, but got: %v", ErrNestingOverflow, err)
+	}
+}
+
+func TestAppendMarshal(t *testing.T) {
+	prefix := []byte{0x01, 0x02}
+	for _, test := range encTests {
+		bs, err := Marshal(test.val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := AppendMarshal(append([]byte(nil), prefix...), test.val)
+		if err != nil {
+			t.Fatalf("append marshal %#v failed: %v", test.val, err)
+		}
+		// the entries of maps are not in the same order
+		if !bytes.HasPrefix(got, prefix) || len(got) != len(prefix)+len(bs) {
+			t.Errorf("append marshal %#v got %x, want %x", test.val, got, bs)
+		}
+	}
+
+	dst := make([]byte, 0, 16)
+	if got, err := AppendMarshal(dst, make(chan int)); err == nil || len(got) != 0 {
+		t.Errorf("append marshal chan should fail and return dst, but got %x, %v", got, err)
+	}
+
+	type flat struct {
+		A uint64
+		B int
+		C string
+		D []byte
+		E bool
+		F float32
+	}
+	v := &flat{A: 1 << 40, B: -5, C: "not a short string", D: []byte{1, 2, 3}, E: true, F: 1.5}
+	buf := make([]byte, 0, 256)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := AppendMarshal(buf[:0], v); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("append marshal of flat struct allocates %v times", allocs)
+	}
+}
+
 func _valueEqualer(a interface{}, b reflect.Value) bool {
 	switch s := a.(type) {
 	case *time.Time:
@@ -465,6 +556,10 @@ func _valueEqualer(a interface{}, b reflect.Value) bool {
 
 // string -> []int
 func TestStringArray(t *testing.T) {
+	forEachEngine(t, testStringArray)
+}
+
+func testStringArray(t *testing.T) {
 	s := "this is a string"
 	i := make([]int, 0)
 
@@ -527,6 +622,10 @@ type version5 struct {
 }
 
 func TestVersion(t *testing.T) {
+	forEachEngine(t, testVersion)
+}
+
+func testVersion(t *testing.T) {
 	{
 		v1 := &version1{A: 87690, B: 12345}
 		buf := new(bytes.Buffer)